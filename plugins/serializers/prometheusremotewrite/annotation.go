@@ -0,0 +1,90 @@
+package prometheusremotewrite
+
+import (
+	"strings"
+	"time"
+
+	"github.com/prometheus/prometheus/prompb"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/plugins/serializers/prometheus"
+)
+
+// convertAnnotation adds the series of the metric to the conversion if the
+// metric is an annotation converted according to the annotation policy. It
+// returns false if the metric must be converted as usual.
+func (s *Serializer) convertAnnotation(c *conversion, metric telegraf.Metric, labels []prompb.Label, metricTime time.Time) bool {
+	if s.AnnotationPolicy != "presence" && s.AnnotationPolicy != "info" && s.AnnotationPolicy != "exemplar" {
+		return false
+	}
+	if !s.isAnnotation(metric) {
+		return false
+	}
+
+	metricName, ok := s.sanitizeMetricName(s.caseMetricName(metric.Name()))
+	if !ok {
+		s.traceAndKeepErr(c, "failed to parse metric name %q", metric.Name())
+		return true
+	}
+	if s.AnnotationPolicy == "info" && !strings.HasSuffix(metricName, "_info") {
+		metricName += "_info"
+	}
+	metrickey, ts := s.annotationTS(metricName, labels, metric, metricTime)
+	if m, ok := c.entries[metrickey]; ok && sampleTime(&ts.TimeSeries) < sampleTime(&m.TimeSeries) {
+		s.traceAndKeepErr(c, "metric %q has samples with timestamp %v older than already registered before", metric.Name(), metricTime)
+		return true
+	}
+	c.entries[metrickey] = ts
+	return true
+}
+
+// isAnnotation returns true if the metric has no fields with sample values,
+// i.e. only carries tags, string fields and a timestamp.
+func (s *Serializer) isAnnotation(metric telegraf.Metric) bool {
+	for _, field := range metric.FieldList() {
+		if s.isExemplarField(field.Key) || s.isFieldTimestamp(metric, field.Key) {
+			continue
+		}
+		if _, ok := field.Value.(string); ok {
+			if _, ok := s.parseStringNumber(field.Value); !ok {
+				continue
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// annotationTS converts an annotation metric either into a gauge or an info
+// series with value one or into a series carrying an exemplar only. The
+// exemplar holds the string fields of the metric as labels.
+func (s *Serializer) annotationTS(name string, labels []prompb.Label, metric telegraf.Metric, timestamp time.Time) (MetricKey, timeSeries) {
+	metrickey, promts := getPromTS(name, labels, 1, timestamp)
+	metadata := prompb.MetricMetadata{
+		Type:             prompb.MetricMetadata_GAUGE,
+		MetricFamilyName: name,
+	}
+	if s.AnnotationPolicy == "info" {
+		metadata.Type = prompb.MetricMetadata_INFO
+	}
+	if s.AnnotationPolicy != "exemplar" {
+		return metrickey, timeSeries{TimeSeries: promts, metadata: metadata}
+	}
+
+	exemplar := prompb.Exemplar{Value: 1, Timestamp: promts.Samples[0].Timestamp}
+	for _, field := range metric.FieldList() {
+		value, ok := field.Value.(string)
+		if !ok {
+			continue
+		}
+		labelName, ok := prometheus.SanitizeLabelName(field.Key)
+		if !ok || hasLabel(labelName, labels) {
+			continue
+		}
+		exemplar.Labels = append(exemplar.Labels, prompb.Label{Name: labelName, Value: value})
+	}
+	promts.Samples = nil
+	promts.Exemplars = []prompb.Exemplar{exemplar}
+	metadata.Type = prompb.MetricMetadata_UNKNOWN
+	return metrickey, timeSeries{TimeSeries: promts, metadata: metadata}
+}
@@ -0,0 +1,145 @@
+package prometheusremotewrite
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+
+	"github.com/prometheus/prometheus/prompb"
+
+	"github.com/influxdata/telegraf"
+)
+
+// AppendToRequest converts the given metric into Prometheus series and adds
+// them to the given request in place. Series already contained in the request
+// are replaced if the new sample is not older than the existing one, so
+// appending metrics one-by-one results in the same series as serializing them
+// as a batch.
+// The series are processed like the series of a batch, e.g. deduplicated
+// across batches, labelled with the series ID, protocol version or write ID,
+// where the write ID identifies the series added by a single call. Options
+// ordering the series are applied with respect to the series in the request,
+// i.e. sorting keeps a sorted request sorted, buckets are inserted in order
+// of their boundary and the series limit per metric name drops or rejects
+// series not fitting into the request anymore. Meta-series such as the
+// build-information or heartbeat series are never added as those are emitted
+// once per batch by SerializeBatch only. Please note that summaries can only
+// be converted to histograms if the count is part of the same metric.
+func (s *Serializer) AppendToRequest(req *prompb.WriteRequest, m telegraf.Metric) error {
+	if req == nil {
+		return errors.New("request is nil")
+	}
+	if s.Protocol == "2.0" {
+		return errors.New("appending to a request is not supported for remote write protocol 2.0")
+	}
+
+	update := newStateUpdate()
+	series, _, err := s.convert(context.Background(), []telegraf.Metric{m}, nil, update)
+	if err != nil {
+		return err
+	}
+	if series, _, err = s.processSeries(series, false, update); err != nil {
+		return err
+	}
+	s.applyState(update)
+
+	index := make(map[MetricKey]int, len(req.Timeseries))
+	counts := make(map[string]int)
+	for i, ts := range req.Timeseries {
+		index[MakeMetricKey(ts.Labels)] = i
+		counts[seriesName(ts.Labels)]++
+	}
+
+	for _, ts := range series {
+		if i, found := index[MakeMetricKey(ts.Labels)]; found {
+			if ts.placeholder {
+				continue
+			}
+			if sampleTime(&ts.TimeSeries) < sampleTime(&req.Timeseries[i]) {
+				s.Log.Tracef("metric %q has samples older than already registered before", m.Name())
+				continue
+			}
+			req.Timeseries[i] = ts.TimeSeries
+			continue
+		}
+
+		name := seriesName(ts.Labels)
+		if s.MaxSeriesPerName > 0 && counts[name] >= s.MaxSeriesPerName {
+			if s.MaxSeriesPerNameAction == "error" {
+				return fmt.Errorf("metric name %q has %d series exceeding the limit of %d", name, counts[name]+1, s.MaxSeriesPerName)
+			}
+			s.Log.Warnf("dropped %d series of metric name %q exceeding the limit of %d series", 1, name, s.MaxSeriesPerName)
+			continue
+		}
+		counts[name]++
+
+		// Requests ordered by timestamp are reordered as a whole after
+		// adding all series.
+		var pos int
+		switch {
+		case s.GlobalTimestampSort:
+			pos = len(req.Timeseries)
+		case s.SortMetrics:
+			pos = sort.Search(len(req.Timeseries), func(i int) bool {
+				return s.seriesLess(ts.Labels, req.Timeseries[i].Labels)
+			})
+		default:
+			pos = s.insertPosition(req.Timeseries, ts)
+		}
+		req.Timeseries = slices.Insert(req.Timeseries, pos, ts.TimeSeries)
+		for key, i := range index {
+			if i >= pos {
+				index[key] = i + 1
+			}
+		}
+		index[MakeMetricKey(ts.Labels)] = pos
+
+		if s.WriteMetadata && !s.SeparateMetadataRequest {
+			s.appendMetadata(req, ts.metadata)
+		}
+	}
+
+	if s.GlobalTimestampSort {
+		s.orderRequestByTimestamp(req)
+	}
+
+	return nil
+}
+
+// orderRequestByTimestamp sorts the series of the given request like the
+// series of a batch with the samples ascending in time, i.e. in label order
+// for series with the same timestamp if sorting is enabled.
+func (s *Serializer) orderRequestByTimestamp(req *prompb.WriteRequest) {
+	series := make([]timeSeries, 0, len(req.Timeseries))
+	for _, ts := range req.Timeseries {
+		series = append(series, timeSeries{TimeSeries: ts})
+	}
+	if s.SortMetrics {
+		sort.SliceStable(series, func(i, j int) bool {
+			return s.seriesLess(series[i].Labels, series[j].Labels)
+		})
+	}
+	orderByTimestamp(series)
+	for i := range series {
+		req.Timeseries[i] = series[i].TimeSeries
+	}
+}
+
+// appendMetadata adds the given metadata to the request keeping the metadata
+// sorted by family name. Metadata of families already contained in the
+// request is kept, metadata of new families is not added if the request
+// already contains the maximum number of entries.
+func (s *Serializer) appendMetadata(req *prompb.WriteRequest, metadata prompb.MetricMetadata) {
+	pos := sort.Search(len(req.Metadata), func(i int) bool {
+		return req.Metadata[i].MetricFamilyName >= metadata.MetricFamilyName
+	})
+	if pos < len(req.Metadata) && req.Metadata[pos].MetricFamilyName == metadata.MetricFamilyName {
+		return
+	}
+	if s.MaxMetadataEntries > 0 && len(req.Metadata) >= s.MaxMetadataEntries {
+		return
+	}
+	req.Metadata = slices.Insert(req.Metadata, pos, metadata)
+}
@@ -0,0 +1,253 @@
+package prometheusremotewrite
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/stretchr/testify/require"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/testutil"
+)
+
+func TestRemoteWriteAppendToRequest(t *testing.T) {
+	metrics := append([]telegraf.Metric{}, protocolTestMetrics...)
+	metrics = append(metrics,
+		testutil.MustMetric(
+			"cpu",
+			map[string]string{},
+			map[string]interface{}{"time_idle": 43.0},
+			time.Unix(1, 0),
+			telegraf.Gauge,
+		),
+		testutil.MustMetric(
+			"cpu",
+			map[string]string{},
+			map[string]interface{}{"time_idle": 41.0},
+			time.Unix(0, 0).Add(-time.Second),
+			telegraf.Gauge,
+		),
+	)
+
+	s := &Serializer{
+		Log:           &testutil.CaptureLogger{},
+		SortMetrics:   true,
+		WriteMetadata: true,
+	}
+	require.NoError(t, s.Init())
+
+	data, err := s.SerializeBatch(metrics)
+	require.NoError(t, err)
+	expected, err := DecodePayload(data)
+	require.NoError(t, err)
+
+	req := &prompb.WriteRequest{}
+	for _, m := range metrics {
+		require.NoError(t, s.AppendToRequest(req, m))
+	}
+	require.Equal(t, expected.Timeseries, req.Timeseries)
+	require.Equal(t, expected.Metadata, req.Metadata)
+}
+
+func TestRemoteWriteAppendToRequestMaxSeriesPerName(t *testing.T) {
+	s := &Serializer{
+		Log:                    &testutil.CaptureLogger{},
+		MaxSeriesPerName:       1,
+		MaxSeriesPerNameAction: "error",
+	}
+	require.NoError(t, s.Init())
+
+	req := &prompb.WriteRequest{}
+	m := testutil.MustMetric(
+		"cpu",
+		map[string]string{"cpu": "cpu0"},
+		map[string]interface{}{"time_idle": 42.0},
+		time.Unix(0, 0),
+	)
+	require.NoError(t, s.AppendToRequest(req, m))
+
+	m.AddTag("cpu", "cpu1")
+	require.ErrorContains(t, s.AppendToRequest(req, m), `metric name "cpu_time_idle" has 2 series exceeding the limit of 1`)
+	require.Len(t, req.Timeseries, 1)
+}
+
+func TestRemoteWriteAppendToRequestProtocolV2(t *testing.T) {
+	s := &Serializer{
+		Log:      &testutil.CaptureLogger{},
+		Protocol: "2.0",
+	}
+	require.NoError(t, s.Init())
+
+	require.ErrorContains(t, s.AppendToRequest(&prompb.WriteRequest{}, protocolTestMetrics[0]), "not supported")
+}
+
+func TestRemoteWriteAppendToRequestProcessing(t *testing.T) {
+	metrics := []telegraf.Metric{
+		testutil.MustMetric(
+			"cpu",
+			map[string]string{"cpu": "cpu1"},
+			map[string]interface{}{"time_idle": 42.0},
+			time.Unix(2, 0),
+			telegraf.Gauge,
+		),
+		testutil.MustMetric(
+			"http",
+			map[string]string{"le": "+Inf"},
+			map[string]interface{}{"latency_bucket": 5.0},
+			time.Unix(1, 0),
+			telegraf.Histogram,
+		),
+		testutil.MustMetric(
+			"http",
+			map[string]string{"le": "1"},
+			map[string]interface{}{"latency_bucket": 3.0},
+			time.Unix(1, 0),
+			telegraf.Histogram,
+		),
+		testutil.MustMetric(
+			"http",
+			map[string]string{},
+			map[string]interface{}{"latency_sum": 2.5, "latency_count": 5.0},
+			time.Unix(1, 0),
+			telegraf.Histogram,
+		),
+		testutil.MustMetric(
+			"http",
+			map[string]string{"le": "0.5"},
+			map[string]interface{}{"latency_bucket": 1.0},
+			time.Unix(1, 0),
+			telegraf.Histogram,
+		),
+		testutil.MustMetric(
+			"cpu",
+			map[string]string{"cpu": "cpu0"},
+			map[string]interface{}{"time_idle": 41.0},
+			time.Unix(0, 0),
+			telegraf.Gauge,
+		),
+	}
+
+	tests := []struct {
+		name       string
+		serializer *Serializer
+	}{
+		{
+			name:       "series id",
+			serializer: &Serializer{SortMetrics: true, EmitSeriesID: true},
+		},
+		{
+			name:       "protocol label",
+			serializer: &Serializer{SortMetrics: true, EmitProtocolLabel: true},
+		},
+		{
+			name:       "global timestamp sort",
+			serializer: &Serializer{SortMetrics: true, GlobalTimestampSort: true},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := tt.serializer
+			s.Log = &testutil.CaptureLogger{}
+			require.NoError(t, s.Init())
+
+			data, err := s.SerializeBatch(metrics)
+			require.NoError(t, err)
+			expected, err := DecodePayload(data)
+			require.NoError(t, err)
+
+			req := &prompb.WriteRequest{}
+			for _, m := range metrics {
+				require.NoError(t, s.AppendToRequest(req, m))
+			}
+			require.Equal(t, expected.Timeseries, req.Timeseries)
+		})
+	}
+}
+
+func TestRemoteWriteAppendToRequestBucketOrder(t *testing.T) {
+	s := &Serializer{Log: &testutil.CaptureLogger{}}
+	require.NoError(t, s.Init())
+
+	req := &prompb.WriteRequest{}
+	for _, le := range []string{"+Inf", "1", "0.5", "2"} {
+		m := testutil.MustMetric(
+			"http",
+			map[string]string{"le": le},
+			map[string]interface{}{"latency_bucket": 1.0},
+			time.Unix(0, 0),
+			telegraf.Histogram,
+		)
+		require.NoError(t, s.AppendToRequest(req, m))
+	}
+
+	var bounds []string
+	for _, ts := range req.Timeseries {
+		if le, found := labelValue(ts.Labels, "le"); found {
+			bounds = append(bounds, le)
+		}
+	}
+	require.Equal(t, []string{"0.5", "1", "2", "+Inf"}, bounds)
+}
+
+func TestRemoteWriteAppendToRequestWriteID(t *testing.T) {
+	s := &Serializer{
+		Log:         &testutil.CaptureLogger{},
+		EmitWriteID: true,
+	}
+	require.NoError(t, s.Init())
+
+	req := &prompb.WriteRequest{}
+	require.NoError(t, s.AppendToRequest(req, protocolTestMetrics[2]))
+	require.Len(t, req.Timeseries, 1)
+	_, found := labelValue(req.Timeseries[0].Labels, writeIDLabel)
+	require.True(t, found)
+}
+
+func TestRemoteWriteAppendToRequestDedupAcrossBatches(t *testing.T) {
+	s := &Serializer{
+		Log:        &testutil.CaptureLogger{},
+		DedupScope: "serializer",
+	}
+	require.NoError(t, s.Init())
+
+	m := testutil.MustMetric(
+		"cpu",
+		map[string]string{},
+		map[string]interface{}{"time_idle": 42.0},
+		time.Unix(10, 0),
+	)
+	_, err := s.SerializeBatch([]telegraf.Metric{m})
+	require.NoError(t, err)
+
+	req := &prompb.WriteRequest{}
+	m.SetTime(time.Unix(5, 0))
+	require.NoError(t, s.AppendToRequest(req, m))
+	require.Empty(t, req.Timeseries)
+
+	m.SetTime(time.Unix(10, 0))
+	require.NoError(t, s.AppendToRequest(req, m))
+	require.Len(t, req.Timeseries, 1)
+}
+
+func TestRemoteWriteAppendToRequestCardinalityLimit(t *testing.T) {
+	s := &Serializer{
+		Log:              &testutil.CaptureLogger{},
+		CardinalityLimit: 1,
+	}
+	require.NoError(t, s.Init())
+
+	req := &prompb.WriteRequest{}
+	for _, cpu := range []string{"cpu0", "cpu1"} {
+		m := testutil.MustMetric(
+			"cpu",
+			map[string]string{"cpu": cpu},
+			map[string]interface{}{"time_idle": 42.0},
+			time.Unix(0, 0),
+		)
+		require.NoError(t, s.AppendToRequest(req, m))
+	}
+	require.Len(t, req.Timeseries, 1)
+	cpu, _ := labelValue(req.Timeseries[0].Labels, "cpu")
+	require.Equal(t, "cpu0", cpu)
+}
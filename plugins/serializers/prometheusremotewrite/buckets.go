@@ -0,0 +1,469 @@
+package prometheusremotewrite
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/prompb"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/plugins/serializers/prometheus"
+)
+
+// bucketSeries is a histogram bucket series with its parsed upper boundary
+type bucketSeries struct {
+	key   MetricKey
+	bound float64
+}
+
+// checkBucketMonotonicity checks the buckets of all histograms in the given
+// series for counts decreasing with increasing boundaries. Depending on the
+// configured action, the counts of those histograms are either repaired by
+// carrying forward the maximum count or all series of the histogram are
+// removed. The number of affected histograms and removed series is returned.
+func (s *Serializer) checkBucketMonotonicity(entries map[MetricKey]timeSeries) (affected, removed int) {
+	le := s.bucketLabel()
+
+	// Group the buckets by the labels of the histogram excluding the bucket
+	// label.
+	histograms := make(map[MetricKey][]bucketSeries)
+	histogramLabels := make(map[MetricKey][]prompb.Label)
+	for key, ts := range entries {
+		if ts.metadata.Type != prompb.MetricMetadata_HISTOGRAM || len(ts.Samples) == 0 {
+			continue
+		}
+		if !strings.HasSuffix(seriesName(ts.Labels), "_bucket") {
+			continue
+		}
+		value, found := labelValue(ts.Labels, le)
+		if !found {
+			continue
+		}
+		bound, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			continue
+		}
+
+		labels := slices.DeleteFunc(slices.Clone(ts.Labels), func(l prompb.Label) bool { return l.Name == le })
+		hkey := MakeMetricKey(labels)
+		histograms[hkey] = append(histograms[hkey], bucketSeries{key: key, bound: bound})
+		histogramLabels[hkey] = labels
+	}
+
+	for hkey, buckets := range histograms {
+		sort.Slice(buckets, func(i, j int) bool { return buckets[i].bound < buckets[j].bound })
+
+		monotonic := true
+		for i := 1; i < len(buckets); i++ {
+			if entries[buckets[i].key].Samples[0].Value < entries[buckets[i-1].key].Samples[0].Value {
+				monotonic = false
+				break
+			}
+		}
+		if monotonic {
+			continue
+		}
+		affected++
+
+		if s.BucketMonotonicityAction == "drop" {
+			for _, b := range buckets {
+				delete(entries, b.key)
+			}
+			removed += len(buckets)
+
+			// Remove the sum and count series of the histogram
+			labels := histogramLabels[hkey]
+			name := strings.TrimSuffix(seriesName(labels), "_bucket")
+			for _, suffix := range []string{"_sum", "_count"} {
+				for i := range labels {
+					if labels[i].Name == model.MetricNameLabel {
+						labels[i].Value = name + suffix
+					}
+				}
+				key := MakeMetricKey(labels)
+				if _, found := entries[key]; found {
+					delete(entries, key)
+					removed++
+				}
+			}
+			continue
+		}
+
+		for i := 1; i < len(buckets); i++ {
+			previous := entries[buckets[i-1].key].Samples[0].Value
+			if current := entries[buckets[i].key]; current.Samples[0].Value < previous {
+				current.Samples[0].Value = previous
+			}
+		}
+	}
+
+	return affected, removed
+}
+
+// checkHistogramSums checks the sums of all histograms in the given series
+// for NaN values. Depending on the configured action, the sums are either
+// kept, set to zero or all series of the histogram are removed. The number of
+// affected histograms and removed series is returned.
+func (s *Serializer) checkHistogramSums(entries map[MetricKey]timeSeries) (affected, removed int) {
+	invalid := make(map[MetricKey]bool)
+	for _, ts := range entries {
+		if ts.metadata.Type != prompb.MetricMetadata_HISTOGRAM || !strings.HasSuffix(seriesName(ts.Labels), "_sum") {
+			continue
+		}
+		nan := false
+		for i := range ts.Samples {
+			if math.IsNaN(ts.Samples[i].Value) {
+				nan = true
+				if s.HistogramNaNSumAction == "zero" {
+					ts.Samples[i].Value = 0
+				}
+			}
+		}
+		if nan {
+			invalid[s.histogramKey(ts.Labels, "_sum")] = true
+		}
+	}
+	affected = len(invalid)
+	if affected == 0 || s.HistogramNaNSumAction != "drop" {
+		return affected, 0
+	}
+
+	return affected, s.removeHistograms(entries, invalid)
+}
+
+// checkHistogramCounts checks all histograms in the given series for a
+// missing count, i.e. histograms where the count series is only a
+// placeholder created for the buckets. The names of the affected histograms
+// are returned sorted. If requested, all series of those histograms are
+// removed and the number of removed series is returned.
+func (s *Serializer) checkHistogramCounts(entries map[MetricKey]timeSeries, placeholders map[MetricKey]bool, remove bool) (names []string, removed int) {
+	invalid := make(map[MetricKey]bool)
+	for key, ts := range entries {
+		if !placeholders[key] || ts.metadata.Type != prompb.MetricMetadata_HISTOGRAM {
+			continue
+		}
+		name := seriesName(ts.Labels)
+		if !strings.HasSuffix(name, "_count") {
+			continue
+		}
+		invalid[s.histogramKey(ts.Labels, "_count")] = true
+		names = append(names, strings.TrimSuffix(name, "_count"))
+	}
+	if len(invalid) == 0 {
+		return nil, 0
+	}
+	sort.Strings(names)
+	names = slices.Compact(names)
+
+	if remove {
+		removed = s.removeHistograms(entries, invalid)
+	}
+	return names, removed
+}
+
+// histogramKey identifies the histogram of the series with the given labels
+// and suffix by its base name and the labels excluding the bucket label.
+func (s *Serializer) histogramKey(labels []prompb.Label, suffix string) MetricKey {
+	le := s.bucketLabel()
+	labels = slices.DeleteFunc(slices.Clone(labels), func(l prompb.Label) bool { return l.Name == le })
+	for i := range labels {
+		if labels[i].Name == model.MetricNameLabel {
+			labels[i].Value = strings.TrimSuffix(labels[i].Value, suffix)
+		}
+	}
+	return MakeMetricKey(labels)
+}
+
+// removeHistograms removes the bucket, sum and count series of the given
+// histograms and returns the number of removed series.
+func (s *Serializer) removeHistograms(entries map[MetricKey]timeSeries, histograms map[MetricKey]bool) (removed int) {
+	for key, ts := range entries {
+		if ts.metadata.Type != prompb.MetricMetadata_HISTOGRAM {
+			continue
+		}
+		name := seriesName(ts.Labels)
+		for _, suffix := range []string{"_bucket", "_sum", "_count"} {
+			if strings.HasSuffix(name, suffix) && histograms[s.histogramKey(ts.Labels, suffix)] {
+				delete(entries, key)
+				removed++
+				break
+			}
+		}
+	}
+	return removed
+}
+
+// orderBuckets reorders the bucket series of each histogram in place such
+// that the buckets are in ascending order of their boundary. The buckets
+// only swap positions among each other, so all other series keep their
+// position.
+func (s *Serializer) orderBuckets(series []timeSeries) {
+	type bucketPosition struct {
+		ts    timeSeries
+		bound float64
+	}
+	positions := make(map[MetricKey][]int)
+	buckets := make(map[MetricKey][]bucketPosition)
+	for i, ts := range series {
+		if ts.metadata.Type != prompb.MetricMetadata_HISTOGRAM {
+			continue
+		}
+		hkey, bound, ok := s.bucketBound(ts.Labels)
+		if !ok {
+			continue
+		}
+		positions[hkey] = append(positions[hkey], i)
+		buckets[hkey] = append(buckets[hkey], bucketPosition{ts: ts, bound: bound})
+	}
+
+	for hkey, histogram := range buckets {
+		sort.SliceStable(histogram, func(i, j int) bool { return histogram[i].bound < histogram[j].bound })
+		for i, pos := range positions[hkey] {
+			series[pos] = histogram[i].ts
+		}
+	}
+}
+
+// insertPosition returns the position for inserting the given histogram
+// bucket into the given series such that it precedes the first bucket of the
+// same histogram with a larger boundary. All other series are inserted at the
+// end.
+func (s *Serializer) insertPosition(series []prompb.TimeSeries, ts timeSeries) int {
+	if ts.metadata.Type != prompb.MetricMetadata_HISTOGRAM {
+		return len(series)
+	}
+	hkey, bound, ok := s.bucketBound(ts.Labels)
+	if !ok {
+		return len(series)
+	}
+	for i := range series {
+		if key, b, ok := s.bucketBound(series[i].Labels); ok && key == hkey && b > bound {
+			return i
+		}
+	}
+	return len(series)
+}
+
+// bucketBound returns the key of the histogram and the boundary of the bucket
+// series with the given labels. Series not being a bucket are reported as
+// such.
+func (s *Serializer) bucketBound(labels []prompb.Label) (MetricKey, float64, bool) {
+	le := s.bucketLabel()
+	if !strings.HasSuffix(seriesName(labels), "_bucket") {
+		return 0, 0, false
+	}
+	value, found := labelValue(labels, le)
+	if !found {
+		return 0, 0, false
+	}
+	bound, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	return MakeMetricKey(slices.DeleteFunc(slices.Clone(labels), func(l prompb.Label) bool { return l.Name == le })), bound, true
+}
+
+// histogramPart identifies a histogram by the series of the metric, i.e. the
+// name and tags excluding the bucket tag, and the base name of the field.
+type histogramPart struct {
+	series uint64
+	base   string
+}
+
+// detectHistograms returns the given metrics with untyped metrics being part
+// of complete histograms converted to the histogram type. A histogram is
+// complete if the metrics contain the "_sum" and "_count" field as well as at
+// least one "_bucket" field with an "le" tag for the same field base name,
+// metric name and tags. Metrics with fields not belonging to a complete
+// histogram are left untouched.
+func (s *Serializer) detectHistograms(metrics []telegraf.Metric) []telegraf.Metric {
+	// Collect the histogram parts contained in the untyped metrics
+	parts := make(map[histogramPart]map[string]bool)
+	for _, m := range metrics {
+		if m.Type() != telegraf.Untyped {
+			continue
+		}
+		_, hasBound := m.GetTag("le")
+		series := familyKey(m)
+		for _, field := range m.FieldList() {
+			base, suffix := splitFieldKey(field.Key, telegraf.Histogram)
+			if suffix == "" || (suffix == "_bucket" && !hasBound) {
+				continue
+			}
+			key := histogramPart{series: series, base: base}
+			if parts[key] == nil {
+				parts[key] = make(map[string]bool)
+			}
+			parts[key][suffix] = true
+		}
+	}
+	complete := func(key histogramPart) bool {
+		return parts[key]["_sum"] && parts[key]["_count"] && parts[key]["_bucket"]
+	}
+
+	result := make([]telegraf.Metric, 0, len(metrics))
+	for _, m := range metrics {
+		if m.Type() != telegraf.Untyped {
+			result = append(result, m)
+			continue
+		}
+
+		series := familyKey(m)
+		histogram := false
+		for _, field := range m.FieldList() {
+			if _, ok := field.Value.(string); ok || s.isExemplarField(field.Key) || s.isFieldTimestamp(m, field.Key) {
+				continue
+			}
+			base, suffix := splitFieldKey(field.Key, telegraf.Histogram)
+			if suffix == "" || !complete(histogramPart{series: series, base: base}) {
+				histogram = false
+				break
+			}
+			histogram = true
+		}
+		if histogram {
+			m = m.Copy()
+			m.SetType(telegraf.Histogram)
+		}
+		result = append(result, m)
+	}
+	return result
+}
+
+// familyKey hashes the name and tags of the metric ignoring the histogram
+// and summary tags such that all metrics of a family get the same key.
+func familyKey(m telegraf.Metric) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(m.Name()))
+	h.Write([]byte("\x00"))
+	for _, tag := range m.TagList() {
+		if tag.Key == "le" || tag.Key == "quantile" {
+			continue
+		}
+		h.Write([]byte(tag.Key))
+		h.Write([]byte("\x00"))
+		h.Write([]byte(tag.Value))
+		h.Write([]byte("\x00"))
+	}
+	return h.Sum64()
+}
+
+// convertHistogramField converts the bucket, sum or count field of a
+// histogram metric into a series. Missing parts of the histogram are added
+// to the conversion as placeholders replaced by any real sample. Fields with
+// invalid values and duplicate buckets to be skipped are reported as not ok.
+func (s *Serializer) convertHistogramField(
+	c *conversion,
+	metric telegraf.Metric,
+	field *telegraf.Field,
+	metricName string,
+	labels []prompb.Label,
+	timestamp time.Time,
+	metadata prompb.MetricMetadata,
+) (MetricKey, prompb.TimeSeries, bool) {
+	switch {
+	case strings.HasSuffix(field.Key, "_bucket"):
+		// if bucket only, init sum, count, inf as placeholders replaced by
+		// any real sample independent of its timestamp
+		metrickeysum, promtssum := getPromTS(metricName+"_sum", labels, float64(0), timestamp)
+		if _, ok := c.entries[metrickeysum]; !ok {
+			c.entries[metrickeysum] = timeSeries{TimeSeries: promtssum, metadata: metadata}
+			c.placeholders[metrickeysum] = true
+		}
+		metrickeycount, promtscount := getPromTS(metricName+"_count", labels, float64(0), timestamp)
+		if _, ok := c.entries[metrickeycount]; !ok {
+			c.entries[metrickeycount] = timeSeries{TimeSeries: promtscount, metadata: metadata}
+			c.placeholders[metrickeycount] = true
+		}
+		extraLabel := prompb.Label{
+			Name:  s.bucketLabel(),
+			Value: "+Inf",
+		}
+		metrickeyinf, promtsinf := getPromTS(metricName+"_bucket", labels, float64(0), timestamp, extraLabel)
+		if _, ok := c.entries[metrickeyinf]; !ok {
+			c.entries[metrickeyinf] = timeSeries{TimeSeries: promtsinf, metadata: metadata}
+			c.placeholders[metrickeyinf] = true
+		}
+
+		le, ok := metric.GetTag("le")
+		if !ok {
+			s.traceAndKeepErr(c, "failed to parse %q: can't find `le` label", metricName)
+			return 0, prompb.TimeSeries{}, false
+		}
+		bound, err := strconv.ParseFloat(le, 64)
+		if err != nil {
+			s.traceAndKeepErr(c, "failed to parse %q: can't parse %q value: %w", metricName, le, err)
+			return 0, prompb.TimeSeries{}, false
+		}
+		count, ok := prometheus.SampleCount(field.Value)
+		if !ok {
+			s.traceAndKeepErr(c, "failed to parse %q: bad sample value %#v", metricName, field.Value)
+			return 0, prompb.TimeSeries{}, false
+		}
+
+		extraLabel = prompb.Label{
+			Name:  s.bucketLabel(),
+			Value: s.formatBoundary(s.bucketLabel(), bound),
+		}
+		metrickey, promts := getPromTS(metricName+"_bucket", labels, float64(count), timestamp, extraLabel)
+		if exemplar, ok := s.exemplar(metric, timestamp); ok {
+			promts.Exemplars = []prompb.Exemplar{exemplar}
+		}
+
+		// Different notations of a boundary such as "Inf" and "+Inf" result
+		// in the same bucket after normalization. Resolve those collisions
+		// according to the policy. Duplicates agreeing on the count are
+		// harmless and skipped silently.
+		if m, found := c.entries[metrickey]; found && c.buckets[metrickey] && m.Samples[0].Timestamp == promts.Samples[0].Timestamp {
+			if m.Samples[0].Value == float64(count) {
+				return 0, prompb.TimeSeries{}, false
+			}
+			c.duplicateBuckets[fmt.Sprintf("%s{%s=%q}", metricName+"_bucket", extraLabel.Name, extraLabel.Value)] = true
+			if s.DuplicateBucketPolicy == "first" || (s.DuplicateBucketPolicy != "last" && m.Samples[0].Value >= float64(count)) {
+				return 0, prompb.TimeSeries{}, false
+			}
+		}
+		c.buckets[metrickey] = true
+		return metrickey, promts, true
+	case strings.HasSuffix(field.Key, "_sum"):
+		sum, ok := prometheus.SampleSum(field.Value)
+		if !ok {
+			s.traceAndKeepErr(c, "failed to parse %q: bad sample value %#v", metricName, field.Value)
+			return 0, prompb.TimeSeries{}, false
+		}
+
+		metrickey, promts := getPromTS(metricName+"_sum", labels, sum, timestamp)
+		return metrickey, promts, true
+	case strings.HasSuffix(field.Key, "_count"):
+		count, ok := prometheus.SampleCount(field.Value)
+		if !ok {
+			s.traceAndKeepErr(c, "failed to parse %q: bad sample value %#v", metricName, field.Value)
+			return 0, prompb.TimeSeries{}, false
+		}
+
+		// if no bucket generate +Inf entry, an empty +Inf bucket is only
+		// replaced by a count not older than the bucket
+		extraLabel := prompb.Label{
+			Name:  s.bucketLabel(),
+			Value: "+Inf",
+		}
+		metrickeyinf, promtsinf := getPromTS(metricName+"_bucket", labels, float64(count), timestamp, extraLabel)
+		minf, ok := c.entries[metrickeyinf]
+		if !ok || c.placeholders[metrickeyinf] || (minf.Samples[0].Value == 0 && minf.Samples[0].Timestamp <= promtsinf.Samples[0].Timestamp) {
+			c.entries[metrickeyinf] = timeSeries{TimeSeries: promtsinf, metadata: metadata}
+			delete(c.placeholders, metrickeyinf)
+		}
+
+		metrickey, promts := getPromTS(metricName+"_count", labels, float64(count), timestamp)
+		return metrickey, promts, true
+	}
+	s.traceAndKeepErr(c, "failed to parse %q: series %q should have `_count`, `_sum` or `_bucket` suffix", metricName, field.Key)
+	return 0, prompb.TimeSeries{}, false
+}
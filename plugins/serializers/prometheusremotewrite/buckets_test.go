@@ -0,0 +1,433 @@
+package prometheusremotewrite
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/testutil"
+)
+
+func TestRemoteWriteSerializeBucketMonotonicity(t *testing.T) {
+	var metrics []telegraf.Metric
+	for _, host := range []string{"a", "b"} {
+		buckets := map[string]float64{"0.1": 10, "0.5": 5, "1": 20, "+Inf": 20}
+		if host == "b" {
+			buckets = map[string]float64{"0.1": 1, "0.5": 2, "1": 3, "+Inf": 3}
+		}
+		for le, count := range buckets {
+			metrics = append(metrics, testutil.MustMetric(
+				"prometheus",
+				map[string]string{"host": host, "le": le},
+				map[string]interface{}{"http_request_duration_seconds_bucket": count},
+				time.Unix(0, 0),
+				telegraf.Histogram,
+			))
+		}
+		metrics = append(metrics, testutil.MustMetric(
+			"prometheus",
+			map[string]string{"host": host},
+			map[string]interface{}{
+				"http_request_duration_seconds_sum":   30.0,
+				"http_request_duration_seconds_count": buckets["+Inf"],
+			},
+			time.Unix(0, 0),
+			telegraf.Histogram,
+		))
+	}
+
+	tests := []struct {
+		name     string
+		action   string
+		expected string
+		warning  string
+	}{
+		{
+			name:   "repair",
+			action: "repair",
+			expected: `
+http_request_duration_seconds_count{host="a"} 20
+http_request_duration_seconds_count{host="b"} 3
+http_request_duration_seconds_sum{host="a"} 30
+http_request_duration_seconds_sum{host="b"} 30
+http_request_duration_seconds_bucket{host="a", le="+Inf"} 20
+http_request_duration_seconds_bucket{host="a", le="0.1"} 10
+http_request_duration_seconds_bucket{host="a", le="0.5"} 10
+http_request_duration_seconds_bucket{host="a", le="1"} 20
+http_request_duration_seconds_bucket{host="b", le="+Inf"} 3
+http_request_duration_seconds_bucket{host="b", le="0.1"} 1
+http_request_duration_seconds_bucket{host="b", le="0.5"} 2
+http_request_duration_seconds_bucket{host="b", le="1"} 3
+`,
+			warning: "repaired 1 histograms with non-monotonic buckets",
+		},
+		{
+			name:   "drop",
+			action: "drop",
+			expected: `
+http_request_duration_seconds_count{host="b"} 3
+http_request_duration_seconds_sum{host="b"} 30
+http_request_duration_seconds_bucket{host="b", le="+Inf"} 3
+http_request_duration_seconds_bucket{host="b", le="0.1"} 1
+http_request_duration_seconds_bucket{host="b", le="0.5"} 2
+http_request_duration_seconds_bucket{host="b", le="1"} 3
+`,
+			warning: "dropped 1 histograms with non-monotonic buckets",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clog := &testutil.CaptureLogger{}
+			s := &Serializer{
+				Log:                        clog,
+				SortMetrics:                true,
+				ValidateBucketMonotonicity: true,
+				BucketMonotonicityAction:   tt.action,
+			}
+			require.NoError(t, s.Init())
+
+			data, err := s.SerializeBatch(metrics)
+			require.NoError(t, err)
+			actual, err := prompbToText(data)
+			require.NoError(t, err)
+			require.Equal(t, strings.TrimSpace(tt.expected), strings.TrimSpace(string(actual)))
+
+			warnings := clog.Warnings()
+			require.Len(t, warnings, 1)
+			require.Contains(t, warnings[0], tt.warning)
+		})
+	}
+}
+
+func TestRemoteWriteSerializeBucketMonotonicityDisabled(t *testing.T) {
+	metrics := []telegraf.Metric{
+		testutil.MustMetric(
+			"prometheus",
+			map[string]string{"le": "0.1"},
+			map[string]interface{}{"http_request_duration_seconds_bucket": 10.0},
+			time.Unix(0, 0),
+			telegraf.Histogram,
+		),
+		testutil.MustMetric(
+			"prometheus",
+			map[string]string{"le": "0.5"},
+			map[string]interface{}{"http_request_duration_seconds_bucket": 5.0},
+			time.Unix(0, 0),
+			telegraf.Histogram,
+		),
+	}
+
+	s := &Serializer{
+		Log:         &testutil.CaptureLogger{},
+		SortMetrics: true,
+	}
+	require.NoError(t, s.Init())
+
+	data, err := s.SerializeBatch(metrics)
+	require.NoError(t, err)
+	actual, err := prompbToText(data)
+	require.NoError(t, err)
+	require.Contains(t, string(actual), `http_request_duration_seconds_bucket{le="0.5"} 5`)
+}
+
+func TestRemoteWriteSerializeHistogramNaNSum(t *testing.T) {
+	var metrics []telegraf.Metric
+	for _, host := range []string{"a", "b"} {
+		sum := 30.0
+		if host == "a" {
+			sum = math.NaN()
+		}
+		for le, count := range map[string]float64{"0.5": 2, "+Inf": 3} {
+			metrics = append(metrics, testutil.MustMetric(
+				"prometheus",
+				map[string]string{"host": host, "le": le},
+				map[string]interface{}{"http_request_duration_seconds_bucket": count},
+				time.Unix(0, 0),
+				telegraf.Histogram,
+			))
+		}
+		metrics = append(metrics, testutil.MustMetric(
+			"prometheus",
+			map[string]string{"host": host},
+			map[string]interface{}{
+				"http_request_duration_seconds_sum":   sum,
+				"http_request_duration_seconds_count": 3.0,
+			},
+			time.Unix(0, 0),
+			telegraf.Histogram,
+		))
+	}
+
+	tests := []struct {
+		name     string
+		action   string
+		expected string
+		warning  string
+	}{
+		{
+			name:   "warn",
+			action: "warn",
+			expected: `
+http_request_duration_seconds_count{host="a"} 3
+http_request_duration_seconds_count{host="b"} 3
+http_request_duration_seconds_sum{host="a"} NaN
+http_request_duration_seconds_sum{host="b"} 30
+http_request_duration_seconds_bucket{host="a", le="+Inf"} 3
+http_request_duration_seconds_bucket{host="a", le="0.5"} 2
+http_request_duration_seconds_bucket{host="b", le="+Inf"} 3
+http_request_duration_seconds_bucket{host="b", le="0.5"} 2
+`,
+			warning: "found 1 histograms with NaN sums",
+		},
+		{
+			name:   "zero",
+			action: "zero",
+			expected: `
+http_request_duration_seconds_count{host="a"} 3
+http_request_duration_seconds_count{host="b"} 3
+http_request_duration_seconds_sum{host="a"} 0
+http_request_duration_seconds_sum{host="b"} 30
+http_request_duration_seconds_bucket{host="a", le="+Inf"} 3
+http_request_duration_seconds_bucket{host="a", le="0.5"} 2
+http_request_duration_seconds_bucket{host="b", le="+Inf"} 3
+http_request_duration_seconds_bucket{host="b", le="0.5"} 2
+`,
+			warning: "zeroed the sums of 1 histograms with NaN sums",
+		},
+		{
+			name:   "drop",
+			action: "drop",
+			expected: `
+http_request_duration_seconds_count{host="b"} 3
+http_request_duration_seconds_sum{host="b"} 30
+http_request_duration_seconds_bucket{host="b", le="+Inf"} 3
+http_request_duration_seconds_bucket{host="b", le="0.5"} 2
+`,
+			warning: "dropped 1 histograms with NaN sums",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clog := &testutil.CaptureLogger{}
+			s := &Serializer{
+				Log:                          clog,
+				SortMetrics:                  true,
+				ValidateHistogramConsistency: true,
+				HistogramNaNSumAction:        tt.action,
+			}
+			require.NoError(t, s.Init())
+
+			data, err := s.SerializeBatch(metrics)
+			require.NoError(t, err)
+			req, err := DecodePayload(data)
+			require.NoError(t, err)
+			require.Equal(t, strings.TrimSpace(tt.expected), strings.TrimSpace(RenderText(req)))
+
+			warnings := clog.Warnings()
+			require.Len(t, warnings, 1)
+			require.Contains(t, warnings[0], tt.warning)
+		})
+	}
+}
+
+func TestRemoteWriteSerializeRequireHistogramCount(t *testing.T) {
+	var metrics []telegraf.Metric
+	for _, host := range []string{"a", "b"} {
+		for le, count := range map[string]float64{"0.5": 2, "+Inf": 3} {
+			metrics = append(metrics, testutil.MustMetric(
+				"prometheus",
+				map[string]string{"host": host, "le": le},
+				map[string]interface{}{"http_request_duration_seconds_bucket": count},
+				time.Unix(0, 0),
+				telegraf.Histogram,
+			))
+		}
+	}
+	metrics = append(metrics, testutil.MustMetric(
+		"prometheus",
+		map[string]string{"host": "b"},
+		map[string]interface{}{
+			"http_request_duration_seconds_sum":   30.0,
+			"http_request_duration_seconds_count": 3.0,
+		},
+		time.Unix(0, 0),
+		telegraf.Histogram,
+	))
+
+	t.Run("drop", func(t *testing.T) {
+		clog := &testutil.CaptureLogger{}
+		s := &Serializer{
+			Log:                   clog,
+			SortMetrics:           true,
+			RequireHistogramCount: true,
+		}
+		require.NoError(t, s.Init())
+
+		data, err := s.SerializeBatch(metrics)
+		require.NoError(t, err)
+		req, err := DecodePayload(data)
+		require.NoError(t, err)
+
+		expected := `
+http_request_duration_seconds_count{host="b"} 3
+http_request_duration_seconds_sum{host="b"} 30
+http_request_duration_seconds_bucket{host="b", le="+Inf"} 3
+http_request_duration_seconds_bucket{host="b", le="0.5"} 2
+`
+		require.Equal(t, strings.TrimSpace(expected), strings.TrimSpace(RenderText(req)))
+
+		warnings := clog.Warnings()
+		require.Len(t, warnings, 1)
+		require.Contains(t, warnings[0], `dropped histograms ["http_request_duration_seconds"] without count`)
+	})
+
+	t.Run("error", func(t *testing.T) {
+		s := &Serializer{
+			Log:                         &testutil.CaptureLogger{},
+			RequireHistogramCount:       true,
+			MissingHistogramCountAction: "error",
+		}
+		require.NoError(t, s.Init())
+
+		_, err := s.SerializeBatch(metrics)
+		require.ErrorContains(t, err, `histograms ["http_request_duration_seconds"] have no count`)
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		s := &Serializer{Log: &testutil.CaptureLogger{}}
+		require.NoError(t, s.Init())
+
+		data, err := s.SerializeBatch(metrics)
+		require.NoError(t, err)
+		req, err := DecodePayload(data)
+		require.NoError(t, err)
+		require.Contains(t, RenderText(req), `http_request_duration_seconds_count{host="a"} 0`)
+	})
+}
+
+func TestRemoteWriteSerializeBucketOrderUnsorted(t *testing.T) {
+	var metrics []telegraf.Metric
+	for _, le := range []string{"10", "+Inf", "0.5", "2", "0.05", "1"} {
+		for _, host := range []string{"a", "b"} {
+			metrics = append(metrics, testutil.MustMetric(
+				"prometheus",
+				map[string]string{"host": host, "le": le},
+				map[string]interface{}{"http_request_duration_seconds_bucket": 1.0},
+				time.Unix(0, 0),
+				telegraf.Histogram,
+			))
+		}
+	}
+	metrics = append(metrics, testutil.MustMetric(
+		"cpu",
+		map[string]string{},
+		map[string]interface{}{"time_idle": 42.0},
+		time.Unix(0, 0),
+	))
+
+	s := &Serializer{Log: &testutil.CaptureLogger{}}
+	require.NoError(t, s.Init())
+
+	data, err := s.SerializeBatch(metrics)
+	require.NoError(t, err)
+	req, err := DecodePayload(data)
+	require.NoError(t, err)
+
+	bounds := make(map[string][]string)
+	for _, ts := range req.Timeseries {
+		if seriesName(ts.Labels) != "http_request_duration_seconds_bucket" {
+			continue
+		}
+		host, _ := labelValue(ts.Labels, "host")
+		le, _ := labelValue(ts.Labels, "le")
+		bounds[host] = append(bounds[host], le)
+	}
+	expected := []string{"0.05", "0.5", "1", "2", "10", "+Inf"}
+	require.Equal(t, map[string][]string{"a": expected, "b": expected}, bounds)
+}
+
+func TestRemoteWriteSerializeHistogramAutoDetect(t *testing.T) {
+	metrics := []telegraf.Metric{
+		testutil.MustMetric(
+			"prometheus",
+			map[string]string{"le": "0.5"},
+			map[string]interface{}{"http_request_duration_seconds_bucket": 129389.0},
+			time.Unix(0, 0),
+		),
+		testutil.MustMetric(
+			"prometheus",
+			map[string]string{"le": "+Inf"},
+			map[string]interface{}{"http_request_duration_seconds_bucket": 144320.0},
+			time.Unix(0, 0),
+		),
+		testutil.MustMetric(
+			"prometheus",
+			map[string]string{},
+			map[string]interface{}{
+				"http_request_duration_seconds_sum":   53423.0,
+				"http_request_duration_seconds_count": 144320.0,
+			},
+			time.Unix(0, 0),
+		),
+		testutil.MustMetric(
+			"queue",
+			map[string]string{},
+			map[string]interface{}{
+				"length":       12.0,
+				"length_count": 3.0,
+			},
+			time.Unix(0, 0),
+			telegraf.Gauge,
+		),
+		testutil.MustMetric(
+			"jobs",
+			map[string]string{},
+			map[string]interface{}{
+				"runtime_sum":   20.0,
+				"runtime_count": 4.0,
+			},
+			time.Unix(0, 0),
+		),
+	}
+
+	s := &Serializer{
+		Log:                 &testutil.CaptureLogger{},
+		SortMetrics:         true,
+		WriteMetadata:       true,
+		HistogramAutoDetect: true,
+	}
+	require.NoError(t, s.Init())
+
+	data, err := s.SerializeBatch(metrics)
+	require.NoError(t, err)
+	req, err := DecodePayload(data)
+	require.NoError(t, err)
+
+	expected := `
+http_request_duration_seconds_count 144320
+http_request_duration_seconds_sum 53423
+jobs_runtime_count 4
+jobs_runtime_sum 20
+queue_length 12
+queue_length_count 3
+http_request_duration_seconds_bucket{le="+Inf"} 144320
+http_request_duration_seconds_bucket{le="0.5"} 129389
+`
+	require.Equal(t, strings.TrimSpace(expected), strings.TrimSpace(RenderText(req)))
+
+	types := make(map[string]string, len(req.Metadata))
+	for _, metadata := range req.Metadata {
+		types[metadata.MetricFamilyName] = metadata.Type.String()
+	}
+	require.Equal(t, map[string]string{
+		"http_request_duration_seconds": "HISTOGRAM",
+		"jobs_runtime_count":            "UNKNOWN",
+		"jobs_runtime_sum":              "UNKNOWN",
+		"queue_length":                  "GAUGE",
+		"queue_length_count":            "GAUGE",
+	}, types)
+}
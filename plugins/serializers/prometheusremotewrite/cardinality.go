@@ -0,0 +1,92 @@
+package prometheusremotewrite
+
+import (
+	"sync"
+	"time"
+)
+
+// cardinalityGuard keeps the distinct series per metric name seen within a
+// sliding window across batches. Series not seen within the window are
+// evicted to bound the memory and to free their slot for new series.
+type cardinalityGuard struct {
+	limit  int
+	window time.Duration
+	seen   map[string]map[MetricKey]time.Time
+	sync.Mutex
+}
+
+// filter removes all series of metric names already having the maximum number
+// of distinct series within the window. Series seen before are always kept.
+// The remaining series are added to the given series per metric name to be
+// recorded once the batch is complete.
+func (c *cardinalityGuard) filter(series []timeSeries, now time.Time, seen map[string]map[MetricKey]bool) (kept []timeSeries, dropped map[string]int) {
+	c.Lock()
+	defer c.Unlock()
+
+	counts := make(map[string]int)
+	kept = series[:0]
+	for _, ts := range series {
+		name := seriesName(ts.Labels)
+		key := MakeMetricKey(ts.Labels)
+		if _, found := counts[name]; !found {
+			counts[name] = c.count(name, now)
+		}
+		if updated, found := c.seen[name][key]; !found || now.Sub(updated) > c.window {
+			if counts[name] >= c.limit {
+				if dropped == nil {
+					dropped = make(map[string]int)
+				}
+				dropped[name]++
+				continue
+			}
+			counts[name]++
+		}
+		if seen[name] == nil {
+			seen[name] = make(map[MetricKey]bool)
+		}
+		seen[name][key] = true
+		kept = append(kept, ts)
+	}
+	return kept, dropped
+}
+
+// count returns the number of distinct series of the given metric name seen
+// within the window.
+func (c *cardinalityGuard) count(name string, now time.Time) int {
+	var n int
+	for _, updated := range c.seen[name] {
+		if now.Sub(updated) <= c.window {
+			n++
+		}
+	}
+	return n
+}
+
+// record records the given series per metric name as seen and evicts the
+// series not seen within the window.
+func (c *cardinalityGuard) record(seen map[string]map[MetricKey]bool, now time.Time) {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.seen == nil {
+		c.seen = make(map[string]map[MetricKey]time.Time)
+	}
+	for name, keys := range c.seen {
+		for key, updated := range keys {
+			if now.Sub(updated) > c.window {
+				delete(keys, key)
+			}
+		}
+		if len(keys) == 0 {
+			delete(c.seen, name)
+		}
+	}
+	for name, keys := range seen {
+		if c.seen[name] == nil {
+			c.seen[name] = make(map[MetricKey]time.Time, len(keys))
+		}
+		for key := range keys {
+			c.seen[name][key] = now
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package prometheusremotewrite
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/stretchr/testify/require"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/testutil"
+)
+
+func TestRemoteWriteSerializeCardinalityLimit(t *testing.T) {
+	newMetric := func(path string) telegraf.Metric {
+		return testutil.MustMetric(
+			"http",
+			map[string]string{"path": path},
+			map[string]interface{}{"requests_total": 1.0},
+			time.Unix(0, 0),
+		)
+	}
+
+	clog := &testutil.CaptureLogger{}
+	s := &Serializer{
+		Log:              clog,
+		SortMetrics:      true,
+		CardinalityLimit: 3,
+	}
+	require.NoError(t, s.Init())
+
+	// Feed an increasing number of label combinations with every batch
+	for n := 1; n <= 5; n++ {
+		metrics := make([]telegraf.Metric, 0, n)
+		for i := 0; i < n; i++ {
+			metrics = append(metrics, newMetric(fmt.Sprintf("/%d", i)))
+		}
+		metrics = append(metrics, testutil.MustMetric(
+			"cpu",
+			map[string]string{},
+			map[string]interface{}{"time_idle": 42.0},
+			time.Unix(0, 0),
+		))
+
+		data, err := s.SerializeBatch(metrics)
+		require.NoError(t, err)
+		actual, err := prompbToText(data)
+		require.NoError(t, err)
+
+		expected := []string{"cpu_time_idle 42"}
+		for i := 0; i < min(n, 3); i++ {
+			expected = append(expected, fmt.Sprintf(`http_requests_total{path="/%d"} 1`, i))
+		}
+		require.Equal(t, strings.Join(expected, "\n"), strings.TrimSpace(string(actual)))
+	}
+
+	warnings := clog.Warnings()
+	require.Len(t, warnings, 2)
+	require.Contains(t, warnings[0], `dropped 1 new series of metric name "http_requests_total" exceeding the cardinality limit of 3 series`)
+	require.Contains(t, warnings[1], `dropped 2 new series of metric name "http_requests_total" exceeding the cardinality limit of 3 series`)
+}
+
+func TestRemoteWriteSerializeCardinalityWindow(t *testing.T) {
+	c := &cardinalityGuard{limit: 1, window: time.Minute}
+	series := func(path string) []timeSeries {
+		_, promts := getPromTS("http_requests_total", []prompb.Label{{Name: "path", Value: path}}, 1.0, time.Unix(0, 0))
+		return []timeSeries{{TimeSeries: promts}}
+	}
+
+	now := time.Now()
+	seen := make(map[string]map[MetricKey]bool)
+	kept, dropped := c.filter(series("/a"), now, seen)
+	require.Len(t, kept, 1)
+	require.Empty(t, dropped)
+	c.record(seen, now)
+
+	seen = make(map[string]map[MetricKey]bool)
+	kept, dropped = c.filter(series("/b"), now.Add(30*time.Second), seen)
+	require.Empty(t, kept)
+	require.Equal(t, map[string]int{"http_requests_total": 1}, dropped)
+	c.record(seen, now.Add(30*time.Second))
+
+	// The slot is freed after the first series was not seen within the window
+	seen = make(map[string]map[MetricKey]bool)
+	kept, dropped = c.filter(series("/b"), now.Add(2*time.Minute), seen)
+	require.Len(t, kept, 1)
+	require.Empty(t, dropped)
+	c.record(seen, now.Add(2*time.Minute))
+	require.Len(t, c.seen["http_requests_total"], 1)
+}
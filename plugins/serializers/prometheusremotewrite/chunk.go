@@ -0,0 +1,185 @@
+package prometheusremotewrite
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sync"
+
+	"github.com/golang/snappy"
+	"github.com/prometheus/common/model"
+
+	"github.com/influxdata/telegraf"
+)
+
+// SerializeBatchChunked serializes the given metrics into one or more
+// payloads each not exceeding the configured maximum payload size. The series
+// are distributed using a conservative estimate of the marshaled size, chunks
+// exceeding the limit nevertheless are split further. An error is returned if
+// a single series exceeds the limit.
+func (s *Serializer) SerializeBatchChunked(metrics []telegraf.Metric) ([][]byte, error) {
+	series, _, err := s.assemble(metrics)
+	if err != nil {
+		return nil, err
+	}
+	if s.omitPayload(series) {
+		return nil, nil
+	}
+
+	if s.MaxPayloadBytes <= 0 {
+		data, err := s.encode(series)
+		if err != nil {
+			return nil, err
+		}
+		return [][]byte{data}, nil
+	}
+	return s.encodeChunks(series)
+}
+
+// encodeChunks distributes the given series to chunks and encodes those,
+// either serially or concurrently if configured.
+func (s *Serializer) encodeChunks(series []timeSeries) ([][]byte, error) {
+	var groups [][]timeSeries
+	var chunk []timeSeries
+	var size int
+	for _, ts := range series {
+		estimated := estimateSize(ts, s.WriteMetadata && !s.SeparateMetadataRequest)
+		if len(chunk) > 0 && snappy.MaxEncodedLen(size+estimated) > s.MaxPayloadBytes {
+			groups = append(groups, chunk)
+			chunk, size = nil, 0
+		}
+		chunk = append(chunk, ts)
+		size += estimated
+	}
+	if len(chunk) > 0 {
+		groups = append(groups, chunk)
+	}
+
+	if s.CompressionConcurrency > 1 && len(groups) > 1 {
+		return s.encodeChunksConcurrently(groups)
+	}
+
+	var chunks [][]byte
+	var err error
+	for _, group := range groups {
+		if chunks, err = s.appendChunk(chunks, group); err != nil {
+			return nil, err
+		}
+	}
+	return chunks, nil
+}
+
+// encodeChunksConcurrently encodes the given groups of series using the
+// configured number of goroutines as marshaling and compressing many chunks
+// is CPU-bound. The chunks are returned in the order of the groups.
+func (s *Serializer) encodeChunksConcurrently(groups [][]timeSeries) ([][]byte, error) {
+	results := make([][][]byte, len(groups))
+	errs := make([]error, len(groups))
+
+	indices := make(chan int)
+	var wg sync.WaitGroup
+	for range min(s.CompressionConcurrency, len(groups)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indices {
+				results[i], errs[i] = s.appendChunk(nil, groups[i])
+			}
+		}()
+	}
+	for i := range groups {
+		indices <- i
+	}
+	close(indices)
+	wg.Wait()
+
+	chunks := make([][]byte, 0, len(groups))
+	for i, result := range results {
+		if errs[i] != nil {
+			return nil, errs[i]
+		}
+		chunks = append(chunks, result...)
+	}
+	return chunks, nil
+}
+
+// SerializeBatchPartial serializes the leading metrics of the given batch
+// whose series are estimated to fit into the configured memory budget for
+// assembling series. The metrics not serialized are returned alongside the
+// payload and should be passed to subsequent calls until no metrics remain.
+// At least one metric is serialized per call to guarantee progress. Please
+// note, histograms and summaries spanning the boundary of two calls are split.
+func (s *Serializer) SerializeBatchPartial(metrics []telegraf.Metric) (data []byte, remaining []telegraf.Metric, err error) {
+	n := len(metrics)
+	if s.MaxAssemblyBytes > 0 {
+		var size int
+		for i, m := range metrics {
+			size += estimateMetricMemory(m)
+			if i > 0 && size > s.MaxAssemblyBytes {
+				n = i
+				break
+			}
+		}
+	}
+
+	if data, err = s.SerializeBatch(metrics[:n]); err != nil {
+		return nil, nil, err
+	}
+	return data, metrics[n:], nil
+}
+
+// Approximate memory overhead of a series and a label in addition to the
+// label names and values.
+const (
+	seriesMemoryOverhead = 160
+	labelMemoryOverhead  = 48
+)
+
+// estimateMetricMemory returns a rough estimate of the memory occupied by the
+// series assembled from the given metric, i.e. one series per field with the
+// metric name and the tags as labels.
+func estimateMetricMemory(m telegraf.Metric) int {
+	labels := labelMemoryOverhead + len(model.MetricNameLabel) + len(m.Name())
+	for _, tag := range m.TagList() {
+		labels += labelMemoryOverhead + len(tag.Key) + len(tag.Value)
+	}
+
+	var size int
+	for _, field := range m.FieldList() {
+		size += seriesMemoryOverhead + labels + len(field.Key)
+	}
+	return size
+}
+
+// appendChunk encodes the given series and appends the result to the chunks.
+// The series are split in halves if the payload exceeds the size limit.
+func (s *Serializer) appendChunk(chunks [][]byte, series []timeSeries) ([][]byte, error) {
+	data, err := s.encode(series)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) <= s.MaxPayloadBytes {
+		return append(chunks, data), nil
+	}
+	if len(series) == 1 {
+		return nil, fmt.Errorf("series %q exceeds the maximum payload size of %d bytes", seriesName(series[0].Labels), s.MaxPayloadBytes)
+	}
+
+	half := len(series) / 2
+	if chunks, err = s.appendChunk(chunks, series[:half]); err != nil {
+		return nil, err
+	}
+	return s.appendChunk(chunks, series[half:])
+}
+
+// estimateSize returns the size of the series within the marshaled request
+// including the field tag and length prefix. The metadata is accounted for
+// each series as the families contained in a chunk are unknown in advance.
+func estimateSize(ts timeSeries, withMetadata bool) int {
+	size := ts.TimeSeries.Size()
+	size += 1 + len(binary.AppendUvarint(nil, uint64(size)))
+	if withMetadata {
+		n := ts.metadata.Size()
+		size += n + 1 + len(binary.AppendUvarint(nil, uint64(n)))
+	}
+	return size
+}
@@ -0,0 +1,249 @@
+package prometheusremotewrite
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/stretchr/testify/require"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/testutil"
+)
+
+func TestRemoteWriteSerializeBatchChunked(t *testing.T) {
+	metrics := make([]telegraf.Metric, 0, 200)
+	for i := range 200 {
+		metrics = append(metrics, testutil.MustMetric(
+			"cpu",
+			map[string]string{"host": fmt.Sprintf("host-%03d.example.org", i)},
+			map[string]interface{}{"time_idle": float64(i)},
+			time.Unix(0, 0),
+		))
+	}
+
+	s := &Serializer{
+		Log:             &testutil.CaptureLogger{},
+		MaxPayloadBytes: 1024,
+	}
+	require.NoError(t, s.Init())
+
+	chunks, err := s.SerializeBatchChunked(metrics)
+	require.NoError(t, err)
+	require.Greater(t, len(chunks), 1)
+
+	var total int
+	for _, chunk := range chunks {
+		require.LessOrEqual(t, len(chunk), 1024)
+
+		decompressed, err := snappy.Decode(nil, chunk)
+		require.NoError(t, err)
+		require.LessOrEqual(t, len(decompressed), 1024)
+
+		req, err := DecodePayload(chunk)
+		require.NoError(t, err)
+		total += len(req.Timeseries)
+	}
+	require.Equal(t, 200, total)
+}
+
+func TestRemoteWriteSerializeBatchChunkedUnlimited(t *testing.T) {
+	s := &Serializer{
+		Log:         &testutil.CaptureLogger{},
+		SortMetrics: true,
+	}
+	require.NoError(t, s.Init())
+
+	chunks, err := s.SerializeBatchChunked(protocolTestMetrics)
+	require.NoError(t, err)
+	require.Len(t, chunks, 1)
+
+	expected, err := s.SerializeBatch(protocolTestMetrics)
+	require.NoError(t, err)
+	require.Equal(t, expected, chunks[0])
+}
+
+func TestRemoteWriteSerializeBatchChunkedSeriesTooLarge(t *testing.T) {
+	m := testutil.MustMetric(
+		"cpu",
+		map[string]string{"host": incompressibleValue(2048)},
+		map[string]interface{}{"time_idle": 42.0},
+		time.Unix(0, 0),
+	)
+
+	s := &Serializer{
+		Log:             &testutil.CaptureLogger{},
+		MaxPayloadBytes: 1024,
+	}
+	require.NoError(t, s.Init())
+
+	_, err := s.SerializeBatchChunked([]telegraf.Metric{m})
+	require.ErrorContains(t, err, `series "cpu_time_idle" exceeds the maximum payload size of 1024 bytes`)
+}
+
+func TestRemoteWriteSerializeBatchChunkedCompressionConcurrency(t *testing.T) {
+	metrics := make([]telegraf.Metric, 0, 200)
+	for i := range 200 {
+		metrics = append(metrics, testutil.MustMetric(
+			"cpu",
+			map[string]string{"host": fmt.Sprintf("host-%03d.example.org", i)},
+			map[string]interface{}{"time_idle": float64(i)},
+			time.Unix(0, 0),
+		))
+	}
+
+	serial := &Serializer{
+		Log:             &testutil.CaptureLogger{},
+		SortMetrics:     true,
+		MaxPayloadBytes: 1024,
+	}
+	require.NoError(t, serial.Init())
+	expected, err := serial.SerializeBatchChunked(metrics)
+	require.NoError(t, err)
+	require.Greater(t, len(expected), 4)
+
+	s := &Serializer{
+		Log:                    &testutil.CaptureLogger{},
+		SortMetrics:            true,
+		MaxPayloadBytes:        1024,
+		CompressionConcurrency: 4,
+	}
+	require.NoError(t, s.Init())
+
+	chunks, err := s.SerializeBatchChunked(metrics)
+	require.NoError(t, err)
+	require.Equal(t, expected, chunks)
+
+	// The series must be in the sorted order across all chunks
+	var i int
+	for _, chunk := range chunks {
+		require.LessOrEqual(t, len(chunk), 1024)
+
+		req, err := DecodePayload(chunk)
+		require.NoError(t, err)
+		for _, ts := range req.Timeseries {
+			host, found := labelValue(ts.Labels, "host")
+			require.True(t, found)
+			require.Equal(t, fmt.Sprintf("host-%03d.example.org", i), host)
+			require.Equal(t, float64(i), ts.Samples[0].Value)
+			i++
+		}
+	}
+	require.Equal(t, 200, i)
+}
+
+func TestRemoteWriteSerializeBatchChunkedCompressionConcurrencySeriesTooLarge(t *testing.T) {
+	metrics := []telegraf.Metric{
+		testutil.MustMetric(
+			"cpu",
+			map[string]string{"host": "a"},
+			map[string]interface{}{"time_idle": 42.0},
+			time.Unix(0, 0),
+		),
+		testutil.MustMetric(
+			"mem",
+			map[string]string{"host": incompressibleValue(2048)},
+			map[string]interface{}{"free": 42.0},
+			time.Unix(0, 0),
+		),
+	}
+
+	s := &Serializer{
+		Log:                    &testutil.CaptureLogger{},
+		MaxPayloadBytes:        1024,
+		CompressionConcurrency: 2,
+	}
+	require.NoError(t, s.Init())
+
+	_, err := s.SerializeBatchChunked(metrics)
+	require.ErrorContains(t, err, `series "mem_free" exceeds the maximum payload size of 1024 bytes`)
+}
+
+func BenchmarkSerializeBatchChunkedCompressionConcurrency(b *testing.B) {
+	metrics := concurrencyTestMetrics(1000)
+	for _, concurrency := range []int{1, 2, 4, 8} {
+		b.Run(fmt.Sprintf("%d goroutines", concurrency), func(b *testing.B) {
+			s := &Serializer{
+				Log:                    &testutil.CaptureLogger{},
+				MaxPayloadBytes:        16 * 1024,
+				CompressionConcurrency: concurrency,
+			}
+			require.NoError(b, s.Init())
+
+			// Only measure encoding the chunks, not the conversion
+			series, _, err := s.assemble(metrics)
+			require.NoError(b, err)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				_, err := s.encodeChunks(series)
+				require.NoError(b, err)
+			}
+		})
+	}
+}
+
+func TestRemoteWriteSerializeBatchPartial(t *testing.T) {
+	metrics := make([]telegraf.Metric, 0, 200)
+	for i := range 200 {
+		metrics = append(metrics, testutil.MustMetric(
+			"cpu",
+			map[string]string{"host": fmt.Sprintf("host-%03d.example.org", i)},
+			map[string]interface{}{"time_idle": float64(i)},
+			time.Unix(0, 0),
+		))
+	}
+	budget := 10 * estimateMetricMemory(metrics[0])
+
+	s := &Serializer{
+		Log:              &testutil.CaptureLogger{},
+		MaxAssemblyBytes: budget,
+	}
+	require.NoError(t, s.Init())
+
+	var calls, total int
+	remaining := metrics
+	for len(remaining) > 0 {
+		var data []byte
+		var err error
+		data, remaining, err = s.SerializeBatchPartial(remaining)
+		require.NoError(t, err)
+		calls++
+
+		req, err := DecodePayload(data)
+		require.NoError(t, err)
+		require.Len(t, req.Timeseries, 10)
+		total += len(req.Timeseries)
+	}
+	require.Equal(t, 20, calls)
+	require.Equal(t, 200, total)
+}
+
+func TestRemoteWriteSerializeBatchPartialUnlimited(t *testing.T) {
+	s := &Serializer{
+		Log:         &testutil.CaptureLogger{},
+		SortMetrics: true,
+	}
+	require.NoError(t, s.Init())
+
+	data, remaining, err := s.SerializeBatchPartial(protocolTestMetrics)
+	require.NoError(t, err)
+	require.Empty(t, remaining)
+
+	expected, err := s.SerializeBatch(protocolTestMetrics)
+	require.NoError(t, err)
+	require.Equal(t, expected, data)
+}
+
+// incompressibleValue returns a random value of the given length not shrinking
+// when compressed.
+func incompressibleValue(n int) string {
+	const chars = "abcdefghijklmnopqrstuvwxyz0123456789"
+	r := rand.New(rand.NewSource(1))
+	value := make([]byte, n)
+	for i := range value {
+		value[i] = chars[r.Intn(len(chars))]
+	}
+	return string(value)
+}
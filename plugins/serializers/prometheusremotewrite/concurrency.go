@@ -0,0 +1,152 @@
+package prometheusremotewrite
+
+import (
+	"context"
+	"hash/fnv"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/influxdata/telegraf"
+)
+
+// convertConcurrently converts the metrics in partitions using the configured
+// number of goroutines and merges the results. Metrics contributing to the
+// same series are placed in the same partition in their original order to
+// keep the deduplication, histogram and counter semantics of the serial path.
+func (s *Serializer) convertConcurrently(ctx context.Context, metrics []telegraf.Metric, labelSet map[string]string, now time.Time) (*conversion, error) {
+	partitions := make([][]telegraf.Metric, s.Concurrency)
+	indices := make([][]int, s.Concurrency)
+	for i, m := range metrics {
+		idx := s.partitionKey(m) % uint64(s.Concurrency)
+		partitions[idx] = append(partitions[idx], m)
+		indices[idx] = append(indices[idx], i)
+	}
+
+	results := make([]*conversion, len(partitions))
+	errs := make([]error, len(partitions))
+	var wg sync.WaitGroup
+	for i, partition := range partitions {
+		if len(partition) == 0 {
+			continue
+		}
+		wg.Add(1)
+		go func(i int, partition []telegraf.Metric) {
+			defer wg.Done()
+			results[i], errs[i] = s.convertPartition(ctx, partition, labelSet, now)
+		}(i, partition)
+	}
+	wg.Wait()
+
+	merged := newConversion()
+	for i, result := range results {
+		if errs[i] != nil {
+			return nil, errs[i]
+		}
+		if result != nil {
+			// Refer to the position of the metric in the whole batch
+			for name, occurrence := range result.familyTypes {
+				occurrence.index = indices[i][occurrence.index]
+				result.familyTypes[name] = occurrence
+			}
+			merged.merge(result)
+		}
+	}
+	return merged, nil
+}
+
+// merge adds the given conversion result keeping the newer sample for series
+// contained in both. Placeholders never replace real samples but are replaced
+// by those. Series with conflicting metric types are kept as is.
+func (c *conversion) merge(other *conversion) {
+	for key, ts := range other.entries {
+		if existing, found := c.entries[key]; found {
+			if existing.metadata.Type != ts.metadata.Type {
+				c.typeConflicts[seriesName(ts.Labels)] = true
+				continue
+			}
+			if !c.placeholders[key] && (other.placeholders[key] || sampleTime(&ts.TimeSeries) < sampleTime(&existing.TimeSeries)) {
+				continue
+			}
+		}
+		c.entries[key] = ts
+		if other.placeholders[key] {
+			c.placeholders[key] = true
+		} else {
+			delete(c.placeholders, key)
+		}
+	}
+	for key, countKey := range other.quantileBuckets {
+		c.quantileBuckets[key] = countKey
+	}
+	for key, total := range other.totals {
+		c.totals[key] = total
+	}
+	for key := range other.buckets {
+		c.buckets[key] = true
+	}
+	for key := range other.duplicateBuckets {
+		c.duplicateBuckets[key] = true
+	}
+	for key := range other.typeConflicts {
+		c.typeConflicts[key] = true
+	}
+	for name, occurrence := range other.familyTypes {
+		if existing, found := c.familyTypes[name]; !found || occurrence.index < existing.index {
+			c.familyTypes[name] = occurrence
+		}
+	}
+	for key := range other.substituted {
+		c.substituted[key] = true
+	}
+	for key := range other.shortenedNames {
+		c.shortenedNames[key] = true
+	}
+	for key := range other.reservedNames {
+		c.reservedNames[key] = true
+	}
+	for key := range other.clampedCounters {
+		c.clampedCounters[key] = true
+	}
+	for key := range other.labelCollisions {
+		c.labelCollisions[key] = true
+	}
+	c.substitutedTimestamps += other.substitutedTimestamps
+	c.clampedTimestamps += other.clampedTimestamps
+	c.limitedSeries += other.limitedSeries
+	c.dropped += other.dropped
+	if other.lastErr != nil {
+		c.lastErr = other.lastErr
+	}
+}
+
+// partitionKey hashes the labels shared by all series of the metric ignoring
+// the histogram and summary labels. Metrics resulting in the same series end
+// up in the same partition even if their tags differ, e.g. by tags not
+// converted to labels, as do all metrics of a histogram or summary.
+func (s *Serializer) partitionKey(m telegraf.Metric) uint64 {
+	labels, _ := s.appendCommonLabels(nil, m)
+	if s.AggregationLabel != "" {
+		if aggregation, found := m.GetTag(s.AggregationLabel); found && aggregation != "" {
+			labels = replaceLabel(labels, "__aggregation__", aggregation)
+		}
+	}
+	if len(s.TagsAsTargetInfo) > 0 {
+		labels, _ = s.splitTargetInfoLabels(labels)
+	}
+
+	sort.Slice(labels, func(i, j int) bool { return labels[i].Name < labels[j].Name })
+
+	h := fnv.New64a()
+	for _, label := range labels {
+		switch label.Name {
+		case "le", "quantile", s.bucketLabel(), s.quantileLabel():
+			continue
+		}
+		h.Write([]byte(label.Name))
+		h.Write([]byte("\x00"))
+		h.Write([]byte(label.Value))
+		h.Write([]byte("\x00"))
+	}
+	return h.Sum64()
+}
@@ -0,0 +1,211 @@
+package prometheusremotewrite
+
+import (
+	"fmt"
+	"maps"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/testutil"
+)
+
+func TestRemoteWriteSerializeBatchConcurrency(t *testing.T) {
+	metrics := concurrencyTestMetrics(100)
+
+	serial := &Serializer{
+		Log:                &testutil.CaptureLogger{},
+		SortMetrics:        true,
+		SummaryToHistogram: true,
+	}
+	require.NoError(t, serial.Init())
+	expected, err := serial.SerializeBatch(metrics)
+	require.NoError(t, err)
+
+	for _, concurrency := range []int{2, 4, 7} {
+		t.Run(fmt.Sprintf("%d goroutines", concurrency), func(t *testing.T) {
+			s := &Serializer{
+				Log:                &testutil.CaptureLogger{},
+				SortMetrics:        true,
+				SummaryToHistogram: true,
+				Concurrency:        concurrency,
+			}
+			require.NoError(t, s.Init())
+
+			actual, err := s.SerializeBatch(metrics)
+			require.NoError(t, err)
+			require.Equal(t, expected, actual)
+		})
+	}
+}
+
+func TestRemoteWriteSerializeBatchConcurrencyIgnoredTags(t *testing.T) {
+	tests := []struct {
+		name   string
+		opts   func(s *Serializer)
+		first  map[string]string
+		second map[string]string
+	}{
+		{
+			name: "help, drop and aggregation tags",
+			opts: func(s *Serializer) {
+				s.HelpTag = "help"
+				s.DropTag = "drop"
+				s.DropTagValue = "true"
+				s.AggregationLabel = "agg"
+			},
+			first:  map[string]string{"agg": "sum", "help": "first"},
+			second: map[string]string{"agg": "sum", "drop": "false"},
+		},
+		{
+			name:   "dropped host tag",
+			opts:   func(s *Serializer) { s.HostTagBehavior = "drop" },
+			first:  map[string]string{"host": "a"},
+			second: map[string]string{"host": "b"},
+		},
+		{
+			name:   "renamed host tag",
+			opts:   func(s *Serializer) { s.HostTagBehavior = "rename-to-instance" },
+			first:  map[string]string{"host": "a"},
+			second: map[string]string{"instance": "a"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Pairs of metrics resulting in the same series with the same
+			// timestamp where the latter one wins.
+			metrics := make([]telegraf.Metric, 0, 100)
+			for i := range 50 {
+				for v, tags := range []map[string]string{tt.first, tt.second} {
+					tags = maps.Clone(tags)
+					tags["id"] = strconv.Itoa(i)
+					metrics = append(metrics, testutil.MustMetric(
+						"cpu",
+						tags,
+						map[string]interface{}{"v": float64(v + 1)},
+						time.Unix(10, 0),
+					))
+				}
+			}
+
+			serial := &Serializer{
+				Log:         &testutil.CaptureLogger{},
+				SortMetrics: true,
+			}
+			tt.opts(serial)
+			require.NoError(t, serial.Init())
+			expected, err := serial.SerializeBatch(metrics)
+			require.NoError(t, err)
+			text, err := prompbToText(expected)
+			require.NoError(t, err)
+			require.NotContains(t, string(text), "} 1")
+
+			for concurrency := 2; concurrency <= 5; concurrency++ {
+				s := &Serializer{
+					Log:         &testutil.CaptureLogger{},
+					SortMetrics: true,
+					Concurrency: concurrency,
+				}
+				tt.opts(s)
+				require.NoError(t, s.Init())
+
+				actual, err := s.SerializeBatch(metrics)
+				require.NoError(t, err)
+				require.Equal(t, expected, actual, "concurrency %d", concurrency)
+			}
+		})
+	}
+}
+
+func BenchmarkSerializeBatchConcurrency(b *testing.B) {
+	metrics := concurrencyTestMetrics(1000)
+	for _, concurrency := range []int{1, 2, 4, 8} {
+		b.Run(fmt.Sprintf("%d goroutines", concurrency), func(b *testing.B) {
+			s := &Serializer{
+				Log:         &testutil.CaptureLogger{},
+				Concurrency: concurrency,
+			}
+			require.NoError(b, s.Init())
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				_, err := s.SerializeBatch(metrics)
+				require.NoError(b, err)
+			}
+		})
+	}
+}
+
+// concurrencyTestMetrics creates gauges, histograms and summaries for the
+// given number of hosts including outdated and duplicate samples.
+func concurrencyTestMetrics(hosts int) []telegraf.Metric {
+	metrics := make([]telegraf.Metric, 0, 8*hosts)
+	for i := range hosts {
+		host := fmt.Sprintf("host%d", i)
+		metrics = append(metrics,
+			testutil.MustMetric(
+				"cpu",
+				map[string]string{"host": host},
+				map[string]interface{}{"time_idle": float64(i), "time_user": 42.0},
+				time.Unix(10, 0),
+			),
+			testutil.MustMetric(
+				"cpu",
+				map[string]string{"host": host},
+				map[string]interface{}{"time_idle": float64(i + 1)},
+				time.Unix(5, 0),
+			),
+			testutil.MustMetric(
+				"cpu",
+				map[string]string{"host": host},
+				map[string]interface{}{"time_user": 43.0},
+				time.Unix(10, 0),
+			),
+			testutil.MustMetric(
+				"prometheus",
+				map[string]string{"host": host},
+				map[string]interface{}{
+					"http_request_duration_seconds_sum":   53423.0,
+					"http_request_duration_seconds_count": 144320.0,
+				},
+				time.Unix(10, 0),
+				telegraf.Histogram,
+			),
+			testutil.MustMetric(
+				"prometheus",
+				map[string]string{"host": host, "le": "0.5"},
+				map[string]interface{}{"http_request_duration_seconds_bucket": 129389.0},
+				time.Unix(10, 0),
+				telegraf.Histogram,
+			),
+			testutil.MustMetric(
+				"prometheus",
+				map[string]string{"host": host, "le": "+Inf"},
+				map[string]interface{}{"http_request_duration_seconds_bucket": 144320.0},
+				time.Unix(10, 0),
+				telegraf.Histogram,
+			),
+			testutil.MustMetric(
+				"prometheus",
+				map[string]string{"host": host},
+				map[string]interface{}{
+					"rpc_duration_seconds_sum":   1.7560473e+07,
+					"rpc_duration_seconds_count": 2693.0,
+				},
+				time.Unix(10, 0),
+				telegraf.Summary,
+			),
+			testutil.MustMetric(
+				"prometheus",
+				map[string]string{"host": host, "quantile": "0.5"},
+				map[string]interface{}{"rpc_duration_seconds": 4773.0},
+				time.Unix(10, 0),
+				telegraf.Summary,
+			),
+		)
+	}
+	return metrics
+}
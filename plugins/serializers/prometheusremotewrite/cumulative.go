@@ -0,0 +1,58 @@
+package prometheusremotewrite
+
+import "sync"
+
+// cumulativeTotal is the running total of a counter series together with the
+// timestamp of the last delta added in milliseconds.
+type cumulativeTotal struct {
+	value     float64
+	timestamp int64
+}
+
+// cumulativeTotals keeps the running totals of counter series fed with
+// deltas across batches.
+type cumulativeTotals struct {
+	totals map[MetricKey]cumulativeTotal
+	sync.Mutex
+}
+
+// get returns the total of the series if any
+func (c *cumulativeTotals) get(key MetricKey) (cumulativeTotal, bool) {
+	c.Lock()
+	defer c.Unlock()
+
+	total, found := c.totals[key]
+	return total, found
+}
+
+// record replaces the totals of the given series
+func (c *cumulativeTotals) record(totals map[MetricKey]cumulativeTotal) {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.totals == nil {
+		c.totals = make(map[MetricKey]cumulativeTotal, len(totals))
+	}
+	for key, total := range totals {
+		c.totals[key] = total
+	}
+}
+
+// accumulate adds the given delta with the given timestamp to the total of
+// the series and returns the new total. The new total is kept in the
+// conversion to be recorded once the batch is complete. Deltas not newer than
+// the last added one are considered to be resent, e.g. when serializing a
+// batch again after a failed write, and are ignored returning the current
+// total.
+func (c *conversion) accumulate(totals *cumulativeTotals, key MetricKey, delta float64, timestamp int64) float64 {
+	total, found := c.totals[key]
+	if !found {
+		total, found = totals.get(key)
+	}
+	if !found || timestamp > total.timestamp {
+		total.value += delta
+		total.timestamp = timestamp
+	}
+	c.totals[key] = total
+	return total.value
+}
@@ -0,0 +1,39 @@
+package prometheusremotewrite
+
+import (
+	"fmt"
+
+	"github.com/golang/snappy"
+	"github.com/prometheus/prometheus/prompb"
+	writev2 "github.com/prometheus/prometheus/prompb/io/prometheus/write/v2"
+)
+
+// DecodePayload decompresses and unmarshals a payload produced by the
+// serializer into a remote-write request.
+func DecodePayload(data []byte) (*prompb.WriteRequest, error) {
+	buf, err := snappy.Decode(nil, data)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decompress payload: %w", err)
+	}
+
+	var req prompb.WriteRequest
+	if err := req.Unmarshal(buf); err != nil {
+		return nil, fmt.Errorf("unable to unmarshal protobuf: %w", err)
+	}
+	return &req, nil
+}
+
+// DecodePayloadV2 decompresses and unmarshals a payload produced by the
+// serializer using the remote-write 2.0 protocol.
+func DecodePayloadV2(data []byte) (*writev2.Request, error) {
+	buf, err := snappy.Decode(nil, data)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decompress payload: %w", err)
+	}
+
+	var req writev2.Request
+	if err := req.Unmarshal(buf); err != nil {
+		return nil, fmt.Errorf("unable to unmarshal protobuf: %w", err)
+	}
+	return &req, nil
+}
@@ -0,0 +1,46 @@
+package prometheusremotewrite
+
+import (
+	"testing"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/stretchr/testify/require"
+
+	"github.com/influxdata/telegraf/testutil"
+)
+
+func TestRemoteWriteDecodePayload(t *testing.T) {
+	s := &Serializer{Log: &testutil.CaptureLogger{}}
+	m := testutil.MustMetric(
+		"cpu",
+		map[string]string{"host": "example.org"},
+		map[string]interface{}{"time_idle": 42.0},
+		time.Unix(1, 0),
+	)
+	data, err := s.Serialize(m)
+	require.NoError(t, err)
+
+	req, err := DecodePayload(data)
+	require.NoError(t, err)
+
+	expected := []prompb.TimeSeries{
+		{
+			Labels: []prompb.Label{
+				{Name: "__name__", Value: "cpu_time_idle"},
+				{Name: "host", Value: "example.org"},
+			},
+			Samples: []prompb.Sample{{Value: 42.0, Timestamp: 1000}},
+		},
+	}
+	require.Equal(t, expected, req.Timeseries)
+}
+
+func TestRemoteWriteDecodePayloadInvalid(t *testing.T) {
+	_, err := DecodePayload([]byte("not snappy"))
+	require.ErrorContains(t, err, "unable to decompress payload")
+
+	_, err = DecodePayload(snappy.Encode(nil, []byte{0xff, 0xff}))
+	require.ErrorContains(t, err, "unable to unmarshal protobuf")
+}
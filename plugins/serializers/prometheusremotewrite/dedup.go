@@ -0,0 +1,63 @@
+package prometheusremotewrite
+
+import (
+	"sync"
+	"time"
+)
+
+// seenSample is the latest sample timestamp of a series serialized by the
+// serializer together with the time of the last update.
+type seenSample struct {
+	timestamp int64
+	updated   time.Time
+}
+
+// dedupCache keeps the latest serialized sample timestamp per series across
+// batches. Series not updated within the TTL are evicted to bound the memory.
+type dedupCache struct {
+	ttl  time.Duration
+	seen map[MetricKey]seenSample
+	sync.Mutex
+}
+
+// filter removes all series with samples older than the ones serialized
+// before. Samples with the same timestamp are kept to allow resending a
+// batch, e.g. after a failed write. The timestamps of the remaining series
+// are added to the given timestamps to be recorded once the batch is
+// complete.
+func (c *dedupCache) filter(series []timeSeries, now time.Time, timestamps map[MetricKey]int64) (kept []timeSeries, dropped int) {
+	c.Lock()
+	defer c.Unlock()
+
+	kept = series[:0]
+	for _, ts := range series {
+		key := MakeMetricKey(ts.Labels)
+		timestamp := sampleTime(&ts.TimeSeries)
+		if entry, found := c.seen[key]; found && now.Sub(entry.updated) <= c.ttl && timestamp < entry.timestamp {
+			dropped++
+			continue
+		}
+		timestamps[key] = timestamp
+		kept = append(kept, ts)
+	}
+	return kept, dropped
+}
+
+// record records the given latest sample timestamps of serialized series and
+// evicts the series not updated within the TTL.
+func (c *dedupCache) record(timestamps map[MetricKey]int64, now time.Time) {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.seen == nil {
+		c.seen = make(map[MetricKey]seenSample)
+	}
+	for key, entry := range c.seen {
+		if now.Sub(entry.updated) > c.ttl {
+			delete(c.seen, key)
+		}
+	}
+	for key, timestamp := range timestamps {
+		c.seen[key] = seenSample{timestamp: timestamp, updated: now}
+	}
+}
@@ -0,0 +1,82 @@
+package prometheusremotewrite
+
+import (
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/prometheus/prometheus/prompb"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/plugins/serializers/prometheus"
+)
+
+// isExemplarField checks if the given field is consumed for constructing
+// exemplars and thus must not be serialized as sample or label.
+func (s *Serializer) isExemplarField(key string) bool {
+	if s.ExemplarField == "" {
+		return false
+	}
+	return key == s.ExemplarField || slices.Contains(s.ExemplarLabelFields, key)
+}
+
+// maxExemplars returns the maximum number of exemplars per series defaulting
+// to a single exemplar.
+func (s *Serializer) maxExemplars() int {
+	if s.MaxExemplarsPerSeries == nil {
+		return 1
+	}
+	return *s.MaxExemplarsPerSeries
+}
+
+// limitExemplars returns the most recent exemplars up to the configured
+// maximum number of exemplars per series ordered by timestamp.
+func (s *Serializer) limitExemplars(exemplars []prompb.Exemplar) []prompb.Exemplar {
+	sort.SliceStable(exemplars, func(i, j int) bool {
+		return exemplars[i].Timestamp < exemplars[j].Timestamp
+	})
+	if limit := s.maxExemplars(); len(exemplars) > limit {
+		exemplars = exemplars[len(exemplars)-limit:]
+	}
+	return exemplars
+}
+
+// exemplar constructs an exemplar from the configured fields of the metric.
+// The exemplar uses the given sample timestamp and string-valued label fields
+// such as trace or span IDs as exemplar labels.
+func (s *Serializer) exemplar(metric telegraf.Metric, timestamp time.Time) (prompb.Exemplar, bool) {
+	if s.ExemplarField == "" || s.maxExemplars() == 0 {
+		return prompb.Exemplar{}, false
+	}
+	raw, ok := metric.GetField(s.ExemplarField)
+	if !ok {
+		return prompb.Exemplar{}, false
+	}
+	value, ok := prometheus.SampleValue(raw)
+	if !ok {
+		return prompb.Exemplar{}, false
+	}
+
+	labels := make([]prompb.Label, 0, len(s.ExemplarLabelFields))
+	for _, key := range s.ExemplarLabelFields {
+		raw, ok := metric.GetField(key)
+		if !ok {
+			continue
+		}
+		v, ok := raw.(string)
+		if !ok || v == "" {
+			continue
+		}
+		name, ok := prometheus.SanitizeLabelName(key)
+		if !ok {
+			continue
+		}
+		labels = append(labels, prompb.Label{Name: name, Value: v})
+	}
+
+	return prompb.Exemplar{
+		Labels:    labels,
+		Value:     value,
+		Timestamp: timestamp.UnixNano() / int64(time.Millisecond),
+	}, true
+}
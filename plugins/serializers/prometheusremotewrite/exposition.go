@@ -0,0 +1,178 @@
+package prometheusremotewrite
+
+import (
+	"bytes"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/prompb"
+
+	"github.com/influxdata/telegraf"
+)
+
+// helpEscaper escapes help texts according to the text exposition format
+var helpEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+
+// SerializeBatchExposition serializes the given metrics in the Prometheus
+// text exposition format instead of a remote-write request. The metrics are
+// converted in the same way as for SerializeBatch and the resulting series
+// are grouped by metric family with the help text and type of the family.
+// Samples are written with their timestamp.
+func (s *Serializer) SerializeBatchExposition(metrics []telegraf.Metric) ([]byte, error) {
+	series, _, err := s.assemble(metrics)
+	if err != nil {
+		return nil, err
+	}
+
+	families := make(map[string][]timeSeries)
+	for _, ts := range series {
+		if len(ts.Samples) == 0 {
+			continue
+		}
+		name := ts.metadata.MetricFamilyName
+		families[name] = append(families[name], ts)
+	}
+	names := make([]string, 0, len(families))
+	for name := range families {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if pi, pj := s.familyPriority(names[i]), s.familyPriority(names[j]); pi != pj {
+			return pi < pj
+		}
+		return names[i] < names[j]
+	})
+
+	var buf bytes.Buffer
+	for _, name := range names {
+		family := families[name]
+		s.sortFamily(name, family)
+
+		for _, ts := range family {
+			if ts.metadata.Help != "" {
+				buf.WriteString("# HELP " + name + " " + helpEscaper.Replace(ts.metadata.Help) + "\n")
+				break
+			}
+		}
+		buf.WriteString("# TYPE " + name + " " + expositionType(family[0].metadata.Type) + "\n")
+
+		for _, ts := range family {
+			for _, sample := range ts.Samples {
+				writeExpositionSeries(&buf, ts.Labels)
+				buf.WriteString(" ")
+				buf.WriteString(formatValue(model.SampleValue(sample.Value)))
+				buf.WriteString(" ")
+				buf.WriteString(strconv.FormatInt(sample.Timestamp, 10))
+				buf.WriteString("\n")
+			}
+		}
+	}
+
+	return buf.Bytes(), nil
+}
+
+// sortFamily sorts the series of a metric family by their labels keeping the
+// buckets or quantiles, the sum and the count of each histogram or summary
+// together in this order. Buckets and quantiles are sorted by their boundary.
+// The bucket and quantile labels are only treated as boundaries for histogram
+// and summary families, for all other types they are ordinary labels.
+func (s *Serializer) sortFamily(name string, family []timeSeries) {
+	type sortEntry struct {
+		ts     timeSeries
+		labels []prompb.Label
+		rank   int
+		bound  float64
+	}
+
+	var boundLabel string
+	switch family[0].metadata.Type {
+	case prompb.MetricMetadata_HISTOGRAM:
+		boundLabel = s.bucketLabel()
+	case prompb.MetricMetadata_SUMMARY:
+		boundLabel = s.quantileLabel()
+	}
+
+	entries := make([]sortEntry, 0, len(family))
+	for _, ts := range family {
+		entry := sortEntry{ts: ts, bound: math.Inf(-1)}
+		for _, l := range ts.Labels {
+			switch {
+			case l.Name == model.MetricNameLabel:
+			case boundLabel != "" && l.Name == boundLabel:
+				entry.bound, _ = strconv.ParseFloat(l.Value, 64)
+			default:
+				entry.labels = append(entry.labels, l)
+			}
+		}
+		switch seriesName(ts.Labels) {
+		case name + "_sum":
+			entry.rank = 1
+		case name + "_count":
+			entry.rank = 2
+		}
+		entries = append(entries, entry)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		if labelsLess(entries[i].labels, entries[j].labels) {
+			return true
+		}
+		if labelsLess(entries[j].labels, entries[i].labels) {
+			return false
+		}
+		if entries[i].rank != entries[j].rank {
+			return entries[i].rank < entries[j].rank
+		}
+		return entries[i].bound < entries[j].bound
+	})
+
+	for i, entry := range entries {
+		family[i] = entry.ts
+	}
+}
+
+// writeExpositionSeries writes the metric name and the labels of the series
+// in the text exposition format.
+func writeExpositionSeries(buf *bytes.Buffer, labels []prompb.Label) {
+	buf.WriteString(seriesName(labels))
+
+	first := true
+	for _, l := range labels {
+		if l.Name == model.MetricNameLabel {
+			continue
+		}
+		if first {
+			buf.WriteString("{")
+			first = false
+		} else {
+			buf.WriteString(",")
+		}
+		buf.WriteString(l.Name)
+		buf.WriteString(`="`)
+		buf.WriteString(labelValueEscaper.Replace(l.Value))
+		buf.WriteString(`"`)
+	}
+	if !first {
+		buf.WriteString("}")
+	}
+}
+
+// expositionType returns the type of the metric family in the text exposition
+// format.
+func expositionType(metricType prompb.MetricMetadata_MetricType) string {
+	switch metricType {
+	case prompb.MetricMetadata_COUNTER:
+		return "counter"
+	case prompb.MetricMetadata_GAUGE:
+		return "gauge"
+	case prompb.MetricMetadata_HISTOGRAM:
+		return "histogram"
+	case prompb.MetricMetadata_SUMMARY:
+		return "summary"
+	default:
+		return "untyped"
+	}
+}
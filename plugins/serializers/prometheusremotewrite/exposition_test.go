@@ -0,0 +1,126 @@
+package prometheusremotewrite
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/expfmt"
+	"github.com/stretchr/testify/require"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/testutil"
+)
+
+func TestRemoteWriteSerializeBatchExposition(t *testing.T) {
+	metrics := []telegraf.Metric{
+		testutil.MustMetric(
+			"http",
+			map[string]string{"code": "200", "help": "Total number of HTTP requests."},
+			map[string]interface{}{"requests_total": 1027.0},
+			time.Unix(0, 0),
+			telegraf.Counter,
+		),
+		testutil.MustMetric(
+			"http",
+			map[string]string{"code": "400"},
+			map[string]interface{}{"requests_total": 3.0},
+			time.Unix(0, 0),
+			telegraf.Counter,
+		),
+		testutil.MustMetric(
+			"prometheus",
+			map[string]string{"le": "0.5"},
+			map[string]interface{}{"http_request_duration_seconds_bucket": 129389.0},
+			time.Unix(0, 0),
+			telegraf.Histogram,
+		),
+		testutil.MustMetric(
+			"prometheus",
+			map[string]string{"le": "+Inf"},
+			map[string]interface{}{"http_request_duration_seconds_bucket": 144320.0},
+			time.Unix(0, 0),
+			telegraf.Histogram,
+		),
+		testutil.MustMetric(
+			"prometheus",
+			map[string]string{"le": "0.05"},
+			map[string]interface{}{"http_request_duration_seconds_bucket": 24054.0},
+			time.Unix(0, 0),
+			telegraf.Histogram,
+		),
+		testutil.MustMetric(
+			"prometheus",
+			map[string]string{},
+			map[string]interface{}{
+				"http_request_duration_seconds_sum":   53423.0,
+				"http_request_duration_seconds_count": 144320.0,
+			},
+			time.Unix(0, 0),
+			telegraf.Histogram,
+		),
+		testutil.MustMetric(
+			"cpu",
+			map[string]string{"host": "example.org"},
+			map[string]interface{}{"time_idle": 42.5},
+			time.Unix(1, 0),
+		),
+	}
+
+	s := &Serializer{
+		Log:     &testutil.CaptureLogger{},
+		HelpTag: "help",
+	}
+	require.NoError(t, s.Init())
+
+	actual, err := s.SerializeBatchExposition(metrics)
+	require.NoError(t, err)
+
+	expected := `# TYPE cpu_time_idle untyped
+cpu_time_idle{host="example.org"} 42.5 1000
+# TYPE http_request_duration_seconds histogram
+http_request_duration_seconds_bucket{le="0.05"} 24054 0
+http_request_duration_seconds_bucket{le="0.5"} 129389 0
+http_request_duration_seconds_bucket{le="+Inf"} 144320 0
+http_request_duration_seconds_sum 53423 0
+http_request_duration_seconds_count 144320 0
+# HELP http_requests_total Total number of HTTP requests.
+# TYPE http_requests_total counter
+http_requests_total{code="200"} 1027 0
+http_requests_total{code="400"} 3 0
+`
+	require.Equal(t, expected, string(actual))
+
+	var parser expfmt.TextParser
+	families, err := parser.TextToMetricFamilies(bytes.NewReader(actual))
+	require.NoError(t, err)
+	require.Len(t, families, 3)
+	require.Equal(t, uint64(144320), families["http_request_duration_seconds"].Metric[0].Histogram.GetSampleCount())
+	require.Len(t, families["http_request_duration_seconds"].Metric[0].Histogram.Bucket, 3)
+}
+
+func TestRemoteWriteSerializeBatchExpositionBoundLabelsOnGauge(t *testing.T) {
+	var metrics []telegraf.Metric
+	for _, le := range []string{"10", "9", "+Inf"} {
+		metrics = append(metrics, testutil.MustMetric(
+			"queue",
+			map[string]string{"le": le},
+			map[string]interface{}{"wait_bucket": 1.0},
+			time.Unix(0, 0),
+			telegraf.Gauge,
+		))
+	}
+
+	s := &Serializer{Log: &testutil.CaptureLogger{}}
+	require.NoError(t, s.Init())
+
+	actual, err := s.SerializeBatchExposition(metrics)
+	require.NoError(t, err)
+
+	expected := `# TYPE queue_wait_bucket gauge
+queue_wait_bucket{le="+Inf"} 1 0
+queue_wait_bucket{le="10"} 1 0
+queue_wait_bucket{le="9"} 1 0
+`
+	require.Equal(t, expected, string(actual))
+}
@@ -0,0 +1,88 @@
+package prometheusremotewrite
+
+import (
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"strconv"
+)
+
+// compileValueExpression compiles the given arithmetic expression into a
+// function transforming a sample value. The expression may only consist of
+// the "value" variable, numeric literals, parentheses, the unary "+" and "-"
+// as well as the binary "+", "-", "*" and "/" operators. Function calls or
+// any other constructs are rejected, so evaluating the expression cannot
+// have side effects.
+func compileValueExpression(expression string) (func(float64) float64, error) {
+	expr, err := parser.ParseExpr(expression)
+	if err != nil {
+		return nil, err
+	}
+	return compileExpr(expr)
+}
+
+func compileExpr(expr ast.Expr) (func(float64) float64, error) {
+	switch e := expr.(type) {
+	case *ast.ParenExpr:
+		return compileExpr(e.X)
+	case *ast.Ident:
+		if e.Name != "value" {
+			return nil, fmt.Errorf("unknown variable %q", e.Name)
+		}
+		return func(v float64) float64 { return v }, nil
+	case *ast.BasicLit:
+		var constant float64
+		switch e.Kind {
+		case token.INT:
+			i, err := strconv.ParseInt(e.Value, 0, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid number %q: %w", e.Value, err)
+			}
+			constant = float64(i)
+		case token.FLOAT:
+			f, err := strconv.ParseFloat(e.Value, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid number %q: %w", e.Value, err)
+			}
+			constant = f
+		default:
+			return nil, fmt.Errorf("unsupported literal %s", e.Value)
+		}
+		return func(float64) float64 { return constant }, nil
+	case *ast.UnaryExpr:
+		x, err := compileExpr(e.X)
+		if err != nil {
+			return nil, err
+		}
+		switch e.Op {
+		case token.ADD:
+			return x, nil
+		case token.SUB:
+			return func(v float64) float64 { return -x(v) }, nil
+		}
+		return nil, fmt.Errorf("unsupported operator %q", e.Op)
+	case *ast.BinaryExpr:
+		x, err := compileExpr(e.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := compileExpr(e.Y)
+		if err != nil {
+			return nil, err
+		}
+		switch e.Op {
+		case token.ADD:
+			return func(v float64) float64 { return x(v) + y(v) }, nil
+		case token.SUB:
+			return func(v float64) float64 { return x(v) - y(v) }, nil
+		case token.MUL:
+			return func(v float64) float64 { return x(v) * y(v) }, nil
+		case token.QUO:
+			return func(v float64) float64 { return x(v) / y(v) }, nil
+		}
+		return nil, fmt.Errorf("unsupported operator %q", e.Op)
+	}
+	return nil, errors.New("unsupported expression")
+}
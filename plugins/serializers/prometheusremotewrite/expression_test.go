@@ -0,0 +1,98 @@
+package prometheusremotewrite
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/testutil"
+)
+
+func TestRemoteWriteCompileValueExpression(t *testing.T) {
+	tests := []struct {
+		expression string
+		value      float64
+		expected   float64
+	}{
+		{expression: "value * 8", value: 2, expected: 16},
+		{expression: "-value", value: 2, expected: -2},
+		{expression: "value + 273.15", value: 1, expected: 274.15},
+		{expression: "(value - 32) * 5 / 9", value: 212, expected: 100},
+		{expression: "+value / 0x10", value: 32, expected: 2},
+		{expression: "1_000 * value", value: 0.5, expected: 500},
+	}
+	for _, tt := range tests {
+		transform, err := compileValueExpression(tt.expression)
+		require.NoError(t, err, tt.expression)
+		require.InDelta(t, tt.expected, transform(tt.value), 1e-9, tt.expression)
+	}
+}
+
+func TestRemoteWriteCompileValueExpressionInvalid(t *testing.T) {
+	tests := map[string]string{
+		"value *":              "expected operand",
+		"x * 8":                `unknown variable "x"`,
+		"abs(value)":           "unsupported expression",
+		`value + "1"`:          "unsupported literal",
+		"value % 2":            `unsupported operator "%"`,
+		"!value":               `unsupported operator "!"`,
+		"value << 1":           `unsupported operator "<<"`,
+		"99999999999999999999": "invalid number",
+	}
+	for expression, expected := range tests {
+		_, err := compileValueExpression(expression)
+		require.ErrorContains(t, err, expected, expression)
+	}
+}
+
+func TestRemoteWriteSerializeValueExpression(t *testing.T) {
+	metrics := []telegraf.Metric{
+		testutil.MustMetric(
+			"net",
+			map[string]string{"interface": "eth0"},
+			map[string]interface{}{"bytes_recv": 1024.0},
+			time.Unix(0, 0),
+			telegraf.Counter,
+		),
+		testutil.MustMetric(
+			"prometheus",
+			map[string]string{"le": "+Inf"},
+			map[string]interface{}{"http_request_duration_seconds_bucket": 10.0},
+			time.Unix(0, 0),
+			telegraf.Histogram,
+		),
+		testutil.MustMetric(
+			"prometheus",
+			map[string]string{},
+			map[string]interface{}{
+				"http_request_duration_seconds_sum":   3.0,
+				"http_request_duration_seconds_count": 10.0,
+			},
+			time.Unix(0, 0),
+			telegraf.Histogram,
+		),
+	}
+
+	s := &Serializer{
+		Log:             &testutil.CaptureLogger{},
+		SortMetrics:     true,
+		ValueExpression: "value * 8",
+	}
+	require.NoError(t, s.Init())
+
+	data, err := s.SerializeBatch(metrics)
+	require.NoError(t, err)
+	actual, err := prompbToText(data)
+	require.NoError(t, err)
+
+	expected := `
+http_request_duration_seconds_count 10
+http_request_duration_seconds_sum 3
+http_request_duration_seconds_bucket{le="+Inf"} 10
+net_bytes_recv{interface="eth0"} 8192
+`
+	require.Equal(t, strings.TrimSpace(expected), strings.TrimSpace(string(actual)))
+}
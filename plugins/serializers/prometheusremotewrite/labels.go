@@ -0,0 +1,399 @@
+package prometheusremotewrite
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/prometheus/prompb"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/plugins/serializers/prometheus"
+)
+
+// metricLabels appends the labels shared by all series of the metric to the
+// given labels, including the instance, aggregation and metric hash labels
+// as well as the given labels of the destination. Descriptive labels are
+// moved to a target info series if configured.
+func (s *Serializer) metricLabels(c *conversion, labels []prompb.Label, metric telegraf.Metric, labelSet map[string]string, metricTime time.Time) ([]prompb.Label, error) {
+	labels, collisions := s.appendCommonLabels(labels, metric)
+	if len(collisions) > 0 {
+		if s.LabelCollisionPolicy == "error" {
+			return nil, fmt.Errorf("tags of metric %q collide in label %q after sanitization", metric.Name(), collisions[0])
+		}
+		for _, name := range collisions {
+			c.labelCollisions[name] = true
+		}
+	}
+	if s.instance != "" && !hasLabel("instance", labels) {
+		labels = append(labels, prompb.Label{Name: "instance", Value: s.instance})
+	}
+	if s.AggregationLabel != "" {
+		if aggregation, found := metric.GetTag(s.AggregationLabel); found && aggregation != "" {
+			labels = replaceLabel(labels, "__aggregation__", aggregation)
+		}
+	}
+
+	// Move the descriptive labels to an info series identified by the
+	// remaining labels instead of attaching them to every series.
+	if len(s.TagsAsTargetInfo) > 0 {
+		var info []prompb.Label
+		labels, info = s.splitTargetInfoLabels(labels)
+		if len(info) > 0 {
+			metrickey, ts := targetInfoTS(labels, info, metricTime)
+			if m, ok := c.entries[metrickey]; !ok || sampleTime(&m.TimeSeries) <= sampleTime(&ts.TimeSeries) {
+				c.entries[metrickey] = ts
+			}
+		}
+	}
+
+	if s.MetricHashLabel != "" {
+		labels = replaceLabel(labels, s.MetricHashLabel, fmt.Sprintf("%016x", metric.HashID()))
+	}
+
+	// Labels of the destination replace the labels of the metric
+	for name, value := range labelSet {
+		labels = replaceLabel(labels, name, value)
+	}
+	return labels, nil
+}
+
+// seriesLabels returns the labels of the series of the given field based on
+// the given labels of the metric, adding the field-specific labels and
+// applying the label limit.
+func (s *Serializer) seriesLabels(
+	c *conversion,
+	labels []prompb.Label,
+	metric telegraf.Metric,
+	key, metricName string,
+	metricType prompb.MetricMetadata_MetricType,
+	fieldAsLabel bool,
+) ([]prompb.Label, error) {
+	// Keep the original field name, without histogram or summary suffixes
+	// to keep the series of those families together.
+	seriesLabels := labels
+	if s.OriginalFieldLabel != "" {
+		base, _ := splitFieldKey(key, metric.Type())
+		seriesLabels = replaceLabel(labels, s.OriginalFieldLabel, base)
+	}
+	if fieldAsLabel {
+		seriesLabels = replaceLabel(seriesLabels, "field", key)
+	}
+	if s.EmitUnitLabel {
+		if unit := metricUnit(metricName, metricType); unit != "" {
+			seriesLabels = replaceLabel(seriesLabels, "__unit__", unit)
+		}
+	}
+	if s.MaxLabelsPerSeries <= 0 {
+		return seriesLabels, nil
+	}
+
+	// Reserve the labels for the metric name as well as for the bucket or
+	// quantile label to keep the label sets within a family equal. The
+	// labels added to all series after the conversion are reserved as well
+	// to apply the limit to the final label set.
+	limit := s.MaxLabelsPerSeries - 1 - s.generatedLabels()
+	if metric.Type() == telegraf.Histogram || metric.Type() == telegraf.Summary {
+		limit--
+	}
+	if len(seriesLabels) <= limit {
+		return seriesLabels, nil
+	}
+	if s.MaxLabelsPerSeriesAction == "error" {
+		return nil, fmt.Errorf("metric %q has %d labels exceeding the limit of %d", metricName, len(seriesLabels)+s.MaxLabelsPerSeries-limit, s.MaxLabelsPerSeries)
+	}
+	c.limitedSeries++
+	if s.HashExcessLabels {
+		return hashExcessLabels(seriesLabels, limit), nil
+	}
+	return limitLabels(seriesLabels, limit), nil
+}
+
+// appendCommonLabels appends the labels of the tags and, if enabled, string
+// fields of the metric. The names of labels resulting from multiple tags are
+// returned alongside.
+func (s *Serializer) appendCommonLabels(labels []prompb.Label, metric telegraf.Metric) ([]prompb.Label, []string) {
+	var collisions []string
+	for _, tag := range metric.TagList() {
+		// The help text is part of the metadata and not a label
+		if s.HelpTag != "" && tag.Key == s.HelpTag {
+			continue
+		}
+
+		// The aggregation is added as reserved label instead
+		if s.AggregationLabel != "" && tag.Key == s.AggregationLabel {
+			continue
+		}
+
+		// The drop marker is no label, independent of its value
+		if s.DropTag != "" && tag.Key == s.DropTag {
+			continue
+		}
+
+		// Ignore special tags for histogram and summary types.
+		switch metric.Type() {
+		case telegraf.Histogram:
+			if tag.Key == "le" || tag.Key == s.bucketLabel() {
+				continue
+			}
+		case telegraf.Summary:
+			if tag.Key == "quantile" || tag.Key == s.quantileLabel() {
+				continue
+			}
+			if s.SummaryToHistogram && tag.Key == s.bucketLabel() {
+				continue
+			}
+		}
+
+		key := s.stripLabelKeyPrefix(tag.Key)
+
+		// Prometheus identifies the source by the instance label rather
+		// than by the ubiquitous host tag.
+		if tag.Key == "host" {
+			switch s.HostTagBehavior {
+			case "drop":
+				continue
+			case "rename-to-instance":
+				key = "instance"
+			}
+		}
+
+		name, ok := prometheus.SanitizeLabelName(key)
+		if !ok || s.isReservedLabel(name) {
+			continue
+		}
+
+		// remove tags with empty values
+		if tag.Value == "" {
+			continue
+		}
+
+		// Different tag keys might result in the same label name after
+		// sanitization, resolve those collisions according to the policy.
+		value := s.normalizeLabelValue(name, tag.Value)
+		if i := slices.IndexFunc(labels, func(l prompb.Label) bool { return l.Name == name }); i >= 0 {
+			collisions = append(collisions, name)
+			if s.LabelCollisionPolicy != "suffix" {
+				labels[i].Value = value
+				continue
+			}
+			for n := 2; ; n++ {
+				if candidate := fmt.Sprintf("%s_%d", name, n); !hasLabel(candidate, labels) {
+					name = candidate
+					break
+				}
+			}
+		}
+
+		labels = append(labels, prompb.Label{Name: name, Value: value})
+	}
+
+	if !s.StringAsLabel {
+		return labels, collisions
+	}
+
+	for _, field := range metric.FieldList() {
+		value, ok := field.Value.(string)
+		if !ok || s.isExemplarField(field.Key) || s.isFieldTimestamp(metric, field.Key) {
+			continue
+		}
+		if _, ok := s.parseStringNumber(value); ok {
+			continue
+		}
+
+		name, ok := prometheus.SanitizeLabelName(s.stripLabelKeyPrefix(field.Key))
+		if !ok || s.isReservedLabel(name) {
+			continue
+		}
+
+		// If there is a tag with the same name as the string field, discard
+		// the field and use the tag instead.
+		if hasLabel(name, labels) {
+			continue
+		}
+
+		labels = append(labels, prompb.Label{Name: name, Value: s.normalizeLabelValue(name, value)})
+	}
+
+	return labels, collisions
+}
+
+// stripLabelKeyPrefix removes the configured prefix from the given tag or
+// field key. Keys consisting of the prefix only are kept as they are.
+func (s *Serializer) stripLabelKeyPrefix(key string) string {
+	if stripped, found := strings.CutPrefix(key, s.LabelKeyStripPrefix); found && stripped != "" {
+		return stripped
+	}
+	return key
+}
+
+// normalizeLabelValue converts the value of the given label to the configured
+// case if the label is selected for normalization.
+func (s *Serializer) normalizeLabelValue(name, value string) string {
+	if slices.Contains(s.NormalizeNumericLabels, name) {
+		value = canonicalNumber(value)
+	}
+	if !slices.Contains(s.LabelValueCaseLabels, name) {
+		return value
+	}
+	switch s.LabelValueCase {
+	case "lower":
+		return strings.ToLower(value)
+	case "upper":
+		return strings.ToUpper(value)
+	}
+	return value
+}
+
+// formatBoundary formats the bucket or quantile boundary of the given label
+// in decimal notation without exponent if the label is selected for numeric
+// normalization.
+func (s *Serializer) formatBoundary(name string, value float64) string {
+	formatted := fmt.Sprint(value)
+	if slices.Contains(s.NormalizeNumericLabels, name) {
+		return canonicalNumber(formatted)
+	}
+	return formatted
+}
+
+// canonicalNumber formats the given value in decimal notation without
+// exponent and superfluous zeros if it is a finite decimal number, e.g.
+// "1e-05" becomes "0.00001". Other values are returned as is.
+func canonicalNumber(value string) string {
+	if strings.ContainsAny(value, "xX_") {
+		return value
+	}
+	v, err := strconv.ParseFloat(value, 64)
+	if err != nil || math.IsInf(v, 0) || math.IsNaN(v) {
+		return value
+	}
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+// replaceLabel returns a copy of the labels with the label of the given name
+// being added or replaced.
+func replaceLabel(labels []prompb.Label, name, value string) []prompb.Label {
+	result := make([]prompb.Label, 0, len(labels)+1)
+	for _, label := range labels {
+		if label.Name != name {
+			result = append(result, label)
+		}
+	}
+	return append(result, prompb.Label{Name: name, Value: value})
+}
+
+// generatedLabels returns the number of labels added to all series after
+// the conversion, i.e. the series ID, protocol and write ID labels.
+func (s *Serializer) generatedLabels() int {
+	var n int
+	for _, enabled := range []bool{s.EmitSeriesID, s.EmitProtocolLabel, s.EmitWriteID} {
+		if enabled {
+			n++
+		}
+	}
+	return n
+}
+
+// limitLabels keeps the given number of labels dropping the lexicographically
+// last ones.
+func limitLabels(labels []prompb.Label, limit int) []prompb.Label {
+	limited := slices.Clone(labels)
+	sort.Sort(sortableLabels(limited))
+	return limited[:limit]
+}
+
+// hashExcessLabels keeps the given number of labels replacing the
+// lexicographically last ones by a single "__labels_hash__" label holding a
+// hash of the replaced labels. This keeps series differing in the replaced
+// labels distinct.
+func hashExcessLabels(labels []prompb.Label, limit int) []prompb.Label {
+	limited := slices.Clone(labels)
+	sort.Sort(sortableLabels(limited))
+
+	h := fnv.New64a()
+	for _, l := range limited[limit-1:] {
+		h.Write([]byte(l.Name))
+		h.Write([]byte("\x00"))
+		h.Write([]byte(l.Value))
+		h.Write([]byte("\x00"))
+	}
+	limited[limit-1] = prompb.Label{Name: "__labels_hash__", Value: fmt.Sprintf("%016x", h.Sum64())}
+	return limited[:limit]
+}
+
+// isReservedLabel returns true for labels with the reserved "__" prefix not
+// explicitly preserved. The metric name label is always reserved.
+func (s *Serializer) isReservedLabel(name string) bool {
+	if !strings.HasPrefix(name, "__") {
+		return false
+	}
+	return name == "__name__" || !slices.Contains(s.PreserveReservedLabels, name)
+}
+
+func hasLabel(name string, labels []prompb.Label) bool {
+	for _, label := range labels {
+		if name == label.Name {
+			return true
+		}
+	}
+	return false
+}
+
+// labelValue returns the value of the label with the given name.
+func labelValue(labels []prompb.Label, name string) (string, bool) {
+	for _, label := range labels {
+		if label.Name == name {
+			return label.Value, true
+		}
+	}
+	return "", false
+}
+
+// splitTargetInfoLabels separates the labels configured to be moved to the
+// target info series from the given labels. The identifying labels are
+// returned in place of the given labels.
+func (s *Serializer) splitTargetInfoLabels(labels []prompb.Label) (identifying, info []prompb.Label) {
+	identifying = labels[:0]
+	for _, l := range labels {
+		if slices.Contains(s.TagsAsTargetInfo, l.Name) {
+			info = append(info, l)
+			continue
+		}
+		identifying = append(identifying, l)
+	}
+	return identifying, info
+}
+
+// targetInfoTS returns an info series with value 1 carrying the given
+// identifying and descriptive labels.
+func targetInfoTS(identifying, info []prompb.Label, ts time.Time) (MetricKey, timeSeries) {
+	metrickey, promts := getPromTS("target_info", identifying, 1, ts, info...)
+	metadata := prompb.MetricMetadata{
+		Type:             prompb.MetricMetadata_INFO,
+		MetricFamilyName: "target_info",
+	}
+	return metrickey, timeSeries{TimeSeries: promts, metadata: metadata}
+}
+
+// bucketLabel returns the name of the label holding the upper boundary of
+// histogram buckets.
+func (s *Serializer) bucketLabel() string {
+	if s.BucketLabelName == "" {
+		return "le"
+	}
+	return s.BucketLabelName
+}
+
+// quantileLabel returns the name of the label holding the quantile of
+// summaries.
+func (s *Serializer) quantileLabel() string {
+	if s.QuantileLabelName == "" {
+		return "quantile"
+	}
+	return s.QuantileLabelName
+}
@@ -0,0 +1,60 @@
+package prometheusremotewrite
+
+import (
+	"sort"
+	"time"
+
+	"github.com/prometheus/prometheus/prompb"
+
+	"github.com/influxdata/telegraf/internal"
+)
+
+func (s *Serializer) buildInfoTS(ts time.Time) timeSeries {
+	labels := []prompb.Label{
+		{Name: "version", Value: internal.Version},
+		{Name: "remote_write_version", Value: s.remoteWriteVersion()},
+	}
+	_, promts := getPromTS("telegraf_build_info", labels, 1, ts)
+	metadata := prompb.MetricMetadata{
+		Type:             prompb.MetricMetadata_GAUGE,
+		MetricFamilyName: "telegraf_build_info",
+	}
+	return timeSeries{TimeSeries: promts, metadata: metadata}
+}
+
+// heartbeatTS returns a gauge series holding the given time as Unix epoch in
+// seconds.
+func heartbeatTS(ts time.Time) timeSeries {
+	_, promts := getPromTS("telegraf_serializer_heartbeat", nil, float64(ts.Unix()), ts)
+	metadata := prompb.MetricMetadata{
+		Type:             prompb.MetricMetadata_GAUGE,
+		MetricFamilyName: "telegraf_serializer_heartbeat",
+	}
+	return timeSeries{TimeSeries: promts, metadata: metadata}
+}
+
+// familyCardinalityTS returns a gauge series per metric family holding the
+// number of the given series belonging to the family.
+func familyCardinalityTS(series []timeSeries, ts time.Time) []timeSeries {
+	counts := make(map[string]int)
+	for _, s := range series {
+		counts[s.metadata.MetricFamilyName]++
+	}
+	families := make([]string, 0, len(counts))
+	for family := range counts {
+		families = append(families, family)
+	}
+	sort.Strings(families)
+
+	result := make([]timeSeries, 0, len(families))
+	for _, family := range families {
+		labels := []prompb.Label{{Name: "family", Value: family}}
+		_, promts := getPromTS("telegraf_family_series_count", labels, float64(counts[family]), ts)
+		metadata := prompb.MetricMetadata{
+			Type:             prompb.MetricMetadata_GAUGE,
+			MetricFamilyName: "telegraf_family_series_count",
+		}
+		result = append(result, timeSeries{TimeSeries: promts, metadata: metadata})
+	}
+	return result
+}
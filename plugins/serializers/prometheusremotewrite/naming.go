@@ -0,0 +1,240 @@
+package prometheusremotewrite
+
+import (
+	"fmt"
+	"hash/fnv"
+	"slices"
+	"strings"
+
+	"github.com/prometheus/prometheus/prompb"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/plugins/serializers/prometheus"
+)
+
+// metricName returns the name of the series of the given field according to
+// the naming options together with the power of ten converting the values to
+// the base unit of the name. Fields to be dropped are reported as not ok.
+func (s *Serializer) metricName(c *conversion, metric telegraf.Metric, key string, fieldAsLabel bool) (string, int, bool) {
+	rawName := s.caseMetricName(prometheus.MetricName(metric.Name(), key, metric.Type()))
+	if fieldAsLabel {
+		rawName = s.caseMetricName(metric.Name())
+	}
+	metricName, ok := s.sanitizeMetricName(rawName)
+	if !ok {
+		s.traceAndKeepErr(c, "failed to parse metric name %q", rawName)
+		return "", 0, false
+	}
+
+	// Field names consisting of invalid characters only vanish during
+	// sanitization, leaving a confusing metric name of the measurement.
+	// Those are kept as they are unless configured otherwise.
+	base, suffix := splitFieldKey(key, metric.Type())
+	if !fieldAsLabel && !isValidFieldName(base) && (s.InvalidFieldNamePolicy == "drop" || s.InvalidFieldNamePolicy == "placeholder") {
+		if s.InvalidFieldNamePolicy == "drop" {
+			s.traceAndKeepErr(c, "failed to parse %q: field name %q is invalid", rawName, key)
+			return "", 0, false
+		}
+		c.substituted[key] = true
+		rawName = s.caseMetricName(prometheus.MetricName(metric.Name(), s.InvalidFieldNamePlaceholder+suffix, metric.Type()))
+		if metricName, ok = s.sanitizeMetricName(rawName); !ok {
+			s.traceAndKeepErr(c, "failed to parse metric name %q", rawName)
+			return "", 0, false
+		}
+	}
+
+	// Convert values of prefixed units to the base unit, e.g. milliseconds
+	// to seconds, renaming the metric accordingly. Histograms and summaries
+	// are not scaled as their bucket and quantile labels would need to be
+	// converted as well.
+	var exponent int
+	if s.AutoScaleUnits && metric.Type() != telegraf.Histogram && metric.Type() != telegraf.Summary {
+		if scaled, e, found := scaleUnit(metricName); found {
+			metricName, exponent = scaled, e
+		}
+	}
+
+	if s.MaxMetricNameLength > 0 {
+		if shortened, ok := s.shortenMetricName(metricName, metric.Type()); ok {
+			c.shortenedNames[metricName] = true
+			metricName = shortened
+		}
+	}
+
+	// Names of series managed by the scraper must not be used to avoid
+	// collisions at the receiver.
+	if slices.Contains(s.ReservedNames, metricName) {
+		c.reservedNames[metricName] = true
+		switch s.ReservedNamePolicy {
+		case "prefix":
+			metricName = s.ReservedNamePrefix + metricName
+		case "drop":
+			return "", 0, false
+		}
+	}
+	return metricName, exponent, true
+}
+
+// nameHashLength is the length of the hash suffix, including the separator,
+// appended to shortened metric names.
+const nameHashLength = 9
+
+// shortenMetricName limits the length of the given metric family name such
+// that the series names, including histogram and summary suffixes, do not
+// exceed the maximum length. The name is either truncated or truncated and
+// suffixed by a short hash of the full name to keep the names distinct.
+func (s *Serializer) shortenMetricName(name string, valueType telegraf.ValueType) (string, bool) {
+	limit := s.MaxMetricNameLength
+	switch valueType {
+	case telegraf.Histogram, telegraf.Summary:
+		limit -= len("_bucket")
+	}
+	if len(name) <= limit {
+		return name, false
+	}
+
+	if s.MetricNameLengthAction != "hash" {
+		return name[:limit], true
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	return fmt.Sprintf("%s_%08x", name[:limit-nameHashLength], h.Sum32()), true
+}
+
+// splitFieldKey separates the histogram and summary suffixes from the given
+// field key.
+func splitFieldKey(key string, valueType telegraf.ValueType) (base, suffix string) {
+	switch valueType {
+	case telegraf.Histogram, telegraf.Summary:
+		for _, suffix := range []string{"_bucket", "_sum", "_count"} {
+			if strings.HasSuffix(key, suffix) {
+				return strings.TrimSuffix(key, suffix), suffix
+			}
+		}
+	}
+	return key, ""
+}
+
+// sanitizeMetricName sanitizes the given metric name. Names starting with a
+// digit are invalid and lose the leading digits during sanitization, so the
+// configured prefix is prepended to those names instead, turning e.g.
+// "5xx_errors" into "_5xx_errors".
+func (s *Serializer) sanitizeMetricName(name string) (string, bool) {
+	if s.LeadingDigitPrefix == "" || !startsWithDigit(name) {
+		return prometheus.SanitizeMetricName(name)
+	}
+
+	// Sanitization trims leading underscores, so the prefix might vanish
+	// and is added again in this case.
+	sanitized, ok := prometheus.SanitizeMetricName(s.LeadingDigitPrefix + name)
+	if ok && startsWithDigit(sanitized) {
+		sanitized = s.LeadingDigitPrefix + sanitized
+	}
+	return sanitized, ok
+}
+
+func startsWithDigit(name string) bool {
+	return name != "" && name[0] >= '0' && name[0] <= '9'
+}
+
+// isValidFieldName checks if the field name survives sanitization. An empty
+// name is accepted as the metric name is then formed by the measurement only.
+func isValidFieldName(name string) bool {
+	if name == "" {
+		return true
+	}
+	_, ok := prometheus.SanitizeMetricName(name)
+	return ok
+}
+
+// caseMetricName returns the given composed metric name in lower case if
+// configured.
+func (s *Serializer) caseMetricName(name string) string {
+	if s.LowercaseNames {
+		return strings.ToLower(name)
+	}
+	return name
+}
+
+// unitSuffixes are the units recognized as suffix of metric names, following
+// the Prometheus naming conventions of using base units.
+var unitSuffixes = []string{
+	"seconds", "milliseconds", "microseconds", "nanoseconds",
+	"bytes", "bits", "ratio", "percent",
+	"meters", "grams", "joules", "watts", "volts", "amperes", "hertz", "celsius", "kelvin",
+}
+
+// metricUnit returns the unit of the metric derived from the suffix of the
+// metric name ignoring the counter suffix and, for histograms and summaries,
+// the suffixes of their parts. An empty string is returned if the name does
+// not end with a known unit.
+func metricUnit(name string, metricType prompb.MetricMetadata_MetricType) string {
+	if metricType == prompb.MetricMetadata_HISTOGRAM || metricType == prompb.MetricMetadata_SUMMARY {
+		for _, suffix := range []string{"_bucket", "_sum", "_count"} {
+			if trimmed, found := strings.CutSuffix(name, suffix); found {
+				name = trimmed
+				break
+			}
+		}
+	}
+	name = strings.TrimSuffix(name, "_total")
+
+	for _, unit := range unitSuffixes {
+		if strings.HasSuffix(name, "_"+unit) {
+			return unit
+		}
+	}
+	return ""
+}
+
+// unitScales are the units with a prefix recognized as suffix of metric
+// names together with their base unit and the power of ten converting values
+// to the base unit.
+var unitScales = []struct {
+	unit     string
+	base     string
+	exponent int
+}{
+	{unit: "nanoseconds", base: "seconds", exponent: -9},
+	{unit: "microseconds", base: "seconds", exponent: -6},
+	{unit: "milliseconds", base: "seconds", exponent: -3},
+	{unit: "kilobytes", base: "bytes", exponent: 3},
+	{unit: "megabytes", base: "bytes", exponent: 6},
+	{unit: "gigabytes", base: "bytes", exponent: 9},
+	{unit: "terabytes", base: "bytes", exponent: 12},
+	{unit: "millimeters", base: "meters", exponent: -3},
+	{unit: "centimeters", base: "meters", exponent: -2},
+	{unit: "kilometers", base: "meters", exponent: 3},
+	{unit: "milligrams", base: "grams", exponent: -3},
+	{unit: "kilograms", base: "grams", exponent: 3},
+	{unit: "kilojoules", base: "joules", exponent: 3},
+	{unit: "milliwatts", base: "watts", exponent: -3},
+	{unit: "kilowatts", base: "watts", exponent: 3},
+	{unit: "megawatts", base: "watts", exponent: 6},
+	{unit: "millivolts", base: "volts", exponent: -3},
+	{unit: "kilovolts", base: "volts", exponent: 3},
+	{unit: "milliamperes", base: "amperes", exponent: -3},
+	{unit: "kilohertz", base: "hertz", exponent: 3},
+	{unit: "megahertz", base: "hertz", exponent: 6},
+	{unit: "gigahertz", base: "hertz", exponent: 9},
+	{unit: "percent", base: "ratio", exponent: -2},
+}
+
+// scaleUnit replaces a unit with a prefix at the end of the metric name,
+// ignoring the counter suffix, by its base unit. The renamed metric and the
+// power of ten converting values to the base unit are returned, e.g.
+// "rpc_duration_milliseconds" becomes "rpc_duration_seconds" with -3.
+func scaleUnit(name string) (string, int, bool) {
+	base, total := strings.CutSuffix(name, "_total")
+	for _, scale := range unitScales {
+		if prefix, found := strings.CutSuffix(base, "_"+scale.unit); found && prefix != "" {
+			scaled := prefix + "_" + scale.base
+			if total {
+				scaled += "_total"
+			}
+			return scaled, scale.exponent, true
+		}
+	}
+	return name, 0, false
+}
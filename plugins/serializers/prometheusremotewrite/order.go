@@ -0,0 +1,107 @@
+package prometheusremotewrite
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// seriesLess compares the series by the priority of their metric family and
+// by their labels for series of the same priority.
+func (s *Serializer) seriesLess(lhs, rhs []prompb.Label) bool {
+	if len(s.FamilyPriority) > 0 {
+		lp, rp := s.familyPriority(seriesName(lhs)), s.familyPriority(seriesName(rhs))
+		if lp != rp {
+			return lp < rp
+		}
+	}
+	return labelsLess(lhs, rhs)
+}
+
+// familyPriority returns the position of the family of the given series name
+// in the configured priority list. Families not in the list get the lowest
+// priority. Histogram and summary series are matched with and without their
+// suffixes.
+func (s *Serializer) familyPriority(name string) int {
+	for i, family := range s.FamilyPriority {
+		if name == family {
+			return i
+		}
+		for _, suffix := range []string{"_bucket", "_sum", "_count"} {
+			if name == family+suffix {
+				return i
+			}
+		}
+	}
+	return len(s.FamilyPriority)
+}
+
+func labelsLess(lhs, rhs []prompb.Label) bool {
+	if len(lhs) != len(rhs) {
+		return len(lhs) < len(rhs)
+	}
+
+	for index := range lhs {
+		l := lhs[index]
+		r := rhs[index]
+
+		if l.Name != r.Name {
+			return l.Name < r.Name
+		}
+
+		if l.Value != r.Value {
+			return l.Value < r.Value
+		}
+	}
+
+	return false
+}
+
+// limitSeriesPerName enforces the maximum number of series per metric name.
+// Depending on the configured action, the excess series are either dropped
+// in label order or an error is returned.
+func (s *Serializer) limitSeriesPerName(promTS []timeSeries) ([]timeSeries, error) {
+	counts := make(map[string]int)
+	for _, ts := range promTS {
+		counts[seriesName(ts.Labels)]++
+	}
+
+	exceeded := make([]string, 0)
+	for name, count := range counts {
+		if count > s.MaxSeriesPerName {
+			exceeded = append(exceeded, name)
+		}
+	}
+	if len(exceeded) == 0 {
+		return promTS, nil
+	}
+	sort.Strings(exceeded)
+
+	if s.MaxSeriesPerNameAction == "error" {
+		name := exceeded[0]
+		return nil, fmt.Errorf("metric name %q has %d series exceeding the limit of %d", name, counts[name], s.MaxSeriesPerName)
+	}
+
+	// Sort the series to get a deterministic selection of the kept series
+	sort.Slice(promTS, func(i, j int) bool {
+		return labelsLess(promTS[i].Labels, promTS[j].Labels)
+	})
+
+	seen := make(map[string]int, len(counts))
+	kept := promTS[:0]
+	for _, ts := range promTS {
+		name := seriesName(ts.Labels)
+		if seen[name] >= s.MaxSeriesPerName {
+			continue
+		}
+		seen[name]++
+		kept = append(kept, ts)
+	}
+
+	for _, name := range exceeded {
+		s.Log.Warnf("dropped %d series of metric name %q exceeding the limit of %d series", counts[name]-s.MaxSeriesPerName, name, s.MaxSeriesPerName)
+	}
+
+	return kept, nil
+}
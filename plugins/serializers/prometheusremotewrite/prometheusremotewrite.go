@@ -1,28 +1,541 @@
 package prometheusremotewrite
 
 import (
-	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"hash/fnv"
+	"math"
+	"os"
+	"slices"
 	"sort"
-	"strconv"
 	"strings"
 	"time"
 
-	"github.com/golang/snappy"
+	"github.com/prometheus/common/model"
 	"github.com/prometheus/prometheus/prompb"
 
 	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/config"
 	"github.com/influxdata/telegraf/plugins/serializers"
 	"github.com/influxdata/telegraf/plugins/serializers/prometheus"
 )
 
+// hostname returns the hostname of the machine, replaceable for testing
+var hostname = os.Hostname
+
 type MetricKey uint64
 
+// timeSeries is a Prometheus series together with the metadata of its metric
+// family. Placeholders are series synthesized for incomplete histograms, e.g.
+// a zero count, which must not replace real samples.
+type timeSeries struct {
+	prompb.TimeSeries
+	metadata    prompb.MetricMetadata
+	placeholder bool
+}
+
 type Serializer struct {
-	SortMetrics   bool            `toml:"prometheus_sort_metrics"`
-	StringAsLabel bool            `toml:"prometheus_string_as_label"`
-	Log           telegraf.Logger `toml:"-"`
+	Protocol           string `toml:"prometheus_remote_write_protocol"`
+	WriteMetadata      bool   `toml:"prometheus_write_metadata"`
+	HelpTag            string `toml:"prometheus_help_tag"`
+	SortMetrics        bool   `toml:"prometheus_sort_metrics"`
+	StringAsLabel      bool   `toml:"prometheus_string_as_label"`
+	ParseStringNumbers bool   `toml:"prometheus_parse_string_numbers"`
+	EmitBuildInfo      bool   `toml:"prometheus_emit_build_info"`
+	EmitHeartbeat      bool   `toml:"prometheus_emit_heartbeat"`
+	EmitWriteID        bool   `toml:"prometheus_emit_write_id"`
+	EmitSeriesID       bool   `toml:"prometheus_emit_series_id"`
+	EmitProtocolLabel  bool   `toml:"prometheus_emit_protocol_label"`
+	LogBatchSummary    bool   `toml:"prometheus_log_batch_summary"`
+	EmptyBatchPolicy   string `toml:"prometheus_empty_batch_policy"`
+
+	FamilyPriority []string `toml:"prometheus_family_priority"`
+
+	GlobalTimestampSort bool `toml:"prometheus_global_timestamp_sort"`
+
+	EmitFamilyCardinality bool `toml:"prometheus_emit_family_cardinality"`
+
+	MaxMetadataEntries int `toml:"prometheus_max_metadata_entries"`
+
+	// Options of SerializeBatchSplit only available to Go embedders
+	SeparateMetadataRequest bool `toml:"-"`
+
+	SynthesizeHelp bool   `toml:"prometheus_synthesize_help"`
+	OriginTag      string `toml:"prometheus_origin_tag"`
+
+	MetadataConflictPolicy string `toml:"prometheus_metadata_conflict_policy"`
+
+	AnnotationPolicy string `toml:"prometheus_annotation_policy"`
+
+	MaxSeriesPerName       int    `toml:"prometheus_max_series_per_name"`
+	MaxSeriesPerNameAction string `toml:"prometheus_max_series_per_name_action"`
+
+	MaxLabelsPerSeries       int    `toml:"prometheus_max_labels_per_series"`
+	MaxLabelsPerSeriesAction string `toml:"prometheus_max_labels_per_series_action"`
+	HashExcessLabels         bool   `toml:"prometheus_hash_excess_labels"`
+
+	InvalidFieldNamePolicy      string `toml:"prometheus_invalid_field_name_policy"`
+	InvalidFieldNamePlaceholder string `toml:"prometheus_invalid_field_name_placeholder"`
+
+	LeadingDigitPrefix string `toml:"prometheus_leading_digit_prefix"`
+
+	ExemplarField         string   `toml:"prometheus_exemplar_field"`
+	ExemplarLabelFields   []string `toml:"prometheus_exemplar_label_fields"`
+	MaxExemplarsPerSeries *int     `toml:"prometheus_max_exemplars_per_series"`
+
+	SummaryToHistogram    bool   `toml:"prometheus_summary_to_histogram"`
+	HistogramAutoDetect   bool   `toml:"prometheus_histogram_auto_detect"`
+	DeltaToCumulative     bool   `toml:"prometheus_delta_to_cumulative"`
+	ClampNegativeCounters bool   `toml:"prometheus_clamp_negative_counters"`
+	DuplicateBucketPolicy string `toml:"prometheus_duplicate_bucket_policy"`
+	TypeConflictPolicy    string `toml:"prometheus_type_conflict_policy"`
+
+	ValidateBucketMonotonicity bool   `toml:"prometheus_validate_bucket_monotonicity"`
+	BucketMonotonicityAction   string `toml:"prometheus_bucket_monotonicity_action"`
+
+	ValidateHistogramConsistency bool   `toml:"prometheus_validate_histogram_consistency"`
+	HistogramNaNSumAction        string `toml:"prometheus_histogram_nan_sum_action"`
+
+	RequireHistogramCount       bool   `toml:"prometheus_require_histogram_count"`
+	MissingHistogramCountAction string `toml:"prometheus_missing_histogram_count_action"`
+
+	IncompleteSummaryAction string `toml:"prometheus_incomplete_summary_action"`
+
+	RejectZeroTimestamp bool   `toml:"prometheus_reject_zero_timestamp"`
+	ZeroTimestampAction string `toml:"prometheus_zero_timestamp_action"`
+
+	MaxFutureSkew         config.Duration `toml:"prometheus_max_future_skew"`
+	FutureTimestampAction string          `toml:"prometheus_future_timestamp_action"`
+
+	MissingTimestampPolicy string `toml:"prometheus_missing_timestamp_policy"`
+
+	TimestampResolution string `toml:"prometheus_timestamp_resolution"`
+
+	LowercaseNames bool `toml:"prometheus_lowercase_names"`
+
+	MaxMetricNameLength    int    `toml:"prometheus_max_metric_name_length"`
+	MetricNameLengthAction string `toml:"prometheus_metric_name_length_action"`
+
+	ReservedNames      []string `toml:"prometheus_reserved_names"`
+	ReservedNamePolicy string   `toml:"prometheus_reserved_name_policy"`
+	ReservedNamePrefix string   `toml:"prometheus_reserved_name_prefix"`
+
+	OriginalFieldLabel string `toml:"prometheus_original_field_label"`
+	FieldAsLabel       bool   `toml:"prometheus_field_as_label"`
+
+	ValueMultipliers map[string]float64 `toml:"prometheus_value_multipliers"`
+	ValueExpression  string             `toml:"prometheus_value_expression"`
+	ValuePrecision   int                `toml:"prometheus_value_precision"`
+
+	DualEmitFields []string `toml:"prometheus_dual_emit_fields"`
+
+	PreserveReservedLabels []string `toml:"prometheus_preserve_reserved_labels"`
+
+	LabelCollisionPolicy string `toml:"prometheus_label_collision_policy"`
+	LabelKeyStripPrefix  string `toml:"prometheus_label_key_strip_prefix"`
+
+	LabelValueCase       string   `toml:"prometheus_label_value_case"`
+	LabelValueCaseLabels []string `toml:"prometheus_label_value_case_labels"`
+
+	NormalizeNumericLabels []string `toml:"prometheus_normalize_numeric_labels"`
+
+	MetricHashLabel string `toml:"prometheus_metric_hash_label"`
+
+	InstanceFromHostname bool `toml:"prometheus_instance_from_hostname"`
+
+	HostTagBehavior string `toml:"prometheus_host_tag_behavior"`
+
+	TagsAsTargetInfo []string `toml:"prometheus_tags_as_target_info"`
+
+	AggregationLabel string `toml:"prometheus_aggregation_label"`
+
+	EmitUnitLabel  bool `toml:"prometheus_emit_unit_label"`
+	AutoScaleUnits bool `toml:"prometheus_auto_scale_units"`
+
+	DropTag      string `toml:"prometheus_drop_tag"`
+	DropTagValue string `toml:"prometheus_drop_tag_value"`
+
+	FieldTimestampSuffix string `toml:"prometheus_field_timestamp_suffix"`
+	FieldTimestampFormat string `toml:"prometheus_field_timestamp_format"`
+	TimestampValueField  string `toml:"prometheus_timestamp_value_field"`
+	TimestampValueSource string `toml:"prometheus_timestamp_value_source"`
+
+	RawSeriesField string `toml:"prometheus_raw_series_field"`
+
+	BucketLabelName   string `toml:"prometheus_bucket_label_name"`
+	QuantileLabelName string `toml:"prometheus_quantile_label_name"`
+
+	// Options of SerializeBatchPartial only available to Go embedders
+	MaxAssemblyBytes int `toml:"-"`
+
+	// Options of SerializeBatchChunked only available to Go embedders
+	MaxPayloadBytes int `toml:"-"`
+
+	Concurrency          int             `toml:"prometheus_concurrency"`
+	SerializationTimeout config.Duration `toml:"prometheus_serialization_timeout"`
+
+	// Options of SerializeBatchChunked only available to Go embedders
+	CompressionConcurrency int `toml:"-"`
+
+	// Options of SerializeBatchSharded only available to Go embedders
+	ShardLabel string `toml:"-"`
+	ShardCount int    `toml:"-"`
+
+	// Options of SerializeBatchFor only available to Go embedders
+	LabelSets map[string]map[string]string `toml:"-"`
+
+	DedupScope string          `toml:"prometheus_dedup_scope"`
+	DedupTTL   config.Duration `toml:"prometheus_dedup_ttl"`
+
+	CardinalityLimit  int             `toml:"prometheus_cardinality_limit"`
+	CardinalityWindow config.Duration `toml:"prometheus_cardinality_window"`
+
+	Log telegraf.Logger `toml:"-"`
+
+	dedup          dedupCache
+	cardinality    cardinalityGuard
+	cumulative     cumulativeTotals
+	instance       string
+	valueTransform func(float64) float64
+}
+
+func (s *Serializer) Init() error {
+	switch s.Protocol {
+	case "":
+		s.Protocol = "1.0"
+	case "1.0", "2.0":
+	default:
+		return fmt.Errorf("invalid remote write protocol %q", s.Protocol)
+	}
+
+	if s.MaxMetadataEntries < 0 {
+		return fmt.Errorf("invalid maximum metadata entries %d", s.MaxMetadataEntries)
+	}
+	if s.SeparateMetadataRequest && s.Protocol == "2.0" {
+		return errors.New("separate metadata requests are not supported for remote write protocol 2.0")
+	}
+
+	switch s.EmptyBatchPolicy {
+	case "":
+		s.EmptyBatchPolicy = "empty-payload"
+	case "empty-payload", "nil", "heartbeat", "error":
+	default:
+		return fmt.Errorf("invalid empty batch policy %q", s.EmptyBatchPolicy)
+	}
+
+	switch s.LabelCollisionPolicy {
+	case "":
+		s.LabelCollisionPolicy = "last-wins"
+	case "last-wins", "error", "suffix":
+	default:
+		return fmt.Errorf("invalid label collision policy %q", s.LabelCollisionPolicy)
+	}
+
+	switch s.MaxSeriesPerNameAction {
+	case "":
+		s.MaxSeriesPerNameAction = "drop"
+	case "drop", "error":
+	default:
+		return fmt.Errorf("invalid max series per name action %q", s.MaxSeriesPerNameAction)
+	}
+
+	switch s.MaxLabelsPerSeriesAction {
+	case "":
+		s.MaxLabelsPerSeriesAction = "drop"
+	case "drop", "error":
+	default:
+		return fmt.Errorf("invalid max labels per series action %q", s.MaxLabelsPerSeriesAction)
+	}
+	if s.MaxLabelsPerSeries > 0 && s.MaxLabelsPerSeries < 2+s.generatedLabels() {
+		return fmt.Errorf("maximum labels per series %d too small", s.MaxLabelsPerSeries)
+	}
+	if s.HashExcessLabels && s.MaxLabelsPerSeries > 0 && s.MaxLabelsPerSeries < 3+s.generatedLabels() {
+		return fmt.Errorf("maximum labels per series %d too small for hashing excess labels", s.MaxLabelsPerSeries)
+	}
+
+	switch s.AnnotationPolicy {
+	case "":
+		s.AnnotationPolicy = "drop"
+	case "drop", "presence", "info", "exemplar":
+	default:
+		return fmt.Errorf("invalid annotation policy %q", s.AnnotationPolicy)
+	}
+
+	switch s.InvalidFieldNamePolicy {
+	case "":
+		s.InvalidFieldNamePolicy = "sanitize"
+	case "sanitize", "drop", "placeholder":
+	default:
+		return fmt.Errorf("invalid field name policy %q", s.InvalidFieldNamePolicy)
+	}
+	if s.InvalidFieldNamePlaceholder == "" {
+		s.InvalidFieldNamePlaceholder = "invalid_field"
+	}
+	if _, ok := prometheus.SanitizeMetricName(s.InvalidFieldNamePlaceholder); !ok {
+		return fmt.Errorf("invalid field name placeholder %q", s.InvalidFieldNamePlaceholder)
+	}
+	if s.LeadingDigitPrefix != "" && !model.IsValidLegacyMetricName(s.LeadingDigitPrefix) {
+		return fmt.Errorf("invalid leading digit prefix %q", s.LeadingDigitPrefix)
+	}
+
+	switch s.ZeroTimestampAction {
+	case "":
+		s.ZeroTimestampAction = "drop"
+	case "drop", "now":
+	default:
+		return fmt.Errorf("invalid zero timestamp action %q", s.ZeroTimestampAction)
+	}
+
+	if s.MaxFutureSkew < 0 {
+		return fmt.Errorf("invalid maximum future skew %v", time.Duration(s.MaxFutureSkew))
+	}
+	switch s.FutureTimestampAction {
+	case "":
+		s.FutureTimestampAction = "drop"
+	case "drop", "clamp":
+	default:
+		return fmt.Errorf("invalid future timestamp action %q", s.FutureTimestampAction)
+	}
+
+	switch s.TimestampResolution {
+	case "":
+		s.TimestampResolution = "ms"
+	case "ms", "s":
+	default:
+		return fmt.Errorf("invalid timestamp resolution %q", s.TimestampResolution)
+	}
+
+	switch s.MissingTimestampPolicy {
+	case "":
+		s.MissingTimestampPolicy = "now"
+	case "now", "keep", "drop", "error":
+	default:
+		return fmt.Errorf("invalid missing timestamp policy %q", s.MissingTimestampPolicy)
+	}
+
+	switch s.MetricNameLengthAction {
+	case "":
+		s.MetricNameLengthAction = "truncate"
+	case "truncate", "hash":
+	default:
+		return fmt.Errorf("invalid metric name length action %q", s.MetricNameLengthAction)
+	}
+	if s.MaxMetricNameLength > 0 && s.MaxMetricNameLength <= len("_bucket")+nameHashLength {
+		return fmt.Errorf("maximum metric name length %d too small", s.MaxMetricNameLength)
+	}
+
+	// Label sets must neither override the metric name nor the labels
+	// generated for histograms, summaries or by the serializer itself.
+	for id, labelSet := range s.LabelSets {
+		for name := range labelSet {
+			if !model.LabelName(name).IsValidLegacy() || strings.HasPrefix(name, "__") {
+				return fmt.Errorf("invalid label %q in label set %q", name, id)
+			}
+			if name == "le" || name == "quantile" || name == s.bucketLabel() || name == s.quantileLabel() {
+				return fmt.Errorf("reserved label %q in label set %q", name, id)
+			}
+		}
+	}
+
+	if s.ReservedNames == nil {
+		s.ReservedNames = []string{
+			"up",
+			"scrape_duration_seconds",
+			"scrape_samples_scraped",
+			"scrape_samples_post_metric_relabeling",
+			"scrape_series_added",
+		}
+	}
+	switch s.ReservedNamePolicy {
+	case "":
+		s.ReservedNamePolicy = "warn"
+	case "warn", "prefix", "drop":
+	default:
+		return fmt.Errorf("invalid reserved name policy %q", s.ReservedNamePolicy)
+	}
+	if s.ReservedNamePrefix == "" {
+		s.ReservedNamePrefix = "telegraf_"
+	}
+	if s.ReservedNamePolicy == "prefix" && !model.IsValidLegacyMetricName(s.ReservedNamePrefix+"up") {
+		return fmt.Errorf("invalid reserved name prefix %q", s.ReservedNamePrefix)
+	}
+
+	switch s.LabelValueCase {
+	case "":
+		s.LabelValueCase = "none"
+	case "none", "lower", "upper":
+	default:
+		return fmt.Errorf("invalid label value case %q", s.LabelValueCase)
+	}
+
+	if s.OriginalFieldLabel != "" && !model.LabelName(s.OriginalFieldLabel).IsValidLegacy() {
+		return fmt.Errorf("invalid original field label %q", s.OriginalFieldLabel)
+	}
+
+	switch s.DuplicateBucketPolicy {
+	case "":
+		s.DuplicateBucketPolicy = "max"
+	case "max", "first", "last":
+	default:
+		return fmt.Errorf("invalid duplicate bucket policy %q", s.DuplicateBucketPolicy)
+	}
+
+	if s.ValuePrecision < 0 {
+		return fmt.Errorf("invalid value precision %d", s.ValuePrecision)
+	}
+
+	if s.ValueExpression != "" {
+		transform, err := compileValueExpression(s.ValueExpression)
+		if err != nil {
+			return fmt.Errorf("invalid value expression %q: %w", s.ValueExpression, err)
+		}
+		s.valueTransform = transform
+	}
+
+	switch s.BucketMonotonicityAction {
+	case "":
+		s.BucketMonotonicityAction = "repair"
+	case "repair", "drop":
+	default:
+		return fmt.Errorf("invalid bucket monotonicity action %q", s.BucketMonotonicityAction)
+	}
+
+	switch s.HistogramNaNSumAction {
+	case "":
+		s.HistogramNaNSumAction = "warn"
+	case "warn", "zero", "drop":
+	default:
+		return fmt.Errorf("invalid histogram NaN sum action %q", s.HistogramNaNSumAction)
+	}
+
+	switch s.MissingHistogramCountAction {
+	case "":
+		s.MissingHistogramCountAction = "drop"
+	case "drop", "error":
+	default:
+		return fmt.Errorf("invalid missing histogram count action %q", s.MissingHistogramCountAction)
+	}
+
+	switch s.IncompleteSummaryAction {
+	case "":
+		s.IncompleteSummaryAction = "keep"
+	case "keep", "drop", "gauge", "synthesize":
+	default:
+		return fmt.Errorf("invalid incomplete summary action %q", s.IncompleteSummaryAction)
+	}
+
+	switch s.TypeConflictPolicy {
+	case "":
+		s.TypeConflictPolicy = "first-wins"
+	case "first-wins", "error":
+	default:
+		return fmt.Errorf("invalid type conflict policy %q", s.TypeConflictPolicy)
+	}
+
+	switch s.MetadataConflictPolicy {
+	case "":
+		s.MetadataConflictPolicy = "first-wins"
+	case "first-wins", "most-common", "error":
+	default:
+		return fmt.Errorf("invalid metadata conflict policy %q", s.MetadataConflictPolicy)
+	}
+
+	if s.MaxAssemblyBytes < 0 {
+		return fmt.Errorf("invalid maximum assembly bytes %d", s.MaxAssemblyBytes)
+	}
+
+	if s.Concurrency < 0 {
+		return fmt.Errorf("invalid concurrency %d", s.Concurrency)
+	}
+	if s.CompressionConcurrency < 0 {
+		return fmt.Errorf("invalid compression concurrency %d", s.CompressionConcurrency)
+	}
+	if s.SerializationTimeout < 0 {
+		return fmt.Errorf("invalid serialization timeout %v", time.Duration(s.SerializationTimeout))
+	}
+
+	if s.ShardCount < 0 {
+		return fmt.Errorf("invalid shard count %d", s.ShardCount)
+	}
+	if s.ShardCount == 0 {
+		s.ShardCount = 1
+	}
+
+	switch s.DedupScope {
+	case "":
+		s.DedupScope = "batch"
+	case "batch", "serializer":
+	default:
+		return fmt.Errorf("invalid dedup scope %q", s.DedupScope)
+	}
+	if s.DedupTTL <= 0 {
+		s.DedupTTL = config.Duration(time.Hour)
+	}
+	s.dedup.ttl = time.Duration(s.DedupTTL)
+
+	if s.CardinalityLimit < 0 {
+		return fmt.Errorf("invalid cardinality limit %d", s.CardinalityLimit)
+	}
+	if s.CardinalityWindow <= 0 {
+		s.CardinalityWindow = config.Duration(time.Hour)
+	}
+	s.cardinality.limit = s.CardinalityLimit
+	s.cardinality.window = time.Duration(s.CardinalityWindow)
+
+	if s.MetricHashLabel != "" && !model.LabelName(s.MetricHashLabel).IsValidLegacy() {
+		return fmt.Errorf("invalid metric hash label %q", s.MetricHashLabel)
+	}
+
+	switch s.HostTagBehavior {
+	case "":
+		s.HostTagBehavior = "keep"
+	case "keep", "drop", "rename-to-instance":
+	default:
+		return fmt.Errorf("invalid host tag behavior %q", s.HostTagBehavior)
+	}
+
+	if s.InstanceFromHostname {
+		name, err := hostname()
+		if err != nil {
+			return fmt.Errorf("determining hostname failed: %w", err)
+		}
+		s.instance = name
+	}
+
+	if s.FieldTimestampFormat == "" {
+		s.FieldTimestampFormat = "unix"
+	}
+	if s.TimestampValueSource != "" {
+		if s.TimestampValueField == "" {
+			return errors.New("timestamp value source requires a timestamp value field")
+		}
+		if s.TimestampValueSource == s.TimestampValueField {
+			return fmt.Errorf("timestamp value source %q must differ from the timestamp value field", s.TimestampValueSource)
+		}
+	}
+
+	if s.BucketLabelName == "" {
+		s.BucketLabelName = "le"
+	}
+	if !model.LabelName(s.BucketLabelName).IsValidLegacy() {
+		return fmt.Errorf("invalid bucket label name %q", s.BucketLabelName)
+	}
+	if s.QuantileLabelName == "" {
+		s.QuantileLabelName = "quantile"
+	}
+	if !model.LabelName(s.QuantileLabelName).IsValidLegacy() {
+		return fmt.Errorf("invalid quantile label name %q", s.QuantileLabelName)
+	}
+
+	if s.ExemplarField != "" && len(s.ExemplarLabelFields) == 0 {
+		s.ExemplarLabelFields = []string{"trace_id", "span_id"}
+	}
+	if s.MaxExemplarsPerSeries != nil && *s.MaxExemplarsPerSeries < 0 {
+		return fmt.Errorf("invalid maximum exemplars per series %d", *s.MaxExemplarsPerSeries)
+	}
+
+	return nil
 }
 
 func (s *Serializer) Serialize(metric telegraf.Metric) ([]byte, error) {
@@ -30,280 +543,830 @@ func (s *Serializer) Serialize(metric telegraf.Metric) ([]byte, error) {
 }
 
 func (s *Serializer) SerializeBatch(metrics []telegraf.Metric) ([]byte, error) {
-	var lastErr error
-	// traceAndKeepErr logs on Trace level every passed error.
-	// with each call it updates lastErr, so it can be logged later with higher level.
-	traceAndKeepErr := func(format string, a ...any) {
-		lastErr = fmt.Errorf(format, a...)
-		s.Log.Trace(lastErr)
+	series, dropped, err := s.assemble(metrics)
+	if err != nil {
+		return nil, err
 	}
 
-	var buf bytes.Buffer
-	var entries = make(map[MetricKey]prompb.TimeSeries)
-	var labels = make([]prompb.Label, 0)
-	for _, metric := range metrics {
-		labels = s.appendCommonLabels(labels[:0], metric)
-		var metrickey MetricKey
-		var promts prompb.TimeSeries
-		for _, field := range metric.FieldList() {
-			rawName := prometheus.MetricName(metric.Name(), field.Key, metric.Type())
-			metricName, ok := prometheus.SanitizeMetricName(rawName)
-			if !ok {
-				traceAndKeepErr("failed to parse metric name %q", rawName)
-				continue
-			}
+	data, err := s.encode(series)
+	if err != nil {
+		return nil, err
+	}
+	if s.LogBatchSummary {
+		s.logBatchSummary(series, dropped, len(data))
+	}
+	return data, nil
+}
 
-			switch metric.Type() {
-			case telegraf.Counter:
-				fallthrough
-			case telegraf.Gauge:
-				fallthrough
-			case telegraf.Untyped:
-				value, ok := prometheus.SampleValue(field.Value)
-				if !ok {
-					traceAndKeepErr("failed to parse %q: bad sample value %#v", metricName, field.Value)
-					continue
-				}
-				metrickey, promts = getPromTS(metricName, labels, value, metric.Time())
-			case telegraf.Histogram:
-				switch {
-				case strings.HasSuffix(field.Key, "_bucket"):
-					// if bucket only, init sum, count, inf
-					metrickeysum, promtssum := getPromTS(metricName+"_sum", labels, float64(0), metric.Time())
-					if _, ok = entries[metrickeysum]; !ok {
-						entries[metrickeysum] = promtssum
-					}
-					metrickeycount, promtscount := getPromTS(metricName+"_count", labels, float64(0), metric.Time())
-					if _, ok = entries[metrickeycount]; !ok {
-						entries[metrickeycount] = promtscount
-					}
-					extraLabel := prompb.Label{
-						Name:  "le",
-						Value: "+Inf",
-					}
-					metrickeyinf, promtsinf := getPromTS(metricName+"_bucket", labels, float64(0), metric.Time(), extraLabel)
-					if _, ok = entries[metrickeyinf]; !ok {
-						entries[metrickeyinf] = promtsinf
-					}
-
-					le, ok := metric.GetTag("le")
-					if !ok {
-						traceAndKeepErr("failed to parse %q: can't find `le` label", metricName)
-						continue
-					}
-					bound, err := strconv.ParseFloat(le, 64)
-					if err != nil {
-						traceAndKeepErr("failed to parse %q: can't parse %q value: %w", metricName, le, err)
-						continue
-					}
-					count, ok := prometheus.SampleCount(field.Value)
-					if !ok {
-						traceAndKeepErr("failed to parse %q: bad sample value %#v", metricName, field.Value)
-						continue
-					}
-
-					extraLabel = prompb.Label{
-						Name:  "le",
-						Value: fmt.Sprint(bound),
-					}
-					metrickey, promts = getPromTS(metricName+"_bucket", labels, float64(count), metric.Time(), extraLabel)
-				case strings.HasSuffix(field.Key, "_sum"):
-					sum, ok := prometheus.SampleSum(field.Value)
-					if !ok {
-						traceAndKeepErr("failed to parse %q: bad sample value %#v", metricName, field.Value)
-						continue
-					}
-
-					metrickey, promts = getPromTS(metricName+"_sum", labels, sum, metric.Time())
-				case strings.HasSuffix(field.Key, "_count"):
-					count, ok := prometheus.SampleCount(field.Value)
-					if !ok {
-						traceAndKeepErr("failed to parse %q: bad sample value %#v", metricName, field.Value)
-						continue
-					}
-
-					// if no bucket generate +Inf entry
-					extraLabel := prompb.Label{
-						Name:  "le",
-						Value: "+Inf",
-					}
-					metrickeyinf, promtsinf := getPromTS(metricName+"_bucket", labels, float64(count), metric.Time(), extraLabel)
-					if minf, ok := entries[metrickeyinf]; !ok || minf.Samples[0].Value == 0 {
-						entries[metrickeyinf] = promtsinf
-					}
-
-					metrickey, promts = getPromTS(metricName+"_count", labels, float64(count), metric.Time())
-				default:
-					traceAndKeepErr("failed to parse %q: series %q should have `_count`, `_sum` or `_bucket` suffix", metricName, field.Key)
-					continue
-				}
-			case telegraf.Summary:
-				switch {
-				case strings.HasSuffix(field.Key, "_sum"):
-					sum, ok := prometheus.SampleSum(field.Value)
-					if !ok {
-						traceAndKeepErr("failed to parse %q: bad sample value %#v", metricName, field.Value)
-						continue
-					}
-
-					metrickey, promts = getPromTS(metricName+"_sum", labels, sum, metric.Time())
-				case strings.HasSuffix(field.Key, "_count"):
-					count, ok := prometheus.SampleCount(field.Value)
-					if !ok {
-						traceAndKeepErr("failed to parse %q: bad sample value %#v", metricName, field.Value)
-						continue
-					}
-
-					metrickey, promts = getPromTS(metricName+"_count", labels, float64(count), metric.Time())
-				default:
-					quantileTag, ok := metric.GetTag("quantile")
-					if !ok {
-						traceAndKeepErr("failed to parse %q: can't find `quantile` label", metricName)
-						continue
-					}
-					quantile, err := strconv.ParseFloat(quantileTag, 64)
-					if err != nil {
-						traceAndKeepErr("failed to parse %q: can't parse %q value: %w", metricName, quantileTag, err)
-						continue
-					}
-					value, ok := prometheus.SampleValue(field.Value)
-					if !ok {
-						traceAndKeepErr("failed to parse %q: bad sample value %#v", metricName, field.Value)
-						continue
-					}
-
-					extraLabel := prompb.Label{
-						Name:  "quantile",
-						Value: fmt.Sprint(quantile),
-					}
-					metrickey, promts = getPromTS(metricName, labels, value, metric.Time(), extraLabel)
-				}
-			default:
-				return nil, fmt.Errorf("unknown type %v", metric.Type())
-			}
+// logBatchSummary logs the composition of a serialized batch
+func (s *Serializer) logBatchSummary(series []timeSeries, dropped, size int) {
+	var samples int
+	families := make(map[string]bool)
+	for _, ts := range series {
+		samples += len(ts.Samples)
+		families[ts.metadata.MetricFamilyName] = true
+	}
+	s.Log.Debugf("serialized %d series with %d samples of %d families, dropped %d series, payload size %d bytes",
+		len(series), samples, len(families), dropped, size)
+}
 
-			// A batch of metrics can contain multiple values for a single
-			// Prometheus sample. If this metric is older than the existing
-			// sample then we can skip over it.
-			m, ok := entries[metrickey]
-			if ok {
-				if metric.Time().Before(time.Unix(0, m.Samples[0].Timestamp*1_000_000)) {
-					traceAndKeepErr("metric %q has samples with timestamp %v older than already registered before", metric.Name(), metric.Time())
-					continue
-				}
-			}
-			entries[metrickey] = promts
+// SerializeBatchSplit serializes the given metrics into a payload containing
+// the samples and, if writing metadata in a separate request is enabled, a
+// second payload containing the metadata only. This allows to send the
+// metadata less frequently or to a different endpoint. The metadata payload
+// is nil if writing metadata or separate metadata requests are disabled.
+func (s *Serializer) SerializeBatchSplit(metrics []telegraf.Metric) (samples, metadata []byte, err error) {
+	series, _, err := s.assemble(metrics)
+	if err != nil {
+		return nil, nil, err
+	}
+	if samples, err = s.encode(series); err != nil {
+		return nil, nil, err
+	}
+	if !s.WriteMetadata || !s.SeparateMetadataRequest || s.omitPayload(series) {
+		return samples, nil, nil
+	}
+	if metadata, err = s.encodeMetadata(series); err != nil {
+		return nil, nil, err
+	}
+	return samples, metadata, nil
+}
+
+// SerializeBatchSharded serializes the given metrics into one payload per
+// shard. Series are assigned to a shard by hashing the value of the configured
+// shard label, so all series with the same value end up in the same shard.
+// Series without the label are assigned to the shard of an empty value.
+// Shards without series are omitted.
+func (s *Serializer) SerializeBatchSharded(metrics []telegraf.Metric) (map[int][]byte, error) {
+	series, _, err := s.assemble(metrics)
+	if err != nil {
+		return nil, err
+	}
+
+	shards := make(map[int][]timeSeries)
+	for _, ts := range series {
+		shard := s.shard(ts.Labels)
+		shards[shard] = append(shards[shard], ts)
+	}
+
+	payloads := make(map[int][]byte, len(shards))
+	for shard, shardSeries := range shards {
+		data, err := s.encode(shardSeries)
+		if err != nil {
+			return nil, err
 		}
+		payloads[shard] = data
 	}
+	return payloads, nil
+}
 
-	if lastErr != nil {
-		// log only the last recorded error in the batch, as it could have many errors and logging each one
-		// could be too verbose. The following log line still provides enough info for user to act on.
-		s.Log.Warnf("some series were dropped, %d series left to send; last recorded error: %v", len(entries), lastErr)
+// SerializeBatchByType serializes the given metrics into one payload per metric
+// type, e.g. to route counters and gauges to different receivers. The payloads
+// are keyed by the type name, i.e. "counter", "gauge", "histogram", "summary"
+// or "untyped", where series without a type such as info series are untyped.
+// Types without series are omitted.
+func (s *Serializer) SerializeBatchByType(metrics []telegraf.Metric) (map[string][]byte, error) {
+	series, _, err := s.assemble(metrics)
+	if err != nil {
+		return nil, err
 	}
 
-	var promTS = make([]prompb.TimeSeries, len(entries))
-	var i int
-	for _, promts := range entries {
-		promTS[i] = promts
-		i++
+	types := make(map[string][]timeSeries)
+	for _, ts := range series {
+		name := expositionType(ts.metadata.Type)
+		types[name] = append(types[name], ts)
 	}
 
-	if s.SortMetrics {
-		sort.Slice(promTS, func(i, j int) bool {
-			lhs := promTS[i].Labels
-			rhs := promTS[j].Labels
-			if len(lhs) != len(rhs) {
-				return len(lhs) < len(rhs)
-			}
+	payloads := make(map[string][]byte, len(types))
+	for name, typeSeries := range types {
+		data, err := s.encode(typeSeries)
+		if err != nil {
+			return nil, err
+		}
+		payloads[name] = data
+	}
+	return payloads, nil
+}
 
-			for index := range lhs {
-				l := lhs[index]
-				r := rhs[index]
+// SerializeBatchFor serializes the given metrics for the destination with the
+// given ID by adding the labels of the destination's label set to all series.
+// Labels of the label set replace existing labels with the same name and are
+// subject to the options operating on labels, e.g. the label limit or the
+// series ID. An error is returned for unknown destinations.
+func (s *Serializer) SerializeBatchFor(id string, metrics []telegraf.Metric) ([]byte, error) {
+	labelSet, found := s.LabelSets[id]
+	if !found {
+		return nil, fmt.Errorf("no label set for destination %q", id)
+	}
 
-				if l.Name != r.Name {
-					return l.Name < r.Name
-				}
+	series, _, err := s.assembleWithLabels(metrics, labelSet)
+	if err != nil {
+		return nil, err
+	}
+	return s.encode(series)
+}
 
-				if l.Value != r.Value {
-					return l.Value < r.Value
-				}
-			}
+// shard returns the shard of the series with the given labels
+func (s *Serializer) shard(labels []prompb.Label) int {
+	if s.ShardCount <= 1 {
+		return 0
+	}
+	value, _ := labelValue(labels, s.ShardLabel)
+	h := fnv.New32a()
+	h.Write([]byte(value))
+	return int(h.Sum32() % uint32(s.ShardCount))
+}
 
-			return false
-		})
+// SerializeBatchWithSize serializes the given metrics like SerializeBatch but
+// additionally returns the length of the uncompressed protobuf payload, e.g.
+// to be passed to the receiver as size hint.
+func (s *Serializer) SerializeBatchWithSize(metrics []telegraf.Metric) ([]byte, int, error) {
+	series, _, err := s.assemble(metrics)
+	if err != nil {
+		return nil, 0, err
 	}
-	pb := &prompb.WriteRequest{Timeseries: promTS}
-	data, err := pb.Marshal()
+	if s.omitPayload(series) {
+		return nil, 0, nil
+	}
+	data, err := s.marshal(series)
 	if err != nil {
-		return nil, fmt.Errorf("unable to marshal protobuf: %w", err)
+		return nil, 0, err
 	}
-	encoded := snappy.Encode(nil, data)
-	buf.Write(encoded)
-	return buf.Bytes(), nil
+	return s.compress(data), len(data), nil
 }
 
-func hasLabel(name string, labels []prompb.Label) bool {
-	for _, label := range labels {
-		if name == label.Name {
-			return true
+// assemble converts the given metrics into Prometheus series and applies the
+// options operating on the batch as a whole. The number of series dropped on
+// the way is returned alongside. An error is returned if the assembly does
+// not finish within the configured timeout.
+func (s *Serializer) assemble(metrics []telegraf.Metric) ([]timeSeries, int, error) {
+	return s.assembleWithLabels(metrics, nil)
+}
+
+// assembleWithLabels assembles the series like assemble with the given labels
+// added to all series.
+func (s *Serializer) assembleWithLabels(metrics []telegraf.Metric, labelSet map[string]string) ([]timeSeries, int, error) {
+	run := newAssemblyRun()
+	if s.SerializationTimeout <= 0 {
+		return s.assembleSeries(run, metrics, labelSet)
+	}
+
+	var series []timeSeries
+	var dropped int
+	var err error
+	if werr := watchdog(time.Duration(s.SerializationTimeout), run, func() {
+		series, dropped, err = s.assembleSeries(run, metrics, labelSet)
+	}); werr != nil {
+		return nil, 0, werr
+	}
+	return series, dropped, err
+}
+
+// watchdog runs the given function and returns an error if it does not
+// finish within the timeout. In this case the run is abandoned, stopping the
+// function at its next cancellation check without changing the serializer
+// state. The results of the function must not be used after an error.
+func watchdog(timeout time.Duration, run *assemblyRun, fn func()) error {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		fn()
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-done:
+		return nil
+	case <-timer.C:
+		if !run.abandon() {
+			// The state was changed already, so the results must be used
+			<-done
+			return nil
 		}
+		return fmt.Errorf("serialization exceeded timeout of %v", timeout)
 	}
-	return false
 }
 
-func (s *Serializer) appendCommonLabels(labels []prompb.Label, metric telegraf.Metric) []prompb.Label {
-	for _, tag := range metric.TagList() {
-		// Ignore special tags for histogram and summary types.
-		switch metric.Type() {
-		case telegraf.Histogram:
-			if tag.Key == "le" {
-				continue
-			}
-		case telegraf.Summary:
-			if tag.Key == "quantile" {
-				continue
+// assembleSeries implements the assembly of series without timeout. Changes
+// to the serializer state are applied at the end unless the run was abandoned.
+func (s *Serializer) assembleSeries(run *assemblyRun, metrics []telegraf.Metric, labelSet map[string]string) ([]timeSeries, int, error) {
+	update := newStateUpdate()
+	promTS, dropped, err := s.convert(run.ctx, metrics, labelSet, update)
+	if err != nil {
+		return nil, 0, err
+	}
+	if err := run.ctx.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	promTS, processed, err := s.processSeries(promTS, true, update)
+	if err != nil {
+		return nil, 0, err
+	}
+	if err := run.apply(s, update); err != nil {
+		return nil, 0, err
+	}
+	return promTS, dropped + processed, nil
+}
+
+// processSeries applies the options operating on the converted series as a
+// whole and returns the number of series dropped on the way. Meta-series such
+// as the build-info or heartbeat series as well as the limit of series per
+// metric name are only applied to complete batches. Changes to the serializer
+// state are collected in the given update.
+func (s *Serializer) processSeries(promTS []timeSeries, batch bool, update *stateUpdate) ([]timeSeries, int, error) {
+	var dropped int
+
+	// Suppress resent samples older than the ones serialized in previous
+	// batches.
+	if s.DedupScope == "serializer" {
+		var outdated int
+		if promTS, outdated = s.dedup.filter(promTS, time.Now(), update.timestamps); outdated > 0 {
+			s.Log.Debugf("dropped %d series older than previously serialized samples", outdated)
+			dropped += outdated
+		}
+	}
+
+	// Protect the receiver from runaway cardinality by dropping new series
+	// of metric names exceeding the limit of distinct series.
+	if s.CardinalityLimit > 0 {
+		var exceeded map[string]int
+		promTS, exceeded = s.cardinality.filter(promTS, time.Now(), update.series)
+		names := make([]string, 0, len(exceeded))
+		for name := range exceeded {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			s.Log.Warnf("dropped %d new series of metric name %q exceeding the cardinality limit of %d series",
+				exceeded[name], name, s.CardinalityLimit)
+			dropped += exceeded[name]
+		}
+	}
+
+	if batch && s.MaxSeriesPerName > 0 {
+		n := len(promTS)
+		var err error
+		if promTS, err = s.limitSeriesPerName(promTS); err != nil {
+			return nil, 0, err
+		}
+		dropped += n - len(promTS)
+	}
+
+	// Compute the write ID from the content before adding series depending on
+	// the current time to get the same ID for identical batches.
+	var id string
+	if s.EmitWriteID {
+		id = writeID(promTS)
+	}
+
+	if batch {
+		// Count the series per family before adding any meta-series so only
+		// the series originating from the metrics are accounted.
+		var cardinality []timeSeries
+		if s.EmitFamilyCardinality {
+			cardinality = familyCardinalityTS(promTS, time.Now())
+		}
+
+		// Add a single build-info series per batch serving as a stable join
+		// target for dashboards, even for empty batches to keep the target.
+		if s.EmitBuildInfo {
+			promTS = append(promTS, s.buildInfoTS(time.Now()))
+		}
+
+		// Add a heartbeat series even to empty batches to prove liveness of
+		// the pipeline.
+		if s.EmitHeartbeat {
+			promTS = append(promTS, heartbeatTS(time.Now()))
+		}
+		promTS = append(promTS, cardinality...)
+
+		// Handle batches without any series as some receivers reject empty
+		// write requests. Omitting the payload is left to the encoding.
+		if len(promTS) == 0 {
+			switch s.EmptyBatchPolicy {
+			case "heartbeat":
+				promTS = append(promTS, heartbeatTS(time.Now()))
+			case "error":
+				return nil, 0, errors.New("batch contains no series")
 			}
 		}
+	}
+
+	// Identify each series by its labels for correlating retries at the
+	// receiver, independent of the write ID differing between batches.
+	if s.EmitSeriesID {
+		addSeriesIDs(promTS)
+	}
+
+	// Allow receivers and proxies to route the series by the protocol of
+	// the producing serializer, e.g. during a migration.
+	if s.EmitProtocolLabel {
+		addProtocolLabel(promTS, s.protocol())
+	}
+
+	if s.EmitWriteID {
+		addWriteID(promTS, id)
+	}
+
+	// Buckets must be in ascending order of their boundary for receivers
+	// and the text rendering. Sorted series are in label order instead.
+	if s.SortMetrics {
+		sort.Slice(promTS, func(i, j int) bool {
+			return s.seriesLess(promTS[i].Labels, promTS[j].Labels)
+		})
+	} else {
+		s.orderBuckets(promTS)
+	}
+
+	// Receivers with out-of-order ingestion write batches faster with the
+	// samples ascending in time across the whole batch.
+	if s.GlobalTimestampSort {
+		orderByTimestamp(promTS)
+	}
+
+	return promTS, dropped, nil
+}
+
+// conversion holds the series and bookkeeping of converting metrics
+type conversion struct {
+	entries               map[MetricKey]timeSeries
+	substituted           map[string]bool
+	shortenedNames        map[string]bool
+	reservedNames         map[string]bool
+	clampedCounters       map[string]bool
+	labelCollisions       map[string]bool
+	quantileBuckets       map[MetricKey]MetricKey
+	buckets               map[MetricKey]bool
+	placeholders          map[MetricKey]bool
+	totals                map[MetricKey]cumulativeTotal
+	duplicateBuckets      map[string]bool
+	typeConflicts         map[string]bool
+	familyTypes           map[string]familyOccurrence
+	substitutedTimestamps int
+	clampedTimestamps     int
+	limitedSeries         int
+	dropped               int
+	lastErr               error
+}
+
+func newConversion() *conversion {
+	return &conversion{
+		entries:          make(map[MetricKey]timeSeries),
+		substituted:      make(map[string]bool),
+		shortenedNames:   make(map[string]bool),
+		reservedNames:    make(map[string]bool),
+		clampedCounters:  make(map[string]bool),
+		labelCollisions:  make(map[string]bool),
+		quantileBuckets:  make(map[MetricKey]MetricKey),
+		buckets:          make(map[MetricKey]bool),
+		placeholders:     make(map[MetricKey]bool),
+		totals:           make(map[MetricKey]cumulativeTotal),
+		duplicateBuckets: make(map[string]bool),
+		typeConflicts:    make(map[string]bool),
+		familyTypes:      make(map[string]familyOccurrence),
+	}
+}
+
+// familyOccurrence is the metric type of a metric family at its first
+// occurrence, identified by the index of the metric in the converted metrics.
+type familyOccurrence struct {
+	metricType prompb.MetricMetadata_MetricType
+	index      int
+}
+
+// observeFamily records the type of the metric family of the given metadata
+// if the family did not occur before.
+func (c *conversion) observeFamily(metadata prompb.MetricMetadata, index int) {
+	if _, found := c.familyTypes[metadata.MetricFamilyName]; !found {
+		c.familyTypes[metadata.MetricFamilyName] = familyOccurrence{metricType: metadata.Type, index: index}
+	}
+}
+
+// convert converts the given metrics into deduplicated Prometheus series with
+// the given labels added and returns the number of series dropped due to
+// conversion errors. The conversion stops early with an error if the given
+// context is cancelled. Changes to the serializer state are collected in the
+// given update.
+func (s *Serializer) convert(ctx context.Context, metrics []telegraf.Metric, labelSet map[string]string, update *stateUpdate) ([]timeSeries, int, error) {
+	now := time.Now()
+
+	var c *conversion
+	var err error
+	if s.Concurrency > 1 && len(metrics) > 1 {
+		c, err = s.convertConcurrently(ctx, metrics, labelSet, now)
+	} else {
+		c, err = s.convertPartition(ctx, metrics, labelSet, now)
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	for key, total := range c.totals {
+		update.totals[key] = total
+	}
+
+	// Series of different metric types must not be mixed, keep the first
+	// occurring type unless conflicts should be rejected.
+	if len(c.typeConflicts) > 0 {
+		keys := make([]string, 0, len(c.typeConflicts))
+		for k := range c.typeConflicts {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		if s.TypeConflictPolicy == "error" {
+			return nil, 0, fmt.Errorf("conflicting metric types of series %q", keys)
+		}
+		s.Log.Warnf("resolved conflicting metric types of series %q using policy %q", keys, s.TypeConflictPolicy)
+	}
 
-		name, ok := prometheus.SanitizeLabelName(tag.Key)
+	// Scale the buckets converted from summary quantiles by the number of
+	// observations of the summary.
+	for metrickey, countkey := range c.quantileBuckets {
+		bucket, ok := c.entries[metrickey]
 		if !ok {
 			continue
 		}
+		count, ok := c.entries[countkey]
+		if !ok {
+			delete(c.entries, metrickey)
+			s.traceAndKeepErr(c, "failed to convert %q: summary has no count", seriesName(bucket.Labels))
+			continue
+		}
+		if count.Samples[0].Timestamp != bucket.Samples[0].Timestamp {
+			delete(c.entries, metrickey)
+			s.traceAndKeepErr(c, "failed to convert %q: summary has no count with the same timestamp", seriesName(bucket.Labels))
+			continue
+		}
+		bucket.Samples[0].Value = math.Round(bucket.Samples[0].Value * count.Samples[0].Value)
+	}
+
+	// Strict receivers reject histograms without a count instead of the
+	// zero count synthesized for the buckets.
+	if s.RequireHistogramCount {
+		names, removed := s.checkHistogramCounts(c.entries, c.placeholders, s.MissingHistogramCountAction == "drop")
+		if len(names) > 0 {
+			if s.MissingHistogramCountAction == "error" {
+				return nil, 0, fmt.Errorf("histograms %q have no count", names)
+			}
+			s.Log.Warnf("dropped histograms %q without count", names)
+		}
+		c.dropped += removed
+	}
 
-		// remove tags with empty values
-		if tag.Value == "" {
+	// Summaries with a sum but without a count are incomplete and cannot
+	// be used for computing the average of the observations.
+	if s.IncompleteSummaryAction != "" && s.IncompleteSummaryAction != "keep" {
+		names, removed := s.checkSummaryCounts(c.entries)
+		if len(names) > 0 {
+			switch s.IncompleteSummaryAction {
+			case "drop":
+				s.Log.Warnf("dropped summaries %q without count", names)
+			case "gauge":
+				s.Log.Warnf("degraded sums of summaries %q without count to gauges", names)
+			case "synthesize":
+				s.Log.Warnf("synthesized zero counts for summaries %q without count", names)
+			}
+		}
+		c.dropped += removed
+	}
+
+	// Cumulative bucket counts must not decrease with increasing boundaries
+	// as otherwise quantiles cannot be estimated.
+	if s.ValidateBucketMonotonicity {
+		affected, removed := s.checkBucketMonotonicity(c.entries)
+		if affected > 0 {
+			if s.BucketMonotonicityAction == "drop" {
+				s.Log.Warnf("dropped %d histograms with non-monotonic buckets", affected)
+			} else {
+				s.Log.Warnf("repaired %d histograms with non-monotonic buckets", affected)
+			}
+		}
+		c.dropped += removed
+	}
+
+	// A NaN sum renders queries on the sum of the histogram useless.
+	if s.ValidateHistogramConsistency {
+		affected, removed := s.checkHistogramSums(c.entries)
+		if affected > 0 {
+			switch s.HistogramNaNSumAction {
+			case "drop":
+				s.Log.Warnf("dropped %d histograms with NaN sums", affected)
+			case "zero":
+				s.Log.Warnf("zeroed the sums of %d histograms with NaN sums", affected)
+			default:
+				s.Log.Warnf("found %d histograms with NaN sums", affected)
+			}
+		}
+		c.dropped += removed
+	}
+
+	// All series of a metric family must share the same metadata type to
+	// avoid ambiguous metadata at the receiver.
+	if families := s.resolveFamilyTypes(c); len(families) > 0 {
+		if s.MetadataConflictPolicy == "error" {
+			return nil, 0, fmt.Errorf("conflicting metadata types of metric families %q", families)
+		}
+		s.Log.Warnf("resolved conflicting metadata types of metric families %q using policy %q", families, s.MetadataConflictPolicy)
+	}
+
+	if c.lastErr != nil {
+		// log only the last recorded error in the batch, as it could have many errors and logging each one
+		// could be too verbose. The following log line still provides enough info for user to act on.
+		s.Log.Warnf("some series were dropped, %d series left to send; last recorded error: %v", len(c.entries), c.lastErr)
+	}
+	if len(c.duplicateBuckets) > 0 {
+		keys := make([]string, 0, len(c.duplicateBuckets))
+		for k := range c.duplicateBuckets {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		s.Log.Warnf("resolved duplicate histogram buckets %v using policy %q", keys, s.DuplicateBucketPolicy)
+	}
+	if c.limitedSeries > 0 {
+		if s.HashExcessLabels {
+			s.Log.Warnf("hashed excess labels of %d series exceeding the limit of %d labels", c.limitedSeries, s.MaxLabelsPerSeries)
+		} else {
+			s.Log.Warnf("dropped labels of %d series exceeding the limit of %d labels", c.limitedSeries, s.MaxLabelsPerSeries)
+		}
+	}
+	if c.substitutedTimestamps > 0 {
+		s.Log.Warnf("replaced zero timestamp of %d metrics by the current time", c.substitutedTimestamps)
+	}
+	if c.clampedTimestamps > 0 {
+		s.Log.Warnf("clamped future timestamp of %d metrics to the current time", c.clampedTimestamps)
+	}
+	if len(c.substituted) > 0 {
+		keys := make([]string, 0, len(c.substituted))
+		for k := range c.substituted {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		s.Log.Warnf("replaced invalid field names %q by placeholder %q", keys, s.InvalidFieldNamePlaceholder)
+	}
+	if len(c.shortenedNames) > 0 {
+		s.Log.Warnf("shortened %d metric names exceeding %d characters", len(c.shortenedNames), s.MaxMetricNameLength)
+	}
+	if len(c.reservedNames) > 0 {
+		keys := make([]string, 0, len(c.reservedNames))
+		for k := range c.reservedNames {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		switch s.ReservedNamePolicy {
+		case "prefix":
+			s.Log.Warnf("prefixed reserved metric names %q with %q", keys, s.ReservedNamePrefix)
+		case "drop":
+			s.Log.Warnf("dropped series with reserved metric names %q", keys)
+		default:
+			s.Log.Warnf("metric names %q collide with reserved names", keys)
+		}
+	}
+	if len(c.labelCollisions) > 0 {
+		keys := make([]string, 0, len(c.labelCollisions))
+		for k := range c.labelCollisions {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		s.Log.Warnf("resolved tags colliding in labels %q after sanitization using policy %q", keys, s.LabelCollisionPolicy)
+	}
+	if len(c.clampedCounters) > 0 {
+		keys := make([]string, 0, len(c.clampedCounters))
+		for k := range c.clampedCounters {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		s.Log.Warnf("clamped negative values of counters %q to zero", keys)
+	}
+
+	var promTS = make([]timeSeries, 0, len(c.entries)+1)
+	for key, promts := range c.entries {
+		promts.placeholder = c.placeholders[key]
+		promTS = append(promTS, promts)
+	}
+
+	return promTS, c.dropped, nil
+}
+
+// resolveFamilyTypes unifies the metadata type of the series of metric
+// families with conflicting types according to the metadata conflict policy,
+// i.e. to the type of the first occurrence or the type of most series of the
+// family. Families are left untouched if conflicts should be rejected. The
+// sorted names of the conflicting families are returned.
+func (s *Serializer) resolveFamilyTypes(c *conversion) []string {
+	counts := make(map[string]map[prompb.MetricMetadata_MetricType]int)
+	for _, ts := range c.entries {
+		name := ts.metadata.MetricFamilyName
+		if counts[name] == nil {
+			counts[name] = make(map[prompb.MetricMetadata_MetricType]int)
+		}
+		counts[name][ts.metadata.Type]++
+	}
+
+	resolved := make(map[string]prompb.MetricMetadata_MetricType)
+	for name, types := range counts {
+		if len(types) < 2 {
 			continue
 		}
 
-		labels = append(labels, prompb.Label{Name: name, Value: tag.Value})
+		// Use the most common type if requested or if the first occurrence
+		// did not make it into the batch, preferring the type of the first
+		// occurrence and then the lower type on ties for deterministic output.
+		first := c.familyTypes[name].metricType
+		chosen := first
+		if s.MetadataConflictPolicy == "most-common" || types[first] == 0 {
+			for t, n := range types {
+				if n > types[chosen] || (n == types[chosen] && chosen != first && t < chosen) {
+					chosen = t
+				}
+			}
+		}
+		resolved[name] = chosen
+	}
+	if len(resolved) == 0 {
+		return nil
+	}
+
+	families := make([]string, 0, len(resolved))
+	for name := range resolved {
+		families = append(families, name)
+	}
+	sort.Strings(families)
+	if s.MetadataConflictPolicy == "error" {
+		return families
 	}
 
-	if !s.StringAsLabel {
-		return labels
+	for key, ts := range c.entries {
+		if t, found := resolved[ts.metadata.MetricFamilyName]; found && ts.metadata.Type != t {
+			ts.metadata.Type = t
+			c.entries[key] = ts
+		}
 	}
+	return families
+}
 
-	for _, field := range metric.FieldList() {
-		value, ok := field.Value.(string)
-		if !ok {
+// convertPartition converts the given metrics into series deduplicated within
+// the given metrics. The conversion stops early with an error if the given
+// context is cancelled.
+func (s *Serializer) convertPartition(ctx context.Context, metrics []telegraf.Metric, labelSet map[string]string, now time.Time) (*conversion, error) {
+	c := newConversion()
+	if s.HistogramAutoDetect {
+		metrics = s.detectHistograms(metrics)
+	}
+
+	var labels = make([]prompb.Label, 0)
+	for index, metric := range metrics {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if s.isDropped(metric) {
+			continue
+		}
+
+		// Incorporate pre-serialized series as they are without converting
+		// the metric, e.g. when proxying remote-write data.
+		if s.convertRawSeries(c, metric, index) {
 			continue
 		}
 
-		name, ok := prometheus.SanitizeLabelName(field.Key)
+		metricTime, valueSource, ok, err := s.metricTimestamp(c, metric, now)
+		if err != nil {
+			return nil, err
+		}
 		if !ok {
 			continue
 		}
 
-		// If there is a tag with the same name as the string field, discard
-		// the field and use the tag instead.
-		if hasLabel(name, labels) {
+		if labels, err = s.metricLabels(c, labels[:0], metric, labelSet, metricTime); err != nil {
+			return nil, err
+		}
+
+		// Metrics without sample values are annotations and converted
+		// according to the policy, dropping them by default.
+		if s.convertAnnotation(c, metric, labels, metricTime) {
 			continue
 		}
 
-		labels = append(labels, prompb.Label{Name: name, Value: value})
+		for _, field := range metric.FieldList() {
+			if s.isExemplarField(field.Key) || s.isFieldTimestamp(metric, field.Key) {
+				continue
+			}
+			if valueSource != "" && field.Key != valueSource {
+				continue
+			}
+			if err := s.convertField(c, metric, field, labels, metricTime, index); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return c, nil
+}
+
+// convertField converts a single field of the metric with the given labels
+// and timestamp into a series and adds it to the conversion.
+func (s *Serializer) convertField(c *conversion, metric telegraf.Metric, field *telegraf.Field, labels []prompb.Label, metricTime time.Time, index int) error {
+	if number, ok := s.parseStringNumber(field.Value); ok {
+		field = &telegraf.Field{Key: field.Key, Value: number}
+	}
+
+	timestamp, ok := s.fieldTimestamp(c, metric, field.Key, metricTime)
+	if !ok {
+		return nil
+	}
+
+	// The fields of counters, gauges and untyped metrics can share the name
+	// of the measurement and are distinguished by a label.
+	fieldAsLabel := s.FieldAsLabel && metric.Type() != telegraf.Histogram && metric.Type() != telegraf.Summary
+
+	metricName, exponent, ok := s.metricName(c, metric, field.Key, fieldAsLabel)
+	if !ok {
+		return nil
+	}
+	metadata := s.metricMetadata(metric, metricName)
+	seriesLabels, err := s.seriesLabels(c, labels, metric, field.Key, metricName, metadata.Type, fieldAsLabel)
+	if err != nil {
+		return err
+	}
+
+	var metrickey MetricKey
+	var promts prompb.TimeSeries
+	switch metric.Type() {
+	case telegraf.Counter, telegraf.Gauge, telegraf.Untyped:
+		metrickey, promts, ok = s.convertValue(c, metric, field, metricName, seriesLabels, exponent, timestamp, &metadata, index)
+	case telegraf.Histogram:
+		metrickey, promts, ok = s.convertHistogramField(c, metric, field, metricName, seriesLabels, timestamp, metadata)
+	case telegraf.Summary:
+		metrickey, promts, ok = s.convertSummaryField(c, metric, field, metricName, seriesLabels, timestamp, &metadata)
+	default:
+		return fmt.Errorf("unknown type %v", metric.Type())
+	}
+	if ok {
+		s.addSeries(c, metric, metrickey, promts, metadata, timestamp, index)
+	}
+	return nil
+}
+
+// addSeries adds the series converted from the given metric to the
+// conversion. A batch of metrics can contain multiple values for a single
+// Prometheus sample. If this metric is older than the existing sample then
+// it is skipped.
+func (s *Serializer) addSeries(c *conversion, metric telegraf.Metric, metrickey MetricKey, promts prompb.TimeSeries, metadata prompb.MetricMetadata, timestamp time.Time, index int) {
+	if m, ok := c.entries[metrickey]; ok {
+		if m.metadata.Type != metadata.Type {
+			c.typeConflicts[seriesName(promts.Labels)] = true
+			return
+		}
+		// Keep the exemplars of all samples of the series up to the limit
+		// independent of the sample timestamps.
+		if len(promts.Exemplars) > 0 || len(m.Exemplars) > 0 {
+			exemplars := s.limitExemplars(append(slices.Clone(m.Exemplars), promts.Exemplars...))
+			m.Exemplars = exemplars
+			promts.Exemplars = exemplars
+			c.entries[metrickey] = m
+		}
+		if !c.placeholders[metrickey] && timestamp.Before(time.Unix(0, m.Samples[0].Timestamp*1_000_000)) {
+			s.traceAndKeepErr(c, "metric %q has samples with timestamp %v older than already registered before", metric.Name(), timestamp)
+			return
+		}
+	}
+	c.entries[metrickey] = timeSeries{TimeSeries: promts, metadata: metadata}
+	c.observeFamily(metadata, index)
+	delete(c.placeholders, metrickey)
+}
+
+// traceAndKeepErr logs on Trace level every passed error and counts the
+// dropped series. With each call it updates the last error of the conversion,
+// so it can be logged later with higher level.
+func (s *Serializer) traceAndKeepErr(c *conversion, format string, a ...any) {
+	c.lastErr = fmt.Errorf(format, a...)
+	s.Log.Trace(c.lastErr)
+	c.dropped++
+}
+
+// seriesName returns the value of the "__name__" label.
+func seriesName(labels []prompb.Label) string {
+	name, _ := labelValue(labels, "__name__")
+	return name
+}
+
+// isDropped returns true if the metric is marked for exclusion by carrying
+// the drop tag, with the configured value if any.
+func (s *Serializer) isDropped(metric telegraf.Metric) bool {
+	if s.DropTag == "" {
+		return false
 	}
+	value, found := metric.GetTag(s.DropTag)
+	return found && (s.DropTagValue == "" || value == s.DropTagValue)
+}
 
-	return labels
+// originTag returns the name of the tag holding the input plugin the metric
+// originates from.
+func (s *Serializer) originTag() string {
+	if s.OriginTag == "" {
+		return "origin"
+	}
+	return s.OriginTag
 }
 
 func MakeMetricKey(labels []prompb.Label) MetricKey {
@@ -318,9 +1381,18 @@ func MakeMetricKey(labels []prompb.Label) MetricKey {
 }
 
 func getPromTS(name string, labels []prompb.Label, value float64, ts time.Time, extraLabels ...prompb.Label) (MetricKey, prompb.TimeSeries) {
-	labelscopy := make([]prompb.Label, len(labels), len(labels)+1)
+	labelscopy := make([]prompb.Label, len(labels), len(labels)+len(extraLabels)+1)
 	copy(labelscopy, labels)
 
+	// The generated labels take precedence over labels of tags sanitized to
+	// the same name to avoid duplicate labels.
+	labelscopy = slices.DeleteFunc(labelscopy, func(l prompb.Label) bool {
+		if l.Name == model.MetricNameLabel {
+			return true
+		}
+		return slices.ContainsFunc(extraLabels, func(extra prompb.Label) bool { return extra.Name == l.Name })
+	})
+
 	sample := []prompb.Sample{{
 		// Timestamp is int milliseconds for remote write.
 		Timestamp: ts.UnixNano() / int64(time.Millisecond),
@@ -1,18 +1,20 @@
 package prometheusremotewrite
 
 import (
-	"bytes"
+	"errors"
 	"fmt"
 	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
-	"github.com/golang/snappy"
 	"github.com/prometheus/common/model"
 	"github.com/prometheus/prometheus/prompb"
 	"github.com/stretchr/testify/require"
 
 	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/config"
+	"github.com/influxdata/telegraf/internal"
 	"github.com/influxdata/telegraf/plugins/serializers"
 	"github.com/influxdata/telegraf/testutil"
 )
@@ -275,6 +277,64 @@ func TestRemoteWriteSerializeNegative(t *testing.T) {
 	assert("failed to parse", err)
 }
 
+func TestRemoteWriteSerializeBoundLabelsOnOtherTypes(t *testing.T) {
+	metrics := []telegraf.Metric{
+		testutil.MustMetric(
+			"queue",
+			map[string]string{"le": "0.5"},
+			map[string]interface{}{"wait_bucket": 3.0},
+			time.Unix(0, 0),
+			telegraf.Gauge,
+		),
+		testutil.MustMetric(
+			"queue",
+			map[string]string{"le": "1"},
+			map[string]interface{}{"wait_bucket": 2.0},
+			time.Unix(0, 0),
+			telegraf.Gauge,
+		),
+		testutil.MustMetric(
+			"rpc",
+			map[string]string{"quantile": "0.9"},
+			map[string]interface{}{"latency": 7.0},
+			time.Unix(0, 0),
+			telegraf.Counter,
+		),
+	}
+
+	clog := &testutil.CaptureLogger{}
+	s := &Serializer{
+		Log:                        clog,
+		SortMetrics:                true,
+		WriteMetadata:              true,
+		HistogramAutoDetect:        true,
+		ValidateBucketMonotonicity: true,
+	}
+	require.NoError(t, s.Init())
+
+	data, err := s.SerializeBatch(metrics)
+	require.NoError(t, err)
+	req, err := DecodePayload(data)
+	require.NoError(t, err)
+
+	expected := `
+queue_wait_bucket{le="0.5"} 3
+queue_wait_bucket{le="1"} 2
+rpc_latency{quantile="0.9"} 7
+`
+	require.Equal(t, strings.TrimSpace(expected), strings.TrimSpace(RenderText(req)))
+
+	types := make(map[string]string, len(req.Metadata))
+	for _, metadata := range req.Metadata {
+		types[metadata.MetricFamilyName] = metadata.Type.String()
+	}
+	require.Equal(t, map[string]string{
+		"queue_wait_bucket": "GAUGE",
+		"rpc_latency":       "COUNTER",
+	}, types)
+	require.Empty(t, clog.Warnings())
+}
+
 func TestRemoteWriteSerializeBatch(t *testing.T) {
 	tests := []struct {
 		name          string
@@ -769,42 +829,4335 @@ rpc_duration_seconds_sum 17560473
 	}
 }
 
-func prompbToText(data []byte) ([]byte, error) {
-	var buf = bytes.Buffer{}
-	protobuff, err := snappy.Decode(nil, data)
-	if err != nil {
-		return nil, err
+func TestRemoteWriteInitInvalid(t *testing.T) {
+	negative := -1
+	tests := []struct {
+		name       string
+		serializer *Serializer
+		expected   string
+	}{
+		{
+			name:       "protocol",
+			serializer: &Serializer{Protocol: "3.0"},
+			expected:   `invalid remote write protocol "3.0"`,
+		},
+		{
+			name:       "separate metadata request with protocol 2.0",
+			serializer: &Serializer{Protocol: "2.0", SeparateMetadataRequest: true},
+			expected:   "separate metadata requests are not supported for remote write protocol 2.0",
+		},
+		{
+			name:       "max metadata entries",
+			serializer: &Serializer{MaxMetadataEntries: -1},
+			expected:   "invalid maximum metadata entries -1",
+		},
+		{
+			name:       "empty batch policy",
+			serializer: &Serializer{EmptyBatchPolicy: "skip"},
+			expected:   `invalid empty batch policy "skip"`,
+		},
+		{
+			name:       "invalid field name policy",
+			serializer: &Serializer{InvalidFieldNamePolicy: "foo"},
+			expected:   `invalid field name policy "foo"`,
+		},
+		{
+			name:       "invalid field name placeholder",
+			serializer: &Serializer{InvalidFieldNamePlaceholder: "@@@"},
+			expected:   `invalid field name placeholder "@@@"`,
+		},
+		{
+			name:       "leading digit prefix starting with digit",
+			serializer: &Serializer{LeadingDigitPrefix: "5"},
+			expected:   `invalid leading digit prefix "5"`,
+		},
+		{
+			name:       "leading digit prefix with invalid character",
+			serializer: &Serializer{LeadingDigitPrefix: "a-"},
+			expected:   `invalid leading digit prefix "a-"`,
+		},
+		{
+			name:       "max exemplars per series",
+			serializer: &Serializer{MaxExemplarsPerSeries: &negative},
+			expected:   "invalid maximum exemplars per series -1",
+		},
+		{
+			name:       "future timestamp action",
+			serializer: &Serializer{FutureTimestampAction: "now"},
+			expected:   `invalid future timestamp action "now"`,
+		},
+		{
+			name:       "timestamp resolution",
+			serializer: &Serializer{TimestampResolution: "us"},
+			expected:   `invalid timestamp resolution "us"`,
+		},
+		{
+			name:       "max metric name length too small",
+			serializer: &Serializer{MaxMetricNameLength: 16},
+			expected:   "maximum metric name length 16 too small",
+		},
+		{
+			name:       "metric name length action",
+			serializer: &Serializer{MaxMetricNameLength: 64, MetricNameLengthAction: "foo"},
+			expected:   `invalid metric name length action "foo"`,
+		},
+		{
+			name:       "original field label",
+			serializer: &Serializer{OriginalFieldLabel: "field-name"},
+			expected:   `invalid original field label "field-name"`,
+		},
+		{
+			name:       "duplicate bucket policy",
+			serializer: &Serializer{DuplicateBucketPolicy: "sum"},
+			expected:   `invalid duplicate bucket policy "sum"`,
+		},
+		{
+			name:       "max labels per series too small",
+			serializer: &Serializer{MaxLabelsPerSeries: 1},
+			expected:   "maximum labels per series 1 too small",
+		},
+		{
+			name:       "max labels per series too small for hashing",
+			serializer: &Serializer{MaxLabelsPerSeries: 2, HashExcessLabels: true},
+			expected:   "maximum labels per series 2 too small for hashing excess labels",
+		},
+		{
+			name:       "max labels per series too small for generated labels",
+			serializer: &Serializer{MaxLabelsPerSeries: 3, EmitSeriesID: true, EmitWriteID: true},
+			expected:   "maximum labels per series 3 too small",
+		},
+		{
+			name:       "max labels per series action",
+			serializer: &Serializer{MaxLabelsPerSeriesAction: "truncate"},
+			expected:   `invalid max labels per series action "truncate"`,
+		},
+		{
+			name:       "dedup scope",
+			serializer: &Serializer{DedupScope: "global"},
+			expected:   `invalid dedup scope "global"`,
+		},
+		{
+			name:       "shard count",
+			serializer: &Serializer{ShardCount: -1},
+			expected:   "invalid shard count -1",
+		},
+		{
+			name:       "bucket label name",
+			serializer: &Serializer{BucketLabelName: "le-bound"},
+			expected:   `invalid bucket label name "le-bound"`,
+		},
+		{
+			name:       "quantile label name",
+			serializer: &Serializer{QuantileLabelName: "0q"},
+			expected:   `invalid quantile label name "0q"`,
+		},
+		{
+			name:       "timestamp value source without field",
+			serializer: &Serializer{TimestampValueSource: "value"},
+			expected:   "timestamp value source requires a timestamp value field",
+		},
+		{
+			name:       "timestamp value source equal to field",
+			serializer: &Serializer{TimestampValueField: "finished_at", TimestampValueSource: "finished_at"},
+			expected:   `timestamp value source "finished_at" must differ from the timestamp value field`,
+		},
+		{
+			name:       "annotation policy",
+			serializer: &Serializer{AnnotationPolicy: "label"},
+			expected:   `invalid annotation policy "label"`,
+		},
+		{
+			name:       "metric hash label",
+			serializer: &Serializer{MetricHashLabel: "metric-hash"},
+			expected:   `invalid metric hash label "metric-hash"`,
+		},
+		{
+			name:       "host tag behavior",
+			serializer: &Serializer{HostTagBehavior: "rename"},
+			expected:   `invalid host tag behavior "rename"`,
+		},
+		{
+			name:       "serialization timeout",
+			serializer: &Serializer{SerializationTimeout: config.Duration(-time.Second)},
+			expected:   "invalid serialization timeout -1s",
+		},
+		{
+			name:       "label collision policy",
+			serializer: &Serializer{LabelCollisionPolicy: "first-wins"},
+			expected:   `invalid label collision policy "first-wins"`,
+		},
+		{
+			name:       "missing timestamp policy",
+			serializer: &Serializer{MissingTimestampPolicy: "zero"},
+			expected:   `invalid missing timestamp policy "zero"`,
+		},
+		{
+			name:       "type conflict policy",
+			serializer: &Serializer{TypeConflictPolicy: "last-wins"},
+			expected:   `invalid type conflict policy "last-wins"`,
+		},
+		{
+			name:       "metadata conflict policy",
+			serializer: &Serializer{MetadataConflictPolicy: "last-wins"},
+			expected:   `invalid metadata conflict policy "last-wins"`,
+		},
+		{
+			name:       "value precision",
+			serializer: &Serializer{ValuePrecision: -1},
+			expected:   "invalid value precision -1",
+		},
+		{
+			name:       "value expression",
+			serializer: &Serializer{ValueExpression: "value ** 2"},
+			expected:   `invalid value expression "value ** 2"`,
+		},
+		{
+			name: "label set with metric name",
+			serializer: &Serializer{
+				BucketLabelName: "bucket",
+				LabelSets:       map[string]map[string]string{"primary": {"__name__": "foo"}},
+			},
+			expected: `invalid label "__name__" in label set "primary"`,
+		},
+		{
+			name: "label set with reserved prefix",
+			serializer: &Serializer{
+				BucketLabelName: "bucket",
+				LabelSets:       map[string]map[string]string{"primary": {"__tenant__": "foo"}},
+			},
+			expected: `invalid label "__tenant__" in label set "primary"`,
+		},
+		{
+			name: "label set with bucket label",
+			serializer: &Serializer{
+				BucketLabelName: "bucket",
+				LabelSets:       map[string]map[string]string{"primary": {"le": "foo"}},
+			},
+			expected: `reserved label "le" in label set "primary"`,
+		},
+		{
+			name: "label set with quantile label",
+			serializer: &Serializer{
+				BucketLabelName: "bucket",
+				LabelSets:       map[string]map[string]string{"primary": {"quantile": "foo"}},
+			},
+			expected: `reserved label "quantile" in label set "primary"`,
+		},
+		{
+			name: "label set with custom bucket label",
+			serializer: &Serializer{
+				BucketLabelName: "bucket",
+				LabelSets:       map[string]map[string]string{"primary": {"bucket": "foo"}},
+			},
+			expected: `reserved label "bucket" in label set "primary"`,
+		},
+		{
+			name:       "reserved name policy",
+			serializer: &Serializer{ReservedNamePolicy: "rename"},
+			expected:   `invalid reserved name policy "rename"`,
+		},
+		{
+			name:       "reserved name prefix",
+			serializer: &Serializer{ReservedNamePolicy: "prefix", ReservedNamePrefix: "0-"},
+			expected:   `invalid reserved name prefix "0-"`,
+		},
+		{
+			name:       "label value case",
+			serializer: &Serializer{LabelValueCase: "title"},
+			expected:   `invalid label value case "title"`,
+		},
+		{
+			name:       "cardinality limit",
+			serializer: &Serializer{CardinalityLimit: -1},
+			expected:   "invalid cardinality limit -1",
+		},
+		{
+			name:       "concurrency",
+			serializer: &Serializer{Concurrency: -1},
+			expected:   "invalid concurrency -1",
+		},
+		{
+			name:       "compression concurrency",
+			serializer: &Serializer{CompressionConcurrency: -1},
+			expected:   "invalid compression concurrency -1",
+		},
+		{
+			name:       "bucket monotonicity action",
+			serializer: &Serializer{BucketMonotonicityAction: "ignore"},
+			expected:   `invalid bucket monotonicity action "ignore"`,
+		},
+		{
+			name:       "histogram NaN sum action",
+			serializer: &Serializer{HistogramNaNSumAction: "ignore"},
+			expected:   `invalid histogram NaN sum action "ignore"`,
+		},
+		{
+			name:       "missing histogram count action",
+			serializer: &Serializer{MissingHistogramCountAction: "synthesize"},
+			expected:   `invalid missing histogram count action "synthesize"`,
+		},
+		{
+			name:       "incomplete summary action",
+			serializer: &Serializer{IncompleteSummaryAction: "error"},
+			expected:   `invalid incomplete summary action "error"`,
+		},
 	}
-	var req prompb.WriteRequest
-	err = req.Unmarshal(protobuff)
-	if err != nil {
-		return nil, err
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.ErrorContains(t, tt.serializer.Init(), tt.expected)
+		})
 	}
-	samples := protoToSamples(&req)
-	for _, sample := range samples {
-		buf.WriteString(fmt.Sprintf("%s %s\n", sample.Metric.String(), sample.Value.String()))
+}
+
+func TestRemoteWriteSerializeBuildInfo(t *testing.T) {
+	for _, count := range []int{0, 1, 5} {
+		t.Run(fmt.Sprintf("%d metrics", count), func(t *testing.T) {
+			metrics := make([]telegraf.Metric, 0, count)
+			for i := range count {
+				metrics = append(metrics, testutil.MustMetric(
+					"cpu",
+					map[string]string{"cpu": fmt.Sprintf("cpu%d", i)},
+					map[string]interface{}{"time_idle": 42.0},
+					time.Unix(0, 0),
+				))
+			}
+
+			s := &Serializer{
+				Log:           &testutil.CaptureLogger{},
+				EmitBuildInfo: true,
+			}
+			data, err := s.SerializeBatch(metrics)
+			require.NoError(t, err)
+
+			req, err := DecodePayload(data)
+			require.NoError(t, err)
+			require.Len(t, req.Timeseries, count+1)
+
+			var found int
+			for _, ts := range req.Timeseries {
+				for _, l := range ts.Labels {
+					if l.Name == "__name__" && l.Value == "telegraf_build_info" {
+						found++
+						require.Equal(t, []prompb.Label{
+							{Name: "__name__", Value: "telegraf_build_info"},
+							{Name: "remote_write_version", Value: "0.1.0"},
+							{Name: "version", Value: internal.Version},
+						}, ts.Labels)
+						require.Len(t, ts.Samples, 1)
+						require.InDelta(t, 1.0, ts.Samples[0].Value, 0.0)
+					}
+				}
+			}
+			require.Equal(t, 1, found)
+		})
+	}
+}
+
+func TestRemoteWriteSerializeHeartbeat(t *testing.T) {
+	s := &Serializer{
+		Log:           &testutil.CaptureLogger{},
+		EmitHeartbeat: true,
 	}
+	require.NoError(t, s.Init())
+
+	before := time.Now().Unix()
+	data, err := s.SerializeBatch(nil)
+	require.NoError(t, err)
+	after := time.Now().Unix()
+
+	req, err := DecodePayload(data)
+	require.NoError(t, err)
+	require.Len(t, req.Timeseries, 1)
 
-	return buf.Bytes(), nil
+	ts := req.Timeseries[0]
+	require.Equal(t, []prompb.Label{{Name: "__name__", Value: "telegraf_serializer_heartbeat"}}, ts.Labels)
+	require.Len(t, ts.Samples, 1)
+	require.GreaterOrEqual(t, ts.Samples[0].Value, float64(before))
+	require.LessOrEqual(t, ts.Samples[0].Value, float64(after))
 }
 
-func protoToSamples(req *prompb.WriteRequest) model.Samples {
-	var samples model.Samples
+func TestRemoteWriteSerializeFamilyCardinality(t *testing.T) {
+	metrics := []telegraf.Metric{
+		testutil.MustMetric(
+			"cpu",
+			map[string]string{"host": "a"},
+			map[string]interface{}{"time_idle": 42.0, "time_user": 7.0},
+			time.Unix(0, 0),
+		),
+		testutil.MustMetric(
+			"cpu",
+			map[string]string{"host": "b"},
+			map[string]interface{}{"time_idle": 43.0},
+			time.Unix(0, 0),
+		),
+		testutil.MustMetric(
+			"prometheus",
+			map[string]string{"le": "0.5"},
+			map[string]interface{}{"http_request_duration_seconds_bucket": 10.0},
+			time.Unix(0, 0),
+			telegraf.Histogram,
+		),
+		testutil.MustMetric(
+			"prometheus",
+			nil,
+			map[string]interface{}{
+				"http_request_duration_seconds_count": 12.0,
+				"http_request_duration_seconds_sum":   3.5,
+			},
+			time.Unix(0, 0),
+			telegraf.Histogram,
+		),
+	}
+
+	s := &Serializer{
+		Log:                   &testutil.CaptureLogger{},
+		EmitFamilyCardinality: true,
+	}
+	require.NoError(t, s.Init())
+
+	data, err := s.SerializeBatch(metrics)
+	require.NoError(t, err)
+	req, err := DecodePayload(data)
+	require.NoError(t, err)
+
+	// Count the series of each family in the payload to compare with the
+	// emitted cardinality series.
+	families := map[string]string{
+		"cpu_time_idle":                        "cpu_time_idle",
+		"cpu_time_user":                        "cpu_time_user",
+		"http_request_duration_seconds_bucket": "http_request_duration_seconds",
+		"http_request_duration_seconds_count":  "http_request_duration_seconds",
+		"http_request_duration_seconds_sum":    "http_request_duration_seconds",
+	}
+	counted := make(map[string]float64)
+	reported := make(map[string]float64)
 	for _, ts := range req.Timeseries {
-		metric := make(model.Metric, len(ts.Labels))
-		for _, l := range ts.Labels {
-			metric[model.LabelName(l.Name)] = model.LabelValue(l.Value)
+		name := seriesName(ts.Labels)
+		if name == "telegraf_family_series_count" {
+			family, found := labelValue(ts.Labels, "family")
+			require.True(t, found)
+			reported[family] = ts.Samples[0].Value
+			continue
 		}
+		family, found := families[name]
+		require.True(t, found, name)
+		counted[family]++
+	}
+	require.Equal(t, map[string]float64{
+		"cpu_time_idle":                 2,
+		"cpu_time_user":                 1,
+		"http_request_duration_seconds": 4,
+	}, reported)
+	require.Equal(t, counted, reported)
+}
 
-		for _, s := range ts.Samples {
-			samples = append(samples, &model.Sample{
-				Metric:    metric,
-				Value:     model.SampleValue(s.Value),
-				Timestamp: model.Time(s.Timestamp),
-			})
+func TestRemoteWriteSerializeFamilyPriority(t *testing.T) {
+	metrics := []telegraf.Metric{
+		testutil.MustMetric(
+			"cpu",
+			map[string]string{"host": "a"},
+			map[string]interface{}{"time_idle": 42.0},
+			time.Unix(0, 0),
+		),
+		testutil.MustMetric(
+			"prometheus",
+			map[string]string{"le": "0.5"},
+			map[string]interface{}{"http_request_duration_seconds_bucket": 10.0},
+			time.Unix(0, 0),
+			telegraf.Histogram,
+		),
+		testutil.MustMetric(
+			"prometheus",
+			map[string]string{},
+			map[string]interface{}{"probe_success": 1.0},
+			time.Unix(0, 0),
+		),
+		testutil.MustMetric(
+			"mem",
+			map[string]string{},
+			map[string]interface{}{"free": 1024.0},
+			time.Unix(0, 0),
+		),
+	}
+
+	s := &Serializer{
+		Log:            &testutil.CaptureLogger{},
+		SortMetrics:    true,
+		FamilyPriority: []string{"probe_success", "http_request_duration_seconds"},
+	}
+	require.NoError(t, s.Init())
+
+	data, err := s.SerializeBatch(metrics)
+	require.NoError(t, err)
+	actual, err := prompbToText(data)
+	require.NoError(t, err)
+	expected := `
+probe_success 1
+http_request_duration_seconds_count 0
+http_request_duration_seconds_sum 0
+http_request_duration_seconds_bucket{le="+Inf"} 0
+http_request_duration_seconds_bucket{le="0.5"} 10
+mem_free 1024
+cpu_time_idle{host="a"} 42
+`
+	require.Equal(t, strings.TrimSpace(expected), strings.TrimSpace(string(actual)))
+}
+
+func TestRemoteWriteSerializeGlobalTimestampSort(t *testing.T) {
+	metrics := []telegraf.Metric{
+		testutil.MustMetric(
+			"cpu",
+			map[string]string{"host": "a"},
+			map[string]interface{}{"time_idle": 1.0},
+			time.Unix(30, 0),
+		),
+		testutil.MustMetric(
+			"mem",
+			map[string]string{"host": "a"},
+			map[string]interface{}{"free": 2.0},
+			time.Unix(10, 0),
+		),
+		testutil.MustMetric(
+			"cpu",
+			map[string]string{"host": "b"},
+			map[string]interface{}{"time_idle": 3.0},
+			time.Unix(20, 0),
+		),
+		testutil.MustMetric(
+			"disk",
+			map[string]string{"host": "a"},
+			map[string]interface{}{"used": 4.0},
+			time.Unix(10, 0),
+		),
+	}
+
+	for _, sorted := range []bool{false, true} {
+		s := &Serializer{
+			Log:                 &testutil.CaptureLogger{},
+			SortMetrics:         sorted,
+			GlobalTimestampSort: true,
+		}
+		require.NoError(t, s.Init())
+
+		data, err := s.SerializeBatch(metrics)
+		require.NoError(t, err)
+		req, err := DecodePayload(data)
+		require.NoError(t, err)
+
+		timestamps := make([]int64, 0, len(req.Timeseries))
+		for _, ts := range req.Timeseries {
+			timestamps = append(timestamps, ts.Samples[0].Timestamp)
+		}
+		require.Equal(t, []int64{10000, 10000, 20000, 30000}, timestamps)
+		if sorted {
+			// Series with the same timestamp keep the label order
+			require.Equal(t, "disk_used", seriesName(req.Timeseries[0].Labels))
+			require.Equal(t, "mem_free", seriesName(req.Timeseries[1].Labels))
+		}
+	}
+}
+
+func TestRemoteWriteSerializeEmptyBatchPolicy(t *testing.T) {
+	s := &Serializer{Log: &testutil.CaptureLogger{}}
+	require.NoError(t, s.Init())
+	data, err := s.SerializeBatch([]telegraf.Metric{})
+	require.NoError(t, err)
+	require.NotNil(t, data)
+	req, err := DecodePayload(data)
+	require.NoError(t, err)
+	require.Empty(t, req.Timeseries)
+
+	s = &Serializer{Log: &testutil.CaptureLogger{}, EmptyBatchPolicy: "nil"}
+	require.NoError(t, s.Init())
+	data, err = s.SerializeBatch([]telegraf.Metric{})
+	require.NoError(t, err)
+	require.Nil(t, data)
+
+	s = &Serializer{Log: &testutil.CaptureLogger{}, EmptyBatchPolicy: "heartbeat"}
+	require.NoError(t, s.Init())
+	data, err = s.SerializeBatch([]telegraf.Metric{})
+	require.NoError(t, err)
+	req, err = DecodePayload(data)
+	require.NoError(t, err)
+	require.Len(t, req.Timeseries, 1)
+	require.Equal(t, "telegraf_serializer_heartbeat", seriesName(req.Timeseries[0].Labels))
+
+	s = &Serializer{Log: &testutil.CaptureLogger{}, EmptyBatchPolicy: "error"}
+	require.NoError(t, s.Init())
+	_, err = s.SerializeBatch([]telegraf.Metric{})
+	require.ErrorContains(t, err, "batch contains no series")
+}
+
+func TestRemoteWriteSerializeEmptyBatchPolicyVariants(t *testing.T) {
+	s := &Serializer{Log: &testutil.CaptureLogger{}, EmptyBatchPolicy: "error"}
+	require.NoError(t, s.Init())
+	_, _, err := s.SerializeBatchWithSize(nil)
+	require.ErrorContains(t, err, "batch contains no series")
+	_, err = s.SerializeBatchChunked(nil)
+	require.ErrorContains(t, err, "batch contains no series")
+
+	s = &Serializer{Log: &testutil.CaptureLogger{}, EmptyBatchPolicy: "nil"}
+	require.NoError(t, s.Init())
+	data, size, err := s.SerializeBatchWithSize(nil)
+	require.NoError(t, err)
+	require.Nil(t, data)
+	require.Zero(t, size)
+	chunks, err := s.SerializeBatchChunked(nil)
+	require.NoError(t, err)
+	require.Empty(t, chunks)
+
+	s = &Serializer{Log: &testutil.CaptureLogger{}, EmptyBatchPolicy: "heartbeat", MaxPayloadBytes: 1024}
+	require.NoError(t, s.Init())
+	chunks, err = s.SerializeBatchChunked(nil)
+	require.NoError(t, err)
+	require.Len(t, chunks, 1)
+	req, err := DecodePayload(chunks[0])
+	require.NoError(t, err)
+	require.Len(t, req.Timeseries, 1)
+	require.Equal(t, "telegraf_serializer_heartbeat", seriesName(req.Timeseries[0].Labels))
+}
+
+func TestRemoteWriteSerializeMaxSeriesPerName(t *testing.T) {
+	metrics := make([]telegraf.Metric, 0, 4)
+	for i := range 3 {
+		metrics = append(metrics, testutil.MustMetric(
+			"cpu",
+			map[string]string{"cpu": fmt.Sprintf("cpu%d", i)},
+			map[string]interface{}{"time_idle": 42.0},
+			time.Unix(0, 0),
+		))
+	}
+	metrics = append(metrics, testutil.MustMetric(
+		"mem",
+		map[string]string{},
+		map[string]interface{}{"free": 23.0},
+		time.Unix(0, 0),
+	))
+
+	t.Run("drop", func(t *testing.T) {
+		clog := &testutil.CaptureLogger{}
+		s := &Serializer{
+			Log:              clog,
+			SortMetrics:      true,
+			MaxSeriesPerName: 2,
+		}
+		require.NoError(t, s.Init())
+
+		data, err := s.SerializeBatch(metrics)
+		require.NoError(t, err)
+		actual, err := prompbToText(data)
+		require.NoError(t, err)
+
+		expected := `
+mem_free 23
+cpu_time_idle{cpu="cpu0"} 42
+cpu_time_idle{cpu="cpu1"} 42
+`
+		require.Equal(t, strings.TrimSpace(expected), strings.TrimSpace(string(actual)))
+
+		warnings := clog.Warnings()
+		require.Len(t, warnings, 1)
+		require.Contains(t, warnings[0], `dropped 1 series of metric name "cpu_time_idle"`)
+	})
+
+	t.Run("error", func(t *testing.T) {
+		s := &Serializer{
+			Log:                    &testutil.CaptureLogger{},
+			MaxSeriesPerName:       2,
+			MaxSeriesPerNameAction: "error",
 		}
+		require.NoError(t, s.Init())
+
+		_, err := s.SerializeBatch(metrics)
+		require.ErrorContains(t, err, `metric name "cpu_time_idle" has 3 series exceeding the limit of 2`)
+	})
+}
+
+func TestRemoteWriteSerializeInvalidFieldName(t *testing.T) {
+	m := testutil.MustMetric(
+		"cpu",
+		map[string]string{},
+		map[string]interface{}{
+			"@@@":       23.0,
+			"time_idle": 42.0,
+		},
+		time.Unix(0, 0),
+	)
+
+	tests := []struct {
+		name        string
+		policy      string
+		placeholder string
+		expected    string
+		warning     string
+	}{
+		{
+			name:     "default",
+			expected: "cpu 23\ncpu_time_idle 42",
+		},
+		{
+			name:     "sanitize",
+			policy:   "sanitize",
+			expected: "cpu 23\ncpu_time_idle 42",
+		},
+		{
+			name:     "drop",
+			policy:   "drop",
+			expected: "cpu_time_idle 42",
+			warning:  `failed to parse "cpu_@@@": field name "@@@" is invalid`,
+		},
+		{
+			name:     "placeholder",
+			policy:   "placeholder",
+			expected: "cpu_invalid_field 23\ncpu_time_idle 42",
+			warning:  `replaced invalid field names ["@@@"] by placeholder "invalid_field"`,
+		},
+		{
+			name:        "custom placeholder",
+			policy:      "placeholder",
+			placeholder: "unknown",
+			expected:    "cpu_time_idle 42\ncpu_unknown 23",
+			warning:     `replaced invalid field names ["@@@"] by placeholder "unknown"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clog := &testutil.CaptureLogger{}
+			s := &Serializer{
+				Log:                         clog,
+				SortMetrics:                 true,
+				InvalidFieldNamePolicy:      tt.policy,
+				InvalidFieldNamePlaceholder: tt.placeholder,
+			}
+			require.NoError(t, s.Init())
+
+			data, err := s.Serialize(m)
+			require.NoError(t, err)
+			actual, err := prompbToText(data)
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, strings.TrimSpace(string(actual)))
+
+			warnings := clog.Warnings()
+			if tt.warning == "" {
+				require.Empty(t, warnings)
+				return
+			}
+			require.Len(t, warnings, 1)
+			require.Contains(t, warnings[0], tt.warning)
+		})
+	}
+}
+
+func TestRemoteWriteSerializeLeadingDigitPrefix(t *testing.T) {
+	tests := []struct {
+		name        string
+		prefix      string
+		measurement string
+		expected    string
+	}{
+		{name: "default", measurement: "5xx", expected: "xx_errors"},
+		{name: "underscore", prefix: "_", measurement: "5xx", expected: "_5xx_errors"},
+		{name: "custom", prefix: "http_", measurement: "5xx", expected: "http_5xx_errors"},
+		{name: "invalid characters", prefix: "_", measurement: "5xx-http", expected: "_5xx_http_errors"},
+		{name: "no leading digit", prefix: "_", measurement: "http", expected: "http_errors"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Serializer{
+				Log:                &testutil.CaptureLogger{},
+				LeadingDigitPrefix: tt.prefix,
+			}
+			require.NoError(t, s.Init())
+
+			metrics := []telegraf.Metric{
+				testutil.MustMetric(
+					tt.measurement,
+					map[string]string{},
+					map[string]interface{}{"errors": 3.0},
+					time.Unix(0, 0),
+				),
+			}
+			data, err := s.SerializeBatch(metrics)
+			require.NoError(t, err)
+			req, err := DecodePayload(data)
+			require.NoError(t, err)
+			require.Len(t, req.Timeseries, 1)
+
+			name := seriesName(req.Timeseries[0].Labels)
+			require.Equal(t, tt.expected, name)
+			require.True(t, model.IsValidLegacyMetricName(name))
+		})
+	}
+}
+
+func TestRemoteWriteSerializeHistogramExemplar(t *testing.T) {
+	metrics := []telegraf.Metric{
+		testutil.MustMetric(
+			"prometheus",
+			map[string]string{"le": "0.5"},
+			map[string]interface{}{
+				"http_request_duration_seconds_bucket": 129389.0,
+				"exemplar":                             0.42,
+				"trace_id":                             "4bf92f3577b34da6a3ce929d0e0e4736",
+				"span_id":                              "00f067aa0ba902b7",
+			},
+			time.Unix(10, 0),
+			telegraf.Histogram,
+		),
+		testutil.MustMetric(
+			"prometheus",
+			map[string]string{"le": "+Inf"},
+			map[string]interface{}{
+				"http_request_duration_seconds_bucket": 144320.0,
+			},
+			time.Unix(10, 0),
+			telegraf.Histogram,
+		),
+	}
+
+	s := &Serializer{
+		Log:           &testutil.CaptureLogger{},
+		SortMetrics:   true,
+		StringAsLabel: true,
+		ExemplarField: "exemplar",
+	}
+	require.NoError(t, s.Init())
+
+	data, err := s.SerializeBatch(metrics)
+	require.NoError(t, err)
+	req, err := DecodePayload(data)
+	require.NoError(t, err)
+
+	expected := `
+http_request_duration_seconds_count 0
+http_request_duration_seconds_sum 0
+http_request_duration_seconds_bucket{le="+Inf"} 144320
+http_request_duration_seconds_bucket{le="0.5"} 129389
+`
+	require.Equal(t, strings.TrimSpace(expected), strings.TrimSpace(RenderText(req)))
+
+	for _, ts := range req.Timeseries {
+		if le, _ := labelValue(ts.Labels, "le"); le != "0.5" {
+			require.Empty(t, ts.Exemplars)
+			continue
+		}
+		require.Equal(t, []prompb.Exemplar{
+			{
+				Labels: []prompb.Label{
+					{Name: "trace_id", Value: "4bf92f3577b34da6a3ce929d0e0e4736"},
+					{Name: "span_id", Value: "00f067aa0ba902b7"},
+				},
+				Value:     0.42,
+				Timestamp: 10000,
+			},
+		}, ts.Exemplars)
+	}
+}
+
+func TestRemoteWriteSerializeMaxExemplarsPerSeries(t *testing.T) {
+	var metrics []telegraf.Metric
+	for i := range 5 {
+		metrics = append(metrics, testutil.MustMetric(
+			"prometheus",
+			map[string]string{"le": "0.5"},
+			map[string]interface{}{
+				"http_request_duration_seconds_bucket": 100.0 + float64(i),
+				"exemplar":                             float64(i) / 10,
+				"trace_id":                             fmt.Sprintf("trace-%d", i),
+			},
+			time.Unix(int64(10+i), 0),
+			telegraf.Histogram,
+		))
+	}
+	// Shuffle the metrics to make sure the newest exemplars are kept
+	// independent of the order
+	metrics[0], metrics[4] = metrics[4], metrics[0]
+	metrics[1], metrics[2] = metrics[2], metrics[1]
+
+	tests := []struct {
+		name     string
+		limit    *int
+		expected []string
+	}{
+		{
+			name:     "default",
+			expected: []string{"trace-4"},
+		},
+		{
+			name:     "limited",
+			limit:    func() *int { v := 3; return &v }(),
+			expected: []string{"trace-2", "trace-3", "trace-4"},
+		},
+		{
+			name:  "disabled",
+			limit: func() *int { v := 0; return &v }(),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Serializer{
+				Log:                   &testutil.CaptureLogger{},
+				ExemplarField:         "exemplar",
+				ExemplarLabelFields:   []string{"trace_id"},
+				MaxExemplarsPerSeries: tt.limit,
+			}
+			require.NoError(t, s.Init())
+
+			data, err := s.SerializeBatch(metrics)
+			require.NoError(t, err)
+			req, err := DecodePayload(data)
+			require.NoError(t, err)
+
+			var actual []string
+			for _, ts := range req.Timeseries {
+				if le, _ := labelValue(ts.Labels, "le"); le != "0.5" {
+					require.Empty(t, ts.Exemplars)
+					continue
+				}
+				require.Equal(t, 104.0, ts.Samples[0].Value)
+				for _, exemplar := range ts.Exemplars {
+					actual = append(actual, exemplar.Labels[0].Value)
+				}
+			}
+			require.Equal(t, tt.expected, actual)
+		})
+	}
+}
+
+func TestRemoteWriteSerializeSummaryToHistogram(t *testing.T) {
+	metrics := []telegraf.Metric{
+		testutil.MustMetric(
+			"prometheus",
+			map[string]string{},
+			map[string]interface{}{
+				"rpc_duration_seconds_sum":   1.7560473e+07,
+				"rpc_duration_seconds_count": 2693,
+			},
+			time.Unix(0, 0),
+			telegraf.Summary,
+		),
+		testutil.MustMetric(
+			"prometheus",
+			map[string]string{"quantile": "0.5"},
+			map[string]interface{}{
+				"rpc_duration_seconds": 4773.0,
+			},
+			time.Unix(0, 0),
+			telegraf.Summary,
+		),
+		testutil.MustMetric(
+			"prometheus",
+			map[string]string{"quantile": "0.9"},
+			map[string]interface{}{
+				"rpc_duration_seconds": 9001.0,
+			},
+			time.Unix(0, 0),
+			telegraf.Summary,
+		),
+		testutil.MustMetric(
+			"prometheus",
+			map[string]string{"quantile": "0.99"},
+			map[string]interface{}{
+				"rpc_duration_seconds": 76656.0,
+			},
+			time.Unix(0, 0),
+			telegraf.Summary,
+		),
+	}
+
+	s := &Serializer{
+		Log:                &testutil.CaptureLogger{},
+		SortMetrics:        true,
+		SummaryToHistogram: true,
+	}
+	data, err := s.SerializeBatch(metrics)
+	require.NoError(t, err)
+	actual, err := prompbToText(data)
+	require.NoError(t, err)
+
+	expected := `
+rpc_duration_seconds_count 2693
+rpc_duration_seconds_sum 17560473
+rpc_duration_seconds_bucket{le="+Inf"} 2693
+rpc_duration_seconds_bucket{le="4773"} 1347
+rpc_duration_seconds_bucket{le="76656"} 2666
+rpc_duration_seconds_bucket{le="9001"} 2424
+`
+	require.Equal(t, strings.TrimSpace(expected), strings.TrimSpace(string(actual)))
+}
+
+func TestRemoteWriteSerializeSummaryAggregateNames(t *testing.T) {
+	metrics := []telegraf.Metric{
+		testutil.MustMetric(
+			"prometheus",
+			map[string]string{"quantile": "0.5"},
+			map[string]interface{}{"rpc_duration_seconds": 3.0},
+			time.Unix(0, 0),
+			telegraf.Summary,
+		),
+		testutil.MustMetric(
+			"prometheus",
+			map[string]string{},
+			map[string]interface{}{
+				"rpc_duration_seconds_sum":   30.0,
+				"rpc_duration_seconds_count": 10.0,
+			},
+			time.Unix(0, 0),
+			telegraf.Summary,
+		),
+	}
+
+	tests := []struct {
+		name       string
+		convert    bool
+		metricType prompb.MetricMetadata_MetricType
+		expected   []string
+	}{
+		{
+			name:       "summary",
+			metricType: prompb.MetricMetadata_SUMMARY,
+			expected: []string{
+				"rpc_duration_seconds_count",
+				"rpc_duration_seconds_sum",
+				"rpc_duration_seconds",
+			},
+		},
+		{
+			name:       "converted to histogram",
+			convert:    true,
+			metricType: prompb.MetricMetadata_HISTOGRAM,
+			expected: []string{
+				"rpc_duration_seconds_count",
+				"rpc_duration_seconds_sum",
+				"rpc_duration_seconds_bucket",
+				"rpc_duration_seconds_bucket",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clog := &testutil.CaptureLogger{}
+			s := &Serializer{
+				Log:                clog,
+				SortMetrics:        true,
+				WriteMetadata:      true,
+				SummaryToHistogram: tt.convert,
+			}
+			require.NoError(t, s.Init())
+
+			series, _, err := s.assemble(metrics)
+			require.NoError(t, err)
+
+			names := make([]string, 0, len(series))
+			for _, ts := range series {
+				names = append(names, seriesName(ts.Labels))
+				require.Equal(t, "rpc_duration_seconds", ts.metadata.MetricFamilyName)
+				require.Equal(t, tt.metricType, ts.metadata.Type)
+			}
+			require.Equal(t, tt.expected, names)
+			require.Empty(t, clog.Warnings())
+		})
+	}
+}
+
+func TestRemoteWriteSerializeSummaryToHistogramWithoutCount(t *testing.T) {
+	m := testutil.MustMetric(
+		"prometheus",
+		map[string]string{"quantile": "0.5"},
+		map[string]interface{}{
+			"rpc_duration_seconds": 4773.0,
+		},
+		time.Unix(0, 0),
+		telegraf.Summary,
+	)
+
+	clog := &testutil.CaptureLogger{}
+	s := &Serializer{
+		Log:                clog,
+		SummaryToHistogram: true,
+	}
+	data, err := s.Serialize(m)
+	require.NoError(t, err)
+	actual, err := prompbToText(data)
+	require.NoError(t, err)
+	require.Empty(t, actual)
+
+	warnings := clog.Warnings()
+	require.Len(t, warnings, 1)
+	require.Contains(t, warnings[0], `failed to convert "rpc_duration_seconds_bucket": summary has no count`)
+}
+
+func TestRemoteWriteSerializeZeroTimestamp(t *testing.T) {
+	metrics := []telegraf.Metric{
+		testutil.MustMetric(
+			"cpu",
+			map[string]string{"cpu": "cpu0"},
+			map[string]interface{}{"time_idle": 42.0},
+			time.Unix(0, 0),
+		),
+		testutil.MustMetric(
+			"cpu",
+			map[string]string{"cpu": "cpu1"},
+			map[string]interface{}{"time_idle": 23.0},
+			time.Unix(1574279268, 0),
+		),
+	}
+
+	t.Run("drop", func(t *testing.T) {
+		clog := &testutil.CaptureLogger{}
+		s := &Serializer{
+			Log:                 clog,
+			RejectZeroTimestamp: true,
+		}
+		require.NoError(t, s.Init())
+
+		data, err := s.SerializeBatch(metrics)
+		require.NoError(t, err)
+		req, err := DecodePayload(data)
+		require.NoError(t, err)
+		require.Equal(t, `cpu_time_idle{cpu="cpu1"} 23`, strings.TrimSpace(RenderText(req)))
+		require.Equal(t, int64(1574279268000), req.Timeseries[0].Samples[0].Timestamp)
+
+		warnings := clog.Warnings()
+		require.Len(t, warnings, 1)
+		require.Contains(t, warnings[0], `metric "cpu" has zero timestamp`)
+	})
+
+	t.Run("now", func(t *testing.T) {
+		clog := &testutil.CaptureLogger{}
+		s := &Serializer{
+			Log:                 clog,
+			SortMetrics:         true,
+			RejectZeroTimestamp: true,
+			ZeroTimestampAction: "now",
+		}
+		require.NoError(t, s.Init())
+
+		before := time.Now().UnixMilli()
+		data, err := s.SerializeBatch(metrics)
+		require.NoError(t, err)
+		req, err := DecodePayload(data)
+		require.NoError(t, err)
+
+		expected := `
+cpu_time_idle{cpu="cpu0"} 42
+cpu_time_idle{cpu="cpu1"} 23
+`
+		require.Equal(t, strings.TrimSpace(expected), strings.TrimSpace(RenderText(req)))
+		require.GreaterOrEqual(t, req.Timeseries[0].Samples[0].Timestamp, before)
+		require.Equal(t, int64(1574279268000), req.Timeseries[1].Samples[0].Timestamp)
+
+		warnings := clog.Warnings()
+		require.Len(t, warnings, 1)
+		require.Contains(t, warnings[0], "replaced zero timestamp of 1 metrics by the current time")
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		s := &Serializer{Log: &testutil.CaptureLogger{}}
+		require.NoError(t, s.Init())
+
+		data, err := s.SerializeBatch(metrics)
+		require.NoError(t, err)
+		req, err := DecodePayload(data)
+		require.NoError(t, err)
+		require.Len(t, req.Timeseries, 2)
+	})
+}
+
+func TestRemoteWriteSerializeFutureTimestamp(t *testing.T) {
+	future := time.Now().Add(time.Hour)
+	metrics := []telegraf.Metric{
+		testutil.MustMetric(
+			"cpu",
+			map[string]string{"cpu": "cpu0"},
+			map[string]interface{}{"time_idle": 42.0},
+			future,
+		),
+		testutil.MustMetric(
+			"cpu",
+			map[string]string{"cpu": "cpu1"},
+			map[string]interface{}{"time_idle": 23.0},
+			time.Unix(1574279268, 0),
+		),
+	}
+
+	t.Run("drop", func(t *testing.T) {
+		clog := &testutil.CaptureLogger{}
+		s := &Serializer{
+			Log:           clog,
+			MaxFutureSkew: config.Duration(time.Minute),
+		}
+		require.NoError(t, s.Init())
+
+		data, err := s.SerializeBatch(metrics)
+		require.NoError(t, err)
+		req, err := DecodePayload(data)
+		require.NoError(t, err)
+		require.Equal(t, `cpu_time_idle{cpu="cpu1"} 23`, strings.TrimSpace(RenderText(req)))
+
+		warnings := clog.Warnings()
+		require.Len(t, warnings, 1)
+		require.Contains(t, warnings[0], `metric "cpu" has timestamp`)
+		require.Contains(t, warnings[0], "too far in the future")
+	})
+
+	t.Run("clamp", func(t *testing.T) {
+		clog := &testutil.CaptureLogger{}
+		s := &Serializer{
+			Log:                   clog,
+			SortMetrics:           true,
+			MaxFutureSkew:         config.Duration(time.Minute),
+			FutureTimestampAction: "clamp",
+		}
+		require.NoError(t, s.Init())
+
+		before := time.Now().UnixMilli()
+		data, err := s.SerializeBatch(metrics)
+		require.NoError(t, err)
+		req, err := DecodePayload(data)
+		require.NoError(t, err)
+
+		expected := `
+cpu_time_idle{cpu="cpu0"} 42
+cpu_time_idle{cpu="cpu1"} 23
+`
+		require.Equal(t, strings.TrimSpace(expected), strings.TrimSpace(RenderText(req)))
+		require.GreaterOrEqual(t, req.Timeseries[0].Samples[0].Timestamp, before)
+		require.Less(t, req.Timeseries[0].Samples[0].Timestamp, future.UnixMilli())
+		require.Equal(t, int64(1574279268000), req.Timeseries[1].Samples[0].Timestamp)
+
+		warnings := clog.Warnings()
+		require.Len(t, warnings, 1)
+		require.Contains(t, warnings[0], "clamped future timestamp of 1 metrics to the current time")
+	})
+
+	t.Run("within skew", func(t *testing.T) {
+		s := &Serializer{
+			Log:           &testutil.CaptureLogger{},
+			MaxFutureSkew: config.Duration(2 * time.Hour),
+		}
+		require.NoError(t, s.Init())
+
+		data, err := s.SerializeBatch(metrics)
+		require.NoError(t, err)
+		req, err := DecodePayload(data)
+		require.NoError(t, err)
+		require.Len(t, req.Timeseries, 2)
+	})
+}
+
+func TestRemoteWriteSerializeTimestampResolution(t *testing.T) {
+	metrics := []telegraf.Metric{
+		testutil.MustMetric(
+			"cpu",
+			map[string]string{},
+			map[string]interface{}{"time_idle": 1.0},
+			time.Unix(10, 200*int64(time.Millisecond)),
+		),
+		testutil.MustMetric(
+			"cpu",
+			map[string]string{},
+			map[string]interface{}{"time_idle": 2.0},
+			time.Unix(10, 700*int64(time.Millisecond)),
+		),
+		testutil.MustMetric(
+			"mem",
+			map[string]string{},
+			map[string]interface{}{"free": 1024.0},
+			time.Unix(12, 999*int64(time.Millisecond)),
+		),
+	}
+
+	tests := []struct {
+		resolution string
+		expected   map[string]prompb.Sample
+	}{
+		{
+			resolution: "ms",
+			expected: map[string]prompb.Sample{
+				"cpu_time_idle": {Value: 2, Timestamp: 10700},
+				"mem_free":      {Value: 1024, Timestamp: 12999},
+			},
+		},
+		{
+			resolution: "s",
+			expected: map[string]prompb.Sample{
+				"cpu_time_idle": {Value: 2, Timestamp: 10000},
+				"mem_free":      {Value: 1024, Timestamp: 12000},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.resolution, func(t *testing.T) {
+			s := &Serializer{
+				Log:                 &testutil.CaptureLogger{},
+				TimestampResolution: tt.resolution,
+			}
+			require.NoError(t, s.Init())
+
+			data, err := s.SerializeBatch(metrics)
+			require.NoError(t, err)
+			req, err := DecodePayload(data)
+			require.NoError(t, err)
+
+			actual := make(map[string]prompb.Sample, len(req.Timeseries))
+			for _, ts := range req.Timeseries {
+				require.Len(t, ts.Samples, 1)
+				actual[seriesName(ts.Labels)] = ts.Samples[0]
+			}
+			require.Equal(t, tt.expected, actual)
+		})
+	}
+}
+
+func TestRemoteWriteSerializeTimestampResolutionDedup(t *testing.T) {
+	s := &Serializer{
+		Log:                 &testutil.CaptureLogger{},
+		DedupScope:          "serializer",
+		TimestampResolution: "s",
+	}
+	require.NoError(t, s.Init())
+
+	serialize := func(value float64, ts time.Time) []prompb.TimeSeries {
+		data, err := s.SerializeBatch([]telegraf.Metric{
+			testutil.MustMetric("cpu", map[string]string{}, map[string]interface{}{"time_idle": value}, ts),
+		})
+		require.NoError(t, err)
+		req, err := DecodePayload(data)
+		require.NoError(t, err)
+		return req.Timeseries
+	}
+
+	series := serialize(1, time.Unix(10, 700*int64(time.Millisecond)))
+	require.Len(t, series, 1)
+	require.Equal(t, int64(10000), series[0].Samples[0].Timestamp)
+
+	// Within the same second the truncated timestamps are equal and the
+	// sample passes as resend although the original timestamp is older.
+	series = serialize(2, time.Unix(10, 300*int64(time.Millisecond)))
+	require.Len(t, series, 1)
+	require.Equal(t, prompb.Sample{Value: 2, Timestamp: 10000}, series[0].Samples[0])
+
+	// Samples of the previous second are older after truncation
+	series = serialize(3, time.Unix(9, 900*int64(time.Millisecond)))
+	require.Empty(t, series)
+}
+
+func TestRemoteWriteTruncateMillis(t *testing.T) {
+	require.Equal(t, int64(10000), truncateMillis(10999))
+	require.Equal(t, int64(10000), truncateMillis(10000))
+	require.Equal(t, int64(0), truncateMillis(999))
+	require.Equal(t, int64(-1000), truncateMillis(-1))
+}
+
+func TestRemoteWriteSerializeMaxMetricNameLength(t *testing.T) {
+	prefix := strings.Repeat("a", 40)
+	m := testutil.MustMetric(
+		"cpu",
+		map[string]string{},
+		map[string]interface{}{
+			prefix + "_x": 1.0,
+			prefix + "_y": 2.0,
+			"short":       3.0,
+		},
+		time.Unix(0, 0),
+	)
+
+	t.Run("truncate", func(t *testing.T) {
+		clog := &testutil.CaptureLogger{}
+		s := &Serializer{
+			Log:                 clog,
+			SortMetrics:         true,
+			MaxMetricNameLength: 32,
+		}
+		require.NoError(t, s.Init())
+
+		data, err := s.Serialize(testutil.MustMetric(
+			"cpu",
+			map[string]string{},
+			map[string]interface{}{
+				prefix + "_x": 1.0,
+				"short":       3.0,
+			},
+			time.Unix(0, 0),
+		))
+		require.NoError(t, err)
+		actual, err := prompbToText(data)
+		require.NoError(t, err)
+
+		expected := "cpu_" + strings.Repeat("a", 28) + " 1\ncpu_short 3"
+		require.Equal(t, expected, strings.TrimSpace(string(actual)))
+
+		warnings := clog.Warnings()
+		require.Len(t, warnings, 1)
+		require.Contains(t, warnings[0], "shortened 1 metric names exceeding 32 characters")
+	})
+
+	t.Run("hash", func(t *testing.T) {
+		s := &Serializer{
+			Log:                    &testutil.CaptureLogger{},
+			SortMetrics:            true,
+			MaxMetricNameLength:    32,
+			MetricNameLengthAction: "hash",
+		}
+		require.NoError(t, s.Init())
+
+		data, err := s.Serialize(m)
+		require.NoError(t, err)
+		req, err := DecodePayload(data)
+		require.NoError(t, err)
+		require.Len(t, req.Timeseries, 3)
+
+		names := make(map[string]bool, len(req.Timeseries))
+		for _, ts := range req.Timeseries {
+			name := seriesName(ts.Labels)
+			require.LessOrEqual(t, len(name), 32)
+			names[name] = true
+		}
+		require.Len(t, names, 3)
+		require.Contains(t, names, "cpu_short")
+
+		// The hash must be stable across calls
+		again, err := s.Serialize(m)
+		require.NoError(t, err)
+		require.Equal(t, data, again)
+	})
+
+	t.Run("histogram suffix", func(t *testing.T) {
+		h := testutil.MustMetric(
+			"prometheus",
+			map[string]string{"le": "0.5"},
+			map[string]interface{}{prefix + "_bucket": 1.0},
+			time.Unix(0, 0),
+			telegraf.Histogram,
+		)
+		s := &Serializer{
+			Log:                    &testutil.CaptureLogger{},
+			MaxMetricNameLength:    32,
+			MetricNameLengthAction: "hash",
+		}
+		require.NoError(t, s.Init())
+
+		data, err := s.Serialize(h)
+		require.NoError(t, err)
+		req, err := DecodePayload(data)
+		require.NoError(t, err)
+		require.Len(t, req.Timeseries, 4)
+		for _, ts := range req.Timeseries {
+			require.LessOrEqual(t, len(seriesName(ts.Labels)), 32)
+		}
+	})
+}
+
+func TestRemoteWriteSerializeOriginalFieldLabel(t *testing.T) {
+	metrics := []telegraf.Metric{
+		testutil.MustMetric(
+			"cpu",
+			map[string]string{"host": "example.org"},
+			map[string]interface{}{
+				"time-idle":  42.0,
+				"time.guest": 23.0,
+			},
+			time.Unix(0, 0),
+		),
+		testutil.MustMetric(
+			"prometheus",
+			map[string]string{"le": "0.5"},
+			map[string]interface{}{
+				"http_request_duration_seconds_bucket": 129389.0,
+			},
+			time.Unix(0, 0),
+			telegraf.Histogram,
+		),
+	}
+
+	s := &Serializer{
+		Log:                &testutil.CaptureLogger{},
+		SortMetrics:        true,
+		OriginalFieldLabel: "field",
+	}
+	require.NoError(t, s.Init())
+
+	data, err := s.SerializeBatch(metrics)
+	require.NoError(t, err)
+	actual, err := prompbToText(data)
+	require.NoError(t, err)
+
+	expected := `
+http_request_duration_seconds_count{field="http_request_duration_seconds"} 0
+http_request_duration_seconds_sum{field="http_request_duration_seconds"} 0
+cpu_time_guest{field="time.guest", host="example.org"} 23
+cpu_time_idle{field="time-idle", host="example.org"} 42
+http_request_duration_seconds_bucket{field="http_request_duration_seconds", le="+Inf"} 0
+http_request_duration_seconds_bucket{field="http_request_duration_seconds", le="0.5"} 129389
+`
+	require.Equal(t, strings.TrimSpace(expected), strings.TrimSpace(string(actual)))
+}
+
+func TestRemoteWriteSerializeFieldAsLabel(t *testing.T) {
+	metrics := []telegraf.Metric{
+		testutil.MustMetric(
+			"cpu",
+			map[string]string{"host": "a"},
+			map[string]interface{}{"time_idle": 42.0, "time_user": 7.0},
+			time.Unix(0, 0),
+		),
+		testutil.MustMetric(
+			"net",
+			map[string]string{"field": "tag"},
+			map[string]interface{}{"bytes_recv": 1024.0},
+			time.Unix(0, 0),
+			telegraf.Counter,
+		),
+		testutil.MustMetric(
+			"prometheus",
+			map[string]string{"le": "+Inf"},
+			map[string]interface{}{"http_request_duration_seconds_bucket": 10.0},
+			time.Unix(0, 0),
+			telegraf.Histogram,
+		),
+	}
+
+	s := &Serializer{
+		Log:           &testutil.CaptureLogger{},
+		SortMetrics:   true,
+		WriteMetadata: true,
+		FieldAsLabel:  true,
+	}
+	require.NoError(t, s.Init())
+
+	data, err := s.SerializeBatch(metrics)
+	require.NoError(t, err)
+	req, err := DecodePayload(data)
+	require.NoError(t, err)
+
+	expected := `
+http_request_duration_seconds_count 0
+http_request_duration_seconds_sum 0
+http_request_duration_seconds_bucket{le="+Inf"} 10
+net{field="bytes_recv"} 1024
+cpu{field="time_idle", host="a"} 42
+cpu{field="time_user", host="a"} 7
+`
+	require.Equal(t, strings.TrimSpace(expected), strings.TrimSpace(RenderText(req)))
+
+	families := make(map[string]prompb.MetricMetadata_MetricType, len(req.Metadata))
+	for _, m := range req.Metadata {
+		families[m.MetricFamilyName] = m.Type
+	}
+	require.Equal(t, map[string]prompb.MetricMetadata_MetricType{
+		"cpu":                           prompb.MetricMetadata_UNKNOWN,
+		"http_request_duration_seconds": prompb.MetricMetadata_HISTOGRAM,
+		"net":                           prompb.MetricMetadata_COUNTER,
+	}, families)
+}
+
+func TestRemoteWriteSerializeDuplicateBucket(t *testing.T) {
+	metrics := []telegraf.Metric{
+		testutil.MustMetric(
+			"prometheus",
+			map[string]string{"le": "+Inf"},
+			map[string]interface{}{"http_request_duration_seconds_bucket": 144320.0},
+			time.Unix(0, 0),
+			telegraf.Histogram,
+		),
+		testutil.MustMetric(
+			"prometheus",
+			map[string]string{"le": "Inf"},
+			map[string]interface{}{"http_request_duration_seconds_bucket": 144322.0},
+			time.Unix(0, 0),
+			telegraf.Histogram,
+		),
+		testutil.MustMetric(
+			"prometheus",
+			map[string]string{"le": "+inf"},
+			map[string]interface{}{"http_request_duration_seconds_bucket": 144321.0},
+			time.Unix(0, 0),
+			telegraf.Histogram,
+		),
+	}
+
+	tests := []struct {
+		policy   string
+		expected float64
+	}{
+		{policy: "max", expected: 144322},
+		{policy: "first", expected: 144320},
+		{policy: "last", expected: 144321},
+	}
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			clog := &testutil.CaptureLogger{}
+			s := &Serializer{
+				Log:                   clog,
+				SortMetrics:           true,
+				DuplicateBucketPolicy: tt.policy,
+			}
+			require.NoError(t, s.Init())
+
+			data, err := s.SerializeBatch(metrics)
+			require.NoError(t, err)
+			actual, err := prompbToText(data)
+			require.NoError(t, err)
+
+			expected := fmt.Sprintf(`
+http_request_duration_seconds_count 0
+http_request_duration_seconds_sum 0
+http_request_duration_seconds_bucket{le="+Inf"} %v
+`, tt.expected)
+			require.Equal(t, strings.TrimSpace(expected), strings.TrimSpace(string(actual)))
+
+			warnings := clog.Warnings()
+			require.Len(t, warnings, 1)
+			require.Contains(t, warnings[0], `resolved duplicate histogram buckets [http_request_duration_seconds_bucket{le="+Inf"}]`)
+		})
+	}
+}
+
+func TestRemoteWriteSerializeDuplicateBucketSameCount(t *testing.T) {
+	metrics := []telegraf.Metric{
+		testutil.MustMetric(
+			"prometheus",
+			map[string]string{"le": "0.5"},
+			map[string]interface{}{"http_request_duration_seconds_bucket": 24054.0},
+			time.Unix(0, 0),
+			telegraf.Histogram,
+		),
+		testutil.MustMetric(
+			"prometheus",
+			map[string]string{"le": "0.50"},
+			map[string]interface{}{"http_request_duration_seconds_bucket": 24054.0},
+			time.Unix(0, 0),
+			telegraf.Histogram,
+		),
+	}
+
+	for _, policy := range []string{"max", "first", "last"} {
+		t.Run(policy, func(t *testing.T) {
+			clog := &testutil.CaptureLogger{}
+			s := &Serializer{
+				Log:                   clog,
+				SortMetrics:           true,
+				DuplicateBucketPolicy: policy,
+			}
+			require.NoError(t, s.Init())
+
+			data, err := s.SerializeBatch(metrics)
+			require.NoError(t, err)
+			actual, err := prompbToText(data)
+			require.NoError(t, err)
+
+			expected := `
+http_request_duration_seconds_count 0
+http_request_duration_seconds_sum 0
+http_request_duration_seconds_bucket{le="+Inf"} 0
+http_request_duration_seconds_bucket{le="0.5"} 24054
+`
+			require.Equal(t, strings.TrimSpace(expected), strings.TrimSpace(string(actual)))
+			require.Empty(t, clog.Warnings())
+		})
+	}
+}
+
+func TestRemoteWriteSerializeMaxLabelsPerSeries(t *testing.T) {
+	metrics := []telegraf.Metric{
+		testutil.MustMetric(
+			"cpu",
+			map[string]string{"host": "example.org", "cpu": "cpu0", "zone": "eu", "arch": "amd64"},
+			map[string]interface{}{"time_idle": 42.0},
+			time.Unix(0, 0),
+		),
+		testutil.MustMetric(
+			"prometheus",
+			map[string]string{"le": "0.5", "host": "example.org", "cpu": "cpu0"},
+			map[string]interface{}{"http_request_duration_seconds_bucket": 129389.0},
+			time.Unix(0, 0),
+			telegraf.Histogram,
+		),
+	}
+
+	clog := &testutil.CaptureLogger{}
+	s := &Serializer{
+		Log:                clog,
+		SortMetrics:        true,
+		MaxLabelsPerSeries: 3,
+	}
+	require.NoError(t, s.Init())
+
+	data, err := s.SerializeBatch(metrics)
+	require.NoError(t, err)
+	actual, err := prompbToText(data)
+	require.NoError(t, err)
+
+	expected := `
+http_request_duration_seconds_count{cpu="cpu0"} 0
+http_request_duration_seconds_sum{cpu="cpu0"} 0
+cpu_time_idle{arch="amd64", cpu="cpu0"} 42
+http_request_duration_seconds_bucket{cpu="cpu0", le="+Inf"} 0
+http_request_duration_seconds_bucket{cpu="cpu0", le="0.5"} 129389
+`
+	require.Equal(t, strings.TrimSpace(expected), strings.TrimSpace(string(actual)))
+
+	warnings := clog.Warnings()
+	require.Len(t, warnings, 1)
+	require.Contains(t, warnings[0], "dropped labels of 2 series exceeding the limit of 3 labels")
+}
+
+func TestRemoteWriteSerializeLowercaseNames(t *testing.T) {
+	metrics := []telegraf.Metric{
+		testutil.MustMetric(
+			"CPU",
+			map[string]string{"Host": "Example.org"},
+			map[string]interface{}{"Time_Idle": 42.0},
+			time.Unix(0, 0),
+		),
+		testutil.MustMetric(
+			"cpu",
+			map[string]string{"Host": "Example.org"},
+			map[string]interface{}{"time_user": 7.0},
+			time.Unix(0, 0),
+		),
+		testutil.MustMetric(
+			"prometheus",
+			map[string]string{},
+			map[string]interface{}{"HTTP_Requests_Total": 3.0},
+			time.Unix(0, 0),
+			telegraf.Counter,
+		),
+	}
+
+	s := &Serializer{
+		Log:            &testutil.CaptureLogger{},
+		SortMetrics:    true,
+		LowercaseNames: true,
+	}
+	require.NoError(t, s.Init())
+
+	data, err := s.SerializeBatch(metrics)
+	require.NoError(t, err)
+	actual, err := prompbToText(data)
+	require.NoError(t, err)
+	expected := `
+http_requests_total 3
+cpu_time_idle{Host="Example.org"} 42
+cpu_time_user{Host="Example.org"} 7
+`
+	require.Equal(t, strings.TrimSpace(expected), strings.TrimSpace(string(actual)))
+}
+
+func TestRemoteWriteSerializeHashExcessLabels(t *testing.T) {
+	metrics := make([]telegraf.Metric, 0, 2)
+	for _, zone := range []string{"eu", "us"} {
+		metrics = append(metrics, testutil.MustMetric(
+			"cpu",
+			map[string]string{"cpu": "cpu0", "host": "example.org", "zone": zone},
+			map[string]interface{}{"time_idle": 42.0},
+			time.Unix(0, 0),
+		))
+	}
+
+	clog := &testutil.CaptureLogger{}
+	s := &Serializer{
+		Log:                clog,
+		SortMetrics:        true,
+		MaxLabelsPerSeries: 3,
+		HashExcessLabels:   true,
+	}
+	require.NoError(t, s.Init())
+
+	data, err := s.SerializeBatch(metrics)
+	require.NoError(t, err)
+	req, err := DecodePayload(data)
+	require.NoError(t, err)
+	require.Len(t, req.Timeseries, 2)
+
+	hashes := make(map[string]bool)
+	for _, ts := range req.Timeseries {
+		require.Len(t, ts.Labels, 3)
+		require.False(t, hasLabel("host", ts.Labels))
+		require.False(t, hasLabel("zone", ts.Labels))
+		cpu, _ := labelValue(ts.Labels, "cpu")
+		require.Equal(t, "cpu0", cpu)
+		hash, found := labelValue(ts.Labels, "__labels_hash__")
+		require.True(t, found)
+		require.Len(t, hash, 16)
+		hashes[hash] = true
+	}
+	require.Len(t, hashes, 2)
+
+	warnings := clog.Warnings()
+	require.Len(t, warnings, 1)
+	require.Contains(t, warnings[0], "hashed excess labels of 2 series exceeding the limit of 3 labels")
+}
+
+func TestRemoteWriteSerializeMaxLabelsPerSeriesGeneratedLabels(t *testing.T) {
+	m := testutil.MustMetric(
+		"cpu",
+		map[string]string{"host": "example.org", "cpu": "cpu0", "zone": "eu"},
+		map[string]interface{}{"time_idle": 42.0},
+		time.Unix(0, 0),
+	)
+
+	s := &Serializer{
+		Log:                &testutil.CaptureLogger{},
+		MaxLabelsPerSeries: 5,
+		EmitSeriesID:       true,
+		EmitProtocolLabel:  true,
+		EmitWriteID:        true,
+	}
+	require.NoError(t, s.Init())
+
+	data, err := s.Serialize(m)
+	require.NoError(t, err)
+	req, err := DecodePayload(data)
+	require.NoError(t, err)
+	require.Len(t, req.Timeseries, 1)
+
+	labels := req.Timeseries[0].Labels
+	require.Len(t, labels, 5)
+	for _, name := range []string{"__name__", "cpu", seriesIDLabel, protocolLabel, writeIDLabel} {
+		require.True(t, hasLabel(name, labels), name)
+	}
+}
+
+func TestRemoteWriteSerializeMaxLabelsPerSeriesError(t *testing.T) {
+	m := testutil.MustMetric(
+		"cpu",
+		map[string]string{"host": "example.org", "cpu": "cpu0", "zone": "eu"},
+		map[string]interface{}{"time_idle": 42.0},
+		time.Unix(0, 0),
+	)
+
+	s := &Serializer{
+		Log:                      &testutil.CaptureLogger{},
+		MaxLabelsPerSeries:       3,
+		MaxLabelsPerSeriesAction: "error",
+	}
+	require.NoError(t, s.Init())
+
+	_, err := s.Serialize(m)
+	require.ErrorContains(t, err, `metric "cpu_time_idle" has 4 labels exceeding the limit of 3`)
+}
+
+func TestRemoteWriteSerializeDedupScopeSerializer(t *testing.T) {
+	s := &Serializer{
+		Log:         &testutil.CaptureLogger{},
+		SortMetrics: true,
+		DedupScope:  "serializer",
+	}
+	require.NoError(t, s.Init())
+
+	first := []telegraf.Metric{
+		testutil.MustMetric(
+			"cpu",
+			map[string]string{"cpu": "cpu0"},
+			map[string]interface{}{"time_idle": 42.0},
+			time.Unix(10, 0),
+		),
+	}
+	data, err := s.SerializeBatch(first)
+	require.NoError(t, err)
+	actual, err := prompbToText(data)
+	require.NoError(t, err)
+	require.Equal(t, `cpu_time_idle{cpu="cpu0"} 42`, strings.TrimSpace(string(actual)))
+
+	// Older samples of known series are suppressed while new series, newer
+	// samples and resends of the same sample pass.
+	second := []telegraf.Metric{
+		testutil.MustMetric(
+			"cpu",
+			map[string]string{"cpu": "cpu0"},
+			map[string]interface{}{"time_idle": 41.0},
+			time.Unix(5, 0),
+		),
+		testutil.MustMetric(
+			"cpu",
+			map[string]string{"cpu": "cpu1"},
+			map[string]interface{}{"time_idle": 40.0},
+			time.Unix(5, 0),
+		),
+	}
+	data, err = s.SerializeBatch(second)
+	require.NoError(t, err)
+	actual, err = prompbToText(data)
+	require.NoError(t, err)
+	require.Equal(t, `cpu_time_idle{cpu="cpu1"} 40`, strings.TrimSpace(string(actual)))
+
+	data, err = s.SerializeBatch(first)
+	require.NoError(t, err)
+	actual, err = prompbToText(data)
+	require.NoError(t, err)
+	require.Equal(t, `cpu_time_idle{cpu="cpu0"} 42`, strings.TrimSpace(string(actual)))
+}
+
+func TestRemoteWriteSerializeDedupScopeBatch(t *testing.T) {
+	s := &Serializer{Log: &testutil.CaptureLogger{}}
+	require.NoError(t, s.Init())
+
+	for _, ts := range []int64{10, 5} {
+		m := testutil.MustMetric(
+			"cpu",
+			map[string]string{},
+			map[string]interface{}{"time_idle": 42.0},
+			time.Unix(ts, 0),
+		)
+		data, err := s.Serialize(m)
+		require.NoError(t, err)
+		actual, err := prompbToText(data)
+		require.NoError(t, err)
+		require.Equal(t, "cpu_time_idle 42", strings.TrimSpace(string(actual)))
+	}
+}
+
+func TestRemoteWriteDedupTTL(t *testing.T) {
+	c := &dedupCache{ttl: time.Minute}
+	series := func() []timeSeries {
+		_, promts := getPromTS("cpu_time_idle", nil, 42.0, time.Unix(10, 0))
+		return []timeSeries{{TimeSeries: promts}}
+	}
+	now := time.Now()
+	timestamps := make(map[MetricKey]int64)
+	kept, dropped := c.filter(series(), now, timestamps)
+	require.Len(t, kept, 1)
+	require.Zero(t, dropped)
+	require.Empty(t, c.seen)
+	c.record(timestamps, now)
+	require.Len(t, c.seen, 1)
+
+	// Evict the series after the TTL expired
+	_, promts := getPromTS("cpu_time_guest", nil, 42.0, time.Unix(10, 0))
+	timestamps = make(map[MetricKey]int64)
+	kept, _ = c.filter([]timeSeries{{TimeSeries: promts}}, now.Add(2*time.Minute), timestamps)
+	require.Len(t, kept, 1)
+	c.record(timestamps, now.Add(2*time.Minute))
+	require.Len(t, c.seen, 1)
+	_, found := c.seen[MakeMetricKey(series()[0].Labels)]
+	require.False(t, found)
+}
+
+func TestRemoteWriteSerializeHistogramBoundaryForms(t *testing.T) {
+	tests := []struct {
+		le       string
+		expected string
+	}{
+		{le: "5", expected: "5"},
+		{le: "5.0", expected: "5"},
+		{le: "5.00", expected: "5"},
+		{le: "5e0", expected: "5"},
+		{le: "0.25", expected: "0.25"},
+		{le: "1000000", expected: "1e+06"},
+		{le: "Inf", expected: "+Inf"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.le, func(t *testing.T) {
+			m := testutil.MustMetric(
+				"prometheus",
+				map[string]string{"le": tt.le},
+				map[string]interface{}{"http_request_duration_seconds_bucket": 42.0},
+				time.Unix(0, 0),
+				telegraf.Histogram,
+			)
+
+			s := &Serializer{Log: &testutil.CaptureLogger{}}
+			require.NoError(t, s.Init())
+
+			data, err := s.Serialize(m)
+			require.NoError(t, err)
+			req, err := DecodePayload(data)
+			require.NoError(t, err)
+
+			var found bool
+			for _, ts := range req.Timeseries {
+				if le, ok := labelValue(ts.Labels, "le"); ok && ts.Samples[0].Value == 42 {
+					require.Equal(t, tt.expected, le)
+					found = true
+				}
+			}
+			require.True(t, found, "bucket not found")
+		})
+	}
+}
+
+func TestRemoteWriteSerializeBatchSharded(t *testing.T) {
+	var metrics []telegraf.Metric
+	for _, host := range []string{"a", "b", "c", "d", "e", "f", "g", "h"} {
+		metrics = append(metrics, testutil.MustMetric(
+			"cpu",
+			map[string]string{"host": host},
+			map[string]interface{}{"time_idle": 42.0, "time_guest": 1.0},
+			time.Unix(0, 0),
+		))
+	}
+
+	s := &Serializer{
+		Log:        &testutil.CaptureLogger{},
+		ShardLabel: "host",
+		ShardCount: 3,
+	}
+	require.NoError(t, s.Init())
+
+	shards, err := s.SerializeBatchSharded(metrics)
+	require.NoError(t, err)
+	require.NotEmpty(t, shards)
+
+	hostShard := make(map[string]int)
+	var total int
+	for shard, data := range shards {
+		require.GreaterOrEqual(t, shard, 0)
+		require.Less(t, shard, 3)
+
+		req, err := DecodePayload(data)
+		require.NoError(t, err)
+		for _, ts := range req.Timeseries {
+			host, ok := labelValue(ts.Labels, "host")
+			require.True(t, ok)
+			if expected, found := hostShard[host]; found {
+				require.Equal(t, expected, shard, "series of host %q in different shards", host)
+			}
+			hostShard[host] = shard
+			total++
+		}
+	}
+	require.Equal(t, 16, total)
+	require.Len(t, hostShard, 8)
+}
+
+func TestRemoteWriteSerializeBatchByType(t *testing.T) {
+	metrics := []telegraf.Metric{
+		testutil.MustMetric(
+			"http",
+			map[string]string{"code": "200"},
+			map[string]interface{}{"requests_total": 1027.0},
+			time.Unix(0, 0),
+			telegraf.Counter,
+		),
+		testutil.MustMetric(
+			"http",
+			map[string]string{"code": "400"},
+			map[string]interface{}{"requests_total": 3.0},
+			time.Unix(0, 0),
+			telegraf.Counter,
+		),
+		testutil.MustMetric(
+			"mem",
+			map[string]string{},
+			map[string]interface{}{"free": 1024.0},
+			time.Unix(0, 0),
+			telegraf.Gauge,
+		),
+		testutil.MustMetric(
+			"prometheus",
+			map[string]string{"le": "+Inf"},
+			map[string]interface{}{"http_request_duration_seconds_bucket": 10.0},
+			time.Unix(0, 0),
+			telegraf.Histogram,
+		),
+		testutil.MustMetric(
+			"prometheus",
+			map[string]string{},
+			map[string]interface{}{
+				"http_request_duration_seconds_sum":   3.0,
+				"http_request_duration_seconds_count": 10.0,
+			},
+			time.Unix(0, 0),
+			telegraf.Histogram,
+		),
+		testutil.MustMetric(
+			"cpu",
+			map[string]string{},
+			map[string]interface{}{"time_idle": 42.0},
+			time.Unix(0, 0),
+		),
+	}
+
+	s := &Serializer{
+		Log:           &testutil.CaptureLogger{},
+		SortMetrics:   true,
+		WriteMetadata: true,
+	}
+	require.NoError(t, s.Init())
+
+	payloads, err := s.SerializeBatchByType(metrics)
+	require.NoError(t, err)
+
+	expected := map[string]string{
+		"counter": `
+http_requests_total{code="200"} 1027
+http_requests_total{code="400"} 3
+`,
+		"gauge": `
+mem_free 1024
+`,
+		"histogram": `
+http_request_duration_seconds_count 10
+http_request_duration_seconds_sum 3
+http_request_duration_seconds_bucket{le="+Inf"} 10
+`,
+		"untyped": `
+cpu_time_idle 42
+`,
+	}
+	require.Len(t, payloads, len(expected))
+	for name, text := range expected {
+		data, found := payloads[name]
+		require.True(t, found, "no payload for type %q", name)
+		req, err := DecodePayload(data)
+		require.NoError(t, err)
+		require.Equal(t, strings.TrimSpace(text), strings.TrimSpace(RenderText(req)), "type %q", name)
+		for _, metadata := range req.Metadata {
+			require.Equal(t, name, expositionType(metadata.Type), "type %q", name)
+		}
+	}
+}
+
+func TestRemoteWriteSerializeCustomBoundaryLabels(t *testing.T) {
+	metrics := []telegraf.Metric{
+		testutil.MustMetric(
+			"prometheus",
+			map[string]string{"le": "0.5"},
+			map[string]interface{}{"http_request_duration_seconds_bucket": 129389.0},
+			time.Unix(0, 0),
+			telegraf.Histogram,
+		),
+		testutil.MustMetric(
+			"prometheus",
+			map[string]string{"quantile": "0.99"},
+			map[string]interface{}{"rpc_duration_seconds": 76656.0},
+			time.Unix(0, 0),
+			telegraf.Summary,
+		),
+	}
+
+	s := &Serializer{
+		Log:               &testutil.CaptureLogger{},
+		SortMetrics:       true,
+		BucketLabelName:   "boundary",
+		QuantileLabelName: "q",
+	}
+	require.NoError(t, s.Init())
+
+	data, err := s.SerializeBatch(metrics)
+	require.NoError(t, err)
+	actual, err := prompbToText(data)
+	require.NoError(t, err)
+
+	expected := `
+http_request_duration_seconds_count 0
+http_request_duration_seconds_sum 0
+http_request_duration_seconds_bucket{boundary="+Inf"} 0
+http_request_duration_seconds_bucket{boundary="0.5"} 129389
+rpc_duration_seconds{q="0.99"} 76656
+`
+	require.Equal(t, strings.TrimSpace(expected), strings.TrimSpace(string(actual)))
+}
+
+func TestRemoteWriteSerializeTimestampValueField(t *testing.T) {
+	metrics := []telegraf.Metric{
+		testutil.MustMetric(
+			"deployment",
+			map[string]string{"service": "api"},
+			map[string]interface{}{
+				"duration_seconds": 42.0,
+				"finished_at":      int64(1700000000123456789),
+			},
+			time.Unix(1700000060, 0),
+		),
+		testutil.MustMetric(
+			"deployment",
+			map[string]string{"service": "web"},
+			map[string]interface{}{"duration_seconds": 7.0},
+			time.Unix(1700000060, 0),
+		),
+	}
+
+	s := &Serializer{
+		Log:                 &testutil.CaptureLogger{},
+		SortMetrics:         true,
+		TimestampValueField: "finished_at",
+	}
+	require.NoError(t, s.Init())
+
+	data, err := s.SerializeBatch(metrics)
+	require.NoError(t, err)
+	req, err := DecodePayload(data)
+	require.NoError(t, err)
+	require.Len(t, req.Timeseries, 2)
+
+	expected := map[string]int64{
+		"api": 1700000000123,
+		"web": 1700000060000,
+	}
+	actual := make(map[string]int64, len(req.Timeseries))
+	for _, ts := range req.Timeseries {
+		require.Equal(t, "deployment_duration_seconds", seriesName(ts.Labels))
+		require.Len(t, ts.Samples, 1)
+		service, _ := labelValue(ts.Labels, "service")
+		actual[service] = ts.Samples[0].Timestamp
+	}
+	require.Equal(t, expected, actual)
+}
+
+func TestRemoteWriteSerializeTimestampValueSource(t *testing.T) {
+	metrics := []telegraf.Metric{
+		testutil.MustMetric(
+			"deployment",
+			map[string]string{"service": "api"},
+			map[string]interface{}{
+				"duration_seconds": 42.0,
+				"retries":          2,
+				"finished_at":      int64(1700000000123456789),
+			},
+			time.Unix(1700000060, 0),
+		),
+		testutil.MustMetric(
+			"deployment",
+			map[string]string{"service": "db"},
+			map[string]interface{}{
+				"retries":     1,
+				"finished_at": int64(1700000000123456789),
+			},
+			time.Unix(1700000060, 0),
+		),
+		testutil.MustMetric(
+			"deployment",
+			map[string]string{"service": "web"},
+			map[string]interface{}{"duration_seconds": 7.0, "retries": 0},
+			time.Unix(1700000060, 0),
+		),
+	}
+
+	s := &Serializer{
+		Log:                  &testutil.CaptureLogger{},
+		SortMetrics:          true,
+		TimestampValueField:  "finished_at",
+		TimestampValueSource: "duration_seconds",
+	}
+	require.NoError(t, s.Init())
+
+	data, err := s.SerializeBatch(metrics)
+	require.NoError(t, err)
+	req, err := DecodePayload(data)
+	require.NoError(t, err)
+
+	// The event only contributes its value, metrics without the timestamp
+	// field are serialized as usual and events without a value are dropped
+	expected := []string{
+		`deployment_duration_seconds{service="api"} 42 @1700000000123`,
+		`deployment_duration_seconds{service="web"} 7 @1700000060000`,
+		`deployment_retries{service="web"} 0 @1700000060000`,
+	}
+	actual := make([]string, 0, len(req.Timeseries))
+	for _, ts := range req.Timeseries {
+		require.Len(t, ts.Samples, 1)
+		service, _ := labelValue(ts.Labels, "service")
+		actual = append(actual, fmt.Sprintf("%s{service=%q} %v @%d",
+			seriesName(ts.Labels), service, ts.Samples[0].Value, ts.Samples[0].Timestamp))
+	}
+	require.Equal(t, expected, actual)
+}
+
+func TestRemoteWriteSerializeFieldTimestamp(t *testing.T) {
+	m := testutil.MustMetric(
+		"sensor",
+		map[string]string{},
+		map[string]interface{}{
+			"temperature":    21.5,
+			"temperature_ts": int64(1700000000),
+			"humidity":       40.0,
+			"humidity_ts":    "1700000030",
+			"pressure":       1013.0,
+		},
+		time.Unix(1700000060, 0),
+	)
+
+	s := &Serializer{
+		Log:                  &testutil.CaptureLogger{},
+		SortMetrics:          true,
+		FieldTimestampSuffix: "_ts",
+	}
+	require.NoError(t, s.Init())
+
+	data, err := s.Serialize(m)
+	require.NoError(t, err)
+	req, err := DecodePayload(data)
+	require.NoError(t, err)
+
+	expected := map[string]int64{
+		"sensor_humidity":    1700000030000,
+		"sensor_pressure":    1700000060000,
+		"sensor_temperature": 1700000000000,
+	}
+	actual := make(map[string]int64, len(req.Timeseries))
+	for _, ts := range req.Timeseries {
+		require.Len(t, ts.Samples, 1)
+		actual[seriesName(ts.Labels)] = ts.Samples[0].Timestamp
+	}
+	require.Equal(t, expected, actual)
+}
+
+func TestRemoteWriteSerializeFieldTimestampWithoutInit(t *testing.T) {
+	m := testutil.MustMetric(
+		"sensor",
+		map[string]string{},
+		map[string]interface{}{
+			"temperature":    21.5,
+			"temperature_ts": int64(1700000000),
+		},
+		time.Unix(1700000060, 0),
+	)
+
+	s := &Serializer{
+		Log:                  &testutil.CaptureLogger{},
+		FieldTimestampSuffix: "_ts",
+	}
+
+	data, err := s.Serialize(m)
+	require.NoError(t, err)
+	req, err := DecodePayload(data)
+	require.NoError(t, err)
+	require.Len(t, req.Timeseries, 1)
+	require.Len(t, req.Timeseries[0].Samples, 1)
+	require.Equal(t, int64(1700000000000), req.Timeseries[0].Samples[0].Timestamp)
+}
+
+func TestRemoteWriteSerializeReservedLabels(t *testing.T) {
+	m := testutil.MustMetric(
+		"cpu",
+		map[string]string{
+			"host":                "example.org",
+			"__name__":            "memory",
+			"__scrape_interval__": "10s",
+			"__tenant__":          "ops",
+		},
+		map[string]interface{}{"time_idle": 42.0},
+		time.Unix(0, 0),
+	)
+
+	tests := []struct {
+		name     string
+		preserve []string
+		expected string
+	}{
+		{
+			name:     "default",
+			expected: `cpu_time_idle{host="example.org"} 42`,
+		},
+		{
+			name:     "preserved",
+			preserve: []string{"__tenant__", "__name__"},
+			expected: `cpu_time_idle{__tenant__="ops", host="example.org"} 42`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Serializer{
+				Log:                    &testutil.CaptureLogger{},
+				PreserveReservedLabels: tt.preserve,
+			}
+			require.NoError(t, s.Init())
+
+			data, err := s.Serialize(m)
+			require.NoError(t, err)
+			actual, err := prompbToText(data)
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, strings.TrimSpace(string(actual)))
+		})
+	}
+}
+
+func TestRemoteWriteSerializeValueMultipliers(t *testing.T) {
+	metrics := []telegraf.Metric{
+		testutil.MustMetric(
+			"http",
+			map[string]string{},
+			map[string]interface{}{
+				"response_time": 0.25,
+				"requests":      10.0,
+			},
+			time.Unix(0, 0),
+			telegraf.Gauge,
+		),
+		testutil.MustMetric(
+			"disk",
+			map[string]string{},
+			map[string]interface{}{"used": 2.0},
+			time.Unix(0, 0),
+		),
+		testutil.MustMetric(
+			"prometheus",
+			map[string]string{"le": "0.5"},
+			map[string]interface{}{"http_request_duration_seconds_bucket": 129389.0},
+			time.Unix(0, 0),
+			telegraf.Histogram,
+		),
+	}
+
+	s := &Serializer{
+		Log:         &testutil.CaptureLogger{},
+		SortMetrics: true,
+		ValueMultipliers: map[string]float64{
+			"response_time":                 1000,
+			"disk_used":                     1024,
+			"http_request_duration_seconds": 1000,
+		},
+	}
+	require.NoError(t, s.Init())
+
+	data, err := s.SerializeBatch(metrics)
+	require.NoError(t, err)
+	actual, err := prompbToText(data)
+	require.NoError(t, err)
+
+	expected := `
+disk_used 2048
+http_request_duration_seconds_count 0
+http_request_duration_seconds_sum 0
+http_requests 10
+http_response_time 250
+http_request_duration_seconds_bucket{le="+Inf"} 0
+http_request_duration_seconds_bucket{le="0.5"} 129389
+`
+	require.Equal(t, strings.TrimSpace(expected), strings.TrimSpace(string(actual)))
+}
+
+func TestRemoteWriteSerializeAnnotationPolicy(t *testing.T) {
+	m := testutil.MustMetric(
+		"deployment",
+		map[string]string{"service": "api"},
+		map[string]interface{}{"version": "v1.2.3"},
+		time.Unix(10, 0),
+	)
+
+	tests := []struct {
+		policy   string
+		expected []prompb.TimeSeries
+	}{
+		{
+			policy: "drop",
+		},
+		{
+			policy: "presence",
+			expected: []prompb.TimeSeries{
+				{
+					Labels: []prompb.Label{
+						{Name: "__name__", Value: "deployment"},
+						{Name: "service", Value: "api"},
+					},
+					Samples: []prompb.Sample{{Value: 1, Timestamp: 10000}},
+				},
+			},
+		},
+		{
+			policy: "exemplar",
+			expected: []prompb.TimeSeries{
+				{
+					Labels: []prompb.Label{
+						{Name: "__name__", Value: "deployment"},
+						{Name: "service", Value: "api"},
+					},
+					Exemplars: []prompb.Exemplar{
+						{
+							Labels:    []prompb.Label{{Name: "version", Value: "v1.2.3"}},
+							Value:     1,
+							Timestamp: 10000,
+						},
+					},
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			s := &Serializer{
+				Log:              &testutil.CaptureLogger{},
+				AnnotationPolicy: tt.policy,
+			}
+			require.NoError(t, s.Init())
+
+			data, err := s.Serialize(m)
+			require.NoError(t, err)
+			req, err := DecodePayload(data)
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, req.Timeseries)
+		})
+	}
+}
+
+func TestRemoteWriteSerializeStringOnlyMetric(t *testing.T) {
+	m := testutil.MustMetric(
+		"deployment",
+		map[string]string{"service": "api"},
+		map[string]interface{}{"version": "v1.2.3"},
+		time.Unix(10, 0),
+	)
+
+	tests := []struct {
+		policy   string
+		expected []prompb.TimeSeries
+		types    []prompb.MetricMetadata_MetricType
+	}{
+		{
+			policy: "drop",
+		},
+		{
+			policy: "presence",
+			expected: []prompb.TimeSeries{
+				{
+					Labels: []prompb.Label{
+						{Name: "__name__", Value: "deployment"},
+						{Name: "service", Value: "api"},
+						{Name: "version", Value: "v1.2.3"},
+					},
+					Samples: []prompb.Sample{{Value: 1, Timestamp: 10000}},
+				},
+			},
+			types: []prompb.MetricMetadata_MetricType{prompb.MetricMetadata_GAUGE},
+		},
+		{
+			policy: "info",
+			expected: []prompb.TimeSeries{
+				{
+					Labels: []prompb.Label{
+						{Name: "__name__", Value: "deployment_info"},
+						{Name: "service", Value: "api"},
+						{Name: "version", Value: "v1.2.3"},
+					},
+					Samples: []prompb.Sample{{Value: 1, Timestamp: 10000}},
+				},
+			},
+			types: []prompb.MetricMetadata_MetricType{prompb.MetricMetadata_INFO},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			s := &Serializer{
+				Log:              &testutil.CaptureLogger{},
+				StringAsLabel:    true,
+				WriteMetadata:    true,
+				AnnotationPolicy: tt.policy,
+			}
+			require.NoError(t, s.Init())
+
+			data, err := s.Serialize(m)
+			require.NoError(t, err)
+			req, err := DecodePayload(data)
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, req.Timeseries)
+
+			var types []prompb.MetricMetadata_MetricType
+			for _, metadata := range req.Metadata {
+				types = append(types, metadata.Type)
+			}
+			require.Equal(t, tt.types, types)
+		})
+	}
+}
+
+func TestRemoteWriteSerializeMetricHashLabel(t *testing.T) {
+	newMetric := func(host string, value float64) telegraf.Metric {
+		return testutil.MustMetric(
+			"cpu",
+			map[string]string{"host": host},
+			map[string]interface{}{"time_idle": value},
+			time.Unix(0, 0),
+		)
+	}
+
+	s := &Serializer{
+		Log:             &testutil.CaptureLogger{},
+		MetricHashLabel: "metric_hash",
+	}
+	require.NoError(t, s.Init())
+
+	hashOf := func(m telegraf.Metric) string {
+		data, err := s.Serialize(m)
+		require.NoError(t, err)
+		req, err := DecodePayload(data)
+		require.NoError(t, err)
+		require.Len(t, req.Timeseries, 1)
+		hash, found := labelValue(req.Timeseries[0].Labels, "metric_hash")
+		require.True(t, found)
+		return hash
+	}
+
+	first := hashOf(newMetric("a.example.org", 42.0))
+	require.Len(t, first, 16)
+	require.Equal(t, first, hashOf(newMetric("a.example.org", 42.0)))
+	require.Equal(t, first, hashOf(newMetric("a.example.org", 43.0)))
+	require.NotEqual(t, first, hashOf(newMetric("b.example.org", 42.0)))
+}
+
+func TestRemoteWriteSerializeInstanceFromHostname(t *testing.T) {
+	original := hostname
+	defer func() { hostname = original }()
+	hostname = func() (string, error) { return "node01.example.org", nil }
+
+	s := &Serializer{
+		Log:                  &testutil.CaptureLogger{},
+		SortMetrics:          true,
+		InstanceFromHostname: true,
+	}
+	require.NoError(t, s.Init())
+
+	data, err := s.SerializeBatch([]telegraf.Metric{
+		testutil.MustMetric(
+			"cpu",
+			map[string]string{"host": "a"},
+			map[string]interface{}{"time_idle": 42.0},
+			time.Unix(0, 0),
+		),
+		testutil.MustMetric(
+			"mem",
+			map[string]string{"instance": "localhost:9100"},
+			map[string]interface{}{"free": 1024.0},
+			time.Unix(0, 0),
+		),
+	})
+	require.NoError(t, err)
+	actual, err := prompbToText(data)
+	require.NoError(t, err)
+	expected := `
+mem_free{instance="localhost:9100"} 1024
+cpu_time_idle{host="a", instance="node01.example.org"} 42
+`
+	require.Equal(t, strings.TrimSpace(expected), strings.TrimSpace(string(actual)))
+}
+
+func TestRemoteWriteInitInstanceFromHostnameFailed(t *testing.T) {
+	original := hostname
+	defer func() { hostname = original }()
+	hostname = func() (string, error) { return "", errors.New("no hostname") }
+
+	s := &Serializer{InstanceFromHostname: true}
+	require.ErrorContains(t, s.Init(), "determining hostname failed: no hostname")
+}
+
+func TestRemoteWriteSerializeHostTagBehavior(t *testing.T) {
+	tests := []struct {
+		behavior string
+		expected string
+	}{
+		{behavior: "keep", expected: `cpu_time_idle{cpu="cpu0", host="a"} 42`},
+		{behavior: "drop", expected: `cpu_time_idle{cpu="cpu0"} 42`},
+		{behavior: "rename-to-instance", expected: `cpu_time_idle{cpu="cpu0", instance="a"} 42`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.behavior, func(t *testing.T) {
+			s := &Serializer{
+				Log:             &testutil.CaptureLogger{},
+				HostTagBehavior: tt.behavior,
+			}
+			require.NoError(t, s.Init())
+
+			data, err := s.SerializeBatch([]telegraf.Metric{
+				testutil.MustMetric(
+					"cpu",
+					map[string]string{"host": "a", "cpu": "cpu0"},
+					map[string]interface{}{"time_idle": 42.0},
+					time.Unix(0, 0),
+				),
+			})
+			require.NoError(t, err)
+			actual, err := prompbToText(data)
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, strings.TrimSpace(string(actual)))
+		})
+	}
+}
+
+func TestRemoteWriteSerializeHostTagRenamedBeforeHostname(t *testing.T) {
+	original := hostname
+	defer func() { hostname = original }()
+	hostname = func() (string, error) { return "node01.example.org", nil }
+
+	s := &Serializer{
+		Log:                  &testutil.CaptureLogger{},
+		InstanceFromHostname: true,
+		HostTagBehavior:      "rename-to-instance",
+	}
+	require.NoError(t, s.Init())
+
+	data, err := s.SerializeBatch([]telegraf.Metric{
+		testutil.MustMetric(
+			"cpu",
+			map[string]string{"host": "a"},
+			map[string]interface{}{"time_idle": 42.0},
+			time.Unix(0, 0),
+		),
+	})
+	require.NoError(t, err)
+	actual, err := prompbToText(data)
+	require.NoError(t, err)
+	require.Equal(t, `cpu_time_idle{instance="a"} 42`, strings.TrimSpace(string(actual)))
+}
+
+func TestRemoteWriteSerializationTimeout(t *testing.T) {
+	s := &Serializer{
+		Log:                  &testutil.CaptureLogger{},
+		SortMetrics:          true,
+		DedupScope:           "serializer",
+		CardinalityLimit:     1,
+		DeltaToCumulative:    true,
+		SerializationTimeout: config.Duration(10 * time.Millisecond),
+	}
+	require.NoError(t, s.Init())
+
+	// Block the conversion of the first value until the serialization
+	// timed out
+	release := make(chan struct{})
+	converted := make(chan struct{})
+	var blocked atomic.Bool
+	s.valueTransform = func(v float64) float64 {
+		if blocked.CompareAndSwap(false, true) {
+			<-release
+			close(converted)
+		}
+		return v
+	}
+
+	_, err := s.SerializeBatch([]telegraf.Metric{
+		testutil.MustMetric(
+			"http",
+			map[string]string{"host": "a"},
+			map[string]interface{}{"requests_total": 5.0},
+			time.Unix(10, 0),
+			telegraf.Counter,
+		),
+		testutil.MustMetric(
+			"cpu",
+			map[string]string{"host": "a"},
+			map[string]interface{}{"usage": 1.0},
+			time.Unix(10, 0),
+		),
+		testutil.MustMetric(
+			"mem",
+			map[string]string{},
+			map[string]interface{}{"used": 1.0},
+			time.Unix(10, 0),
+		),
+	})
+	require.ErrorContains(t, err, "serialization exceeded timeout of 10ms")
+	close(release)
+	<-converted
+
+	// The abandoned serialization must neither have accumulated the counter,
+	// nor occupied the cardinality limit, nor recorded the timestamps
+	s.SerializationTimeout = config.Duration(time.Minute)
+	data, err := s.SerializeBatch([]telegraf.Metric{
+		testutil.MustMetric(
+			"http",
+			map[string]string{"host": "a"},
+			map[string]interface{}{"requests_total": 3.0},
+			time.Unix(20, 0),
+			telegraf.Counter,
+		),
+		testutil.MustMetric(
+			"cpu",
+			map[string]string{"host": "b"},
+			map[string]interface{}{"usage": 2.0},
+			time.Unix(5, 0),
+		),
+		testutil.MustMetric(
+			"mem",
+			map[string]string{},
+			map[string]interface{}{"used": 1.0},
+			time.Unix(5, 0),
+		),
+	})
+	require.NoError(t, err)
+	actual, err := prompbToText(data)
+	require.NoError(t, err)
+	expected := `mem_used 1
+cpu_usage{host="b"} 2
+http_requests_total{host="a"} 3`
+	require.Equal(t, expected, strings.TrimSpace(string(actual)))
+}
+
+func TestRemoteWriteSerializationWithinTimeout(t *testing.T) {
+	s := &Serializer{
+		Log:                  &testutil.CaptureLogger{},
+		SerializationTimeout: config.Duration(time.Minute),
+	}
+	require.NoError(t, s.Init())
+	data, err := s.Serialize(testutil.MustMetric(
+		"cpu",
+		map[string]string{},
+		map[string]interface{}{"time_idle": 42.0},
+		time.Unix(0, 0),
+	))
+	require.NoError(t, err)
+	actual, err := prompbToText(data)
+	require.NoError(t, err)
+	require.Equal(t, "cpu_time_idle 42", strings.TrimSpace(string(actual)))
+}
+
+func TestRemoteWriteSerializeLabelCollisionPolicy(t *testing.T) {
+	m := testutil.MustMetric(
+		"cpu",
+		map[string]string{"host-name": "a", "host.name": "b", "host_name_2": "c"},
+		map[string]interface{}{"time_idle": 42.0},
+		time.Unix(0, 0),
+	)
+
+	tests := []struct {
+		policy   string
+		expected string
+		labels   string
+	}{
+		{
+			policy:   "last-wins",
+			expected: `cpu_time_idle{host_name="b", host_name_2="c"} 42`,
+			labels:   `["host_name"]`,
+		},
+		{
+			policy:   "suffix",
+			expected: `cpu_time_idle{host_name="a", host_name_2="b", host_name_2_2="c"} 42`,
+			labels:   `["host_name" "host_name_2"]`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			clog := &testutil.CaptureLogger{}
+			s := &Serializer{
+				Log:                  clog,
+				LabelCollisionPolicy: tt.policy,
+			}
+			require.NoError(t, s.Init())
+
+			data, err := s.Serialize(m)
+			require.NoError(t, err)
+			actual, err := prompbToText(data)
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, strings.TrimSpace(string(actual)))
+
+			warnings := clog.Warnings()
+			require.Len(t, warnings, 1)
+			require.Contains(t, warnings[0], fmt.Sprintf("resolved tags colliding in labels %s after sanitization using policy %q", tt.labels, tt.policy))
+		})
+	}
+
+	t.Run("error", func(t *testing.T) {
+		s := &Serializer{
+			Log:                  &testutil.CaptureLogger{},
+			LabelCollisionPolicy: "error",
+		}
+		require.NoError(t, s.Init())
+
+		_, err := s.Serialize(m)
+		require.ErrorContains(t, err, `tags of metric "cpu" collide in label "host_name" after sanitization`)
+	})
+}
+
+func TestRemoteWriteSerializeLabelKeyStripPrefix(t *testing.T) {
+	m := testutil.MustMetric(
+		"cpu",
+		map[string]string{"tag_host": "a", "tag_cpu": "cpu0", "tag_": "x", "region": "eu"},
+		map[string]interface{}{"time_idle": 42.0},
+		time.Unix(0, 0),
+	)
+
+	s := &Serializer{
+		Log:                 &testutil.CaptureLogger{},
+		LabelKeyStripPrefix: "tag_",
+	}
+	require.NoError(t, s.Init())
+
+	data, err := s.Serialize(m)
+	require.NoError(t, err)
+	actual, err := prompbToText(data)
+	require.NoError(t, err)
+	require.Equal(t, `cpu_time_idle{cpu="cpu0", host="a", region="eu", tag_="x"} 42`, strings.TrimSpace(string(actual)))
+}
+
+func TestRemoteWriteSerializeLabelKeyStripPrefixCollision(t *testing.T) {
+	m := testutil.MustMetric(
+		"cpu",
+		map[string]string{"host": "a", "tag_host": "b"},
+		map[string]interface{}{"time_idle": 42.0},
+		time.Unix(0, 0),
+	)
+
+	clog := &testutil.CaptureLogger{}
+	s := &Serializer{
+		Log:                  clog,
+		LabelKeyStripPrefix:  "tag_",
+		LabelCollisionPolicy: "suffix",
+	}
+	require.NoError(t, s.Init())
+
+	data, err := s.Serialize(m)
+	require.NoError(t, err)
+	actual, err := prompbToText(data)
+	require.NoError(t, err)
+	require.Equal(t, `cpu_time_idle{host="a", host_2="b"} 42`, strings.TrimSpace(string(actual)))
+
+	warnings := clog.Warnings()
+	require.Len(t, warnings, 1)
+	require.Contains(t, warnings[0], `resolved tags colliding in labels ["host"]`)
+}
+
+func TestRemoteWriteSerializeGeneratedLabelCollision(t *testing.T) {
+	metrics := []telegraf.Metric{
+		testutil.MustMetric(
+			"prometheus",
+			map[string]string{"le": "0.5", "le.": "tag", "agg": "sum", "__aggregation__": "tag"},
+			map[string]interface{}{"http_request_duration_seconds_bucket": 10.0},
+			time.Unix(0, 0),
+			telegraf.Histogram,
+		),
+		testutil.MustMetric(
+			"cpu",
+			map[string]string{"__series_id__": "tag", "__write_id__": "tag"},
+			map[string]interface{}{"time_idle": 42.0},
+			time.Unix(0, 0),
+		),
+	}
+
+	s := &Serializer{
+		Log:                    &testutil.CaptureLogger{},
+		SortMetrics:            true,
+		AggregationLabel:       "agg",
+		EmitSeriesID:           true,
+		EmitWriteID:            true,
+		PreserveReservedLabels: []string{"__aggregation__", "__series_id__", "__write_id__"},
+	}
+	require.NoError(t, s.Init())
+
+	data, err := s.SerializeBatch(metrics)
+	require.NoError(t, err)
+	req, err := DecodePayload(data)
+	require.NoError(t, err)
+	require.NotEmpty(t, req.Timeseries)
+
+	for _, ts := range req.Timeseries {
+		names := make(map[string]bool, len(ts.Labels))
+		for _, l := range ts.Labels {
+			require.False(t, names[l.Name], "duplicate label %q in %v", l.Name, ts.Labels)
+			names[l.Name] = true
+		}
+
+		for _, name := range []string{"__series_id__", "__write_id__"} {
+			value, found := labelValue(ts.Labels, name)
+			require.True(t, found)
+			require.NotEqual(t, "tag", value)
+		}
+
+		if seriesName(ts.Labels) == "http_request_duration_seconds_bucket" {
+			if value, _ := labelValue(ts.Labels, "le"); value != "+Inf" {
+				require.Equal(t, "0.5", value)
+			}
+			value, found := labelValue(ts.Labels, "__aggregation__")
+			require.True(t, found)
+			require.Equal(t, "sum", value)
+		}
+	}
+}
+
+func TestRemoteWriteSerializeAggregationLabel(t *testing.T) {
+	metrics := []telegraf.Metric{
+		testutil.MustMetric(
+			"http",
+			map[string]string{"host": "a", "aggregation": "sum"},
+			map[string]interface{}{"requests_total": 42.0},
+			time.Unix(0, 0),
+			telegraf.Counter,
+		),
+		testutil.MustMetric(
+			"cpu",
+			map[string]string{"host": "a"},
+			map[string]interface{}{"time_idle": 7.0},
+			time.Unix(0, 0),
+		),
+	}
+
+	s := &Serializer{
+		Log:              &testutil.CaptureLogger{},
+		SortMetrics:      true,
+		AggregationLabel: "aggregation",
+	}
+	require.NoError(t, s.Init())
+
+	data, err := s.SerializeBatch(metrics)
+	require.NoError(t, err)
+	actual, err := prompbToText(data)
+	require.NoError(t, err)
+	expected := `
+cpu_time_idle{host="a"} 7
+http_requests_total{__aggregation__="sum", host="a"} 42
+`
+	require.Equal(t, strings.TrimSpace(expected), strings.TrimSpace(string(actual)))
+}
+
+func TestRemoteWriteSerializeDualEmitFields(t *testing.T) {
+	metrics := []telegraf.Metric{
+		testutil.MustMetric(
+			"net",
+			map[string]string{"interface": "eth0"},
+			map[string]interface{}{"bytes_recv": 1024.0, "drop_in": 3.0},
+			time.Unix(0, 0),
+		),
+		testutil.MustMetric(
+			"http",
+			map[string]string{},
+			map[string]interface{}{"requests_total": 42.0},
+			time.Unix(0, 0),
+			telegraf.Counter,
+		),
+	}
+
+	s := &Serializer{
+		Log:            &testutil.CaptureLogger{},
+		SortMetrics:    true,
+		WriteMetadata:  true,
+		DualEmitFields: []string{"bytes_recv", "requests_total"},
+	}
+	require.NoError(t, s.Init())
+
+	data, err := s.SerializeBatch(metrics)
+	require.NoError(t, err)
+	req, err := DecodePayload(data)
+	require.NoError(t, err)
+
+	expected := `
+http_requests 42
+http_requests_total 42
+net_bytes_recv{interface="eth0"} 1024
+net_bytes_recv_total{interface="eth0"} 1024
+net_drop_in{interface="eth0"} 3
+`
+	require.Equal(t, strings.TrimSpace(expected), strings.TrimSpace(RenderText(req)))
+
+	types := make(map[string]string, len(req.Metadata))
+	for _, metadata := range req.Metadata {
+		types[metadata.MetricFamilyName] = metadata.Type.String()
+	}
+	require.Equal(t, map[string]string{
+		"http_requests":        "GAUGE",
+		"http_requests_total":  "COUNTER",
+		"net_bytes_recv":       "GAUGE",
+		"net_bytes_recv_total": "COUNTER",
+		"net_drop_in":          "UNKNOWN",
+	}, types)
+}
+
+func TestRemoteWriteSerializeClampNegativeCounters(t *testing.T) {
+	metrics := []telegraf.Metric{
+		testutil.MustMetric(
+			"http",
+			map[string]string{},
+			map[string]interface{}{"requests_total": -5.0},
+			time.Unix(0, 0),
+			telegraf.Counter,
+		),
+		testutil.MustMetric(
+			"net",
+			map[string]string{},
+			map[string]interface{}{"bytes_total": 10.0},
+			time.Unix(0, 0),
+			telegraf.Counter,
+		),
+		testutil.MustMetric(
+			"room",
+			map[string]string{},
+			map[string]interface{}{"temperature": -3.5},
+			time.Unix(0, 0),
+			telegraf.Gauge,
+		),
+	}
+
+	clog := &testutil.CaptureLogger{}
+	s := &Serializer{
+		Log:                   clog,
+		SortMetrics:           true,
+		ClampNegativeCounters: true,
+	}
+	require.NoError(t, s.Init())
+
+	data, err := s.SerializeBatch(metrics)
+	require.NoError(t, err)
+	actual, err := prompbToText(data)
+	require.NoError(t, err)
+	expected := `
+http_requests_total 0
+net_bytes_total 10
+room_temperature -3.5
+`
+	require.Equal(t, strings.TrimSpace(expected), strings.TrimSpace(string(actual)))
+
+	warnings := clog.Warnings()
+	require.Len(t, warnings, 1)
+	require.Contains(t, warnings[0], `clamped negative values of counters ["http_requests_total"] to zero`)
+}
+
+func TestRemoteWriteSerializeTagsAsTargetInfo(t *testing.T) {
+	metrics := []telegraf.Metric{
+		testutil.MustMetric(
+			"cpu",
+			map[string]string{"host": "a", "os": "linux", "rack": "r1"},
+			map[string]interface{}{"time_idle": 42.0, "time_user": 7.0},
+			time.Unix(0, 0),
+		),
+		testutil.MustMetric(
+			"mem",
+			map[string]string{"host": "a", "os": "linux", "rack": "r1"},
+			map[string]interface{}{"free": 1024.0},
+			time.Unix(0, 0),
+		),
+		testutil.MustMetric(
+			"mem",
+			map[string]string{"host": "b"},
+			map[string]interface{}{"free": 2048.0},
+			time.Unix(0, 0),
+		),
+	}
+
+	s := &Serializer{
+		Log:              &testutil.CaptureLogger{},
+		SortMetrics:      true,
+		WriteMetadata:    true,
+		TagsAsTargetInfo: []string{"os", "rack"},
+	}
+	require.NoError(t, s.Init())
+
+	data, err := s.SerializeBatch(metrics)
+	require.NoError(t, err)
+	req, err := DecodePayload(data)
+	require.NoError(t, err)
+
+	expected := `
+cpu_time_idle{host="a"} 42
+cpu_time_user{host="a"} 7
+mem_free{host="a"} 1024
+mem_free{host="b"} 2048
+target_info{host="a", os="linux", rack="r1"} 1
+`
+	require.Equal(t, strings.TrimSpace(expected), strings.TrimSpace(RenderText(req)))
+	require.Contains(t, req.Metadata, prompb.MetricMetadata{
+		Type:             prompb.MetricMetadata_INFO,
+		MetricFamilyName: "target_info",
+	})
+}
+
+func TestRemoteWriteSerializeDeltaToCumulative(t *testing.T) {
+	newCounter := func(host string, delta int64, ts int64) telegraf.Metric {
+		return testutil.MustMetric(
+			"http",
+			map[string]string{"host": host},
+			map[string]interface{}{"requests_total": delta},
+			time.Unix(ts, 0),
+			telegraf.Counter,
+		)
+	}
+
+	s := &Serializer{
+		Log:               &testutil.CaptureLogger{},
+		SortMetrics:       true,
+		DeltaToCumulative: true,
+	}
+	require.NoError(t, s.Init())
+
+	data, err := s.SerializeBatch([]telegraf.Metric{
+		newCounter("a", 5, 10),
+		newCounter("b", 1, 10),
+		newCounter("a", 3, 20),
+	})
+	require.NoError(t, err)
+	actual, err := prompbToText(data)
+	require.NoError(t, err)
+	expected := `
+http_requests_total{host="a"} 8
+http_requests_total{host="b"} 1
+`
+	require.Equal(t, strings.TrimSpace(expected), strings.TrimSpace(string(actual)))
+
+	data, err = s.SerializeBatch([]telegraf.Metric{
+		newCounter("a", 2, 30),
+		newCounter("b", 4, 30),
+	})
+	require.NoError(t, err)
+	actual, err = prompbToText(data)
+	require.NoError(t, err)
+	expected = `
+http_requests_total{host="a"} 10
+http_requests_total{host="b"} 5
+`
+	require.Equal(t, strings.TrimSpace(expected), strings.TrimSpace(string(actual)))
+}
+
+func TestRemoteWriteSerializeDeltaToCumulativeRetry(t *testing.T) {
+	newCounter := func(host string, delta int64, ts int64) telegraf.Metric {
+		return testutil.MustMetric(
+			"http",
+			map[string]string{"host": host},
+			map[string]interface{}{"requests_total": delta},
+			time.Unix(ts, 0),
+			telegraf.Counter,
+		)
+	}
+	batch := []telegraf.Metric{
+		newCounter("a", 5, 10),
+		newCounter("b", 1, 10),
+		newCounter("a", 3, 20),
+	}
+
+	s := &Serializer{
+		Log:               &testutil.CaptureLogger{},
+		SortMetrics:       true,
+		DeltaToCumulative: true,
+	}
+	require.NoError(t, s.Init())
+
+	// Serializing the batch again, e.g. after a failed write, must not add
+	// the deltas twice, independent of the serialization function.
+	expected, err := s.SerializeBatch(batch)
+	require.NoError(t, err)
+	actual, err := s.SerializeBatch(batch)
+	require.NoError(t, err)
+	require.Equal(t, expected, actual)
+	chunks, err := s.SerializeBatchChunked(batch)
+	require.NoError(t, err)
+	require.Equal(t, [][]byte{expected}, chunks)
+
+	data, err := s.SerializeBatch([]telegraf.Metric{newCounter("a", 2, 30)})
+	require.NoError(t, err)
+	text, err := prompbToText(data)
+	require.NoError(t, err)
+	require.Equal(t, `http_requests_total{host="a"} 10`, strings.TrimSpace(string(text)))
+}
+
+func TestRemoteWriteSerializeLabelsLexicographic(t *testing.T) {
+	m := testutil.MustMetric(
+		"cpu",
+		map[string]string{
+			"host":    "example.org",
+			"Zone":    "eu",
+			"__aaa__": "preserved",
+		},
+		map[string]interface{}{"time_idle": 42.0},
+		time.Unix(0, 0),
+	)
+
+	s := &Serializer{
+		Log:                    &testutil.CaptureLogger{},
+		PreserveReservedLabels: []string{"__aaa__"},
+	}
+	require.NoError(t, s.Init())
+
+	data, err := s.Serialize(m)
+	require.NoError(t, err)
+	req, err := DecodePayload(data)
+	require.NoError(t, err)
+	require.Len(t, req.Timeseries, 1)
+
+	// The remote-write specification requires the labels to be sorted
+	// lexicographically by name, so the metric name is not necessarily first.
+	expected := []prompb.Label{
+		{Name: "Zone", Value: "eu"},
+		{Name: "__aaa__", Value: "preserved"},
+		{Name: "__name__", Value: "cpu_time_idle"},
+		{Name: "host", Value: "example.org"},
+	}
+	require.Equal(t, expected, req.Timeseries[0].Labels)
+}
+
+func TestRemoteWriteSerializeMissingTimestamp(t *testing.T) {
+	m := testutil.MustMetric(
+		"cpu",
+		map[string]string{},
+		map[string]interface{}{"time_idle": 42.0},
+		time.Time{},
+	)
+
+	t.Run("now", func(t *testing.T) {
+		s := &Serializer{Log: &testutil.CaptureLogger{}}
+		require.NoError(t, s.Init())
+
+		before := time.Now().UnixMilli()
+		data, err := s.Serialize(m)
+		require.NoError(t, err)
+		after := time.Now().UnixMilli()
+
+		req, err := DecodePayload(data)
+		require.NoError(t, err)
+		require.Len(t, req.Timeseries, 1)
+		require.GreaterOrEqual(t, req.Timeseries[0].Samples[0].Timestamp, before)
+		require.LessOrEqual(t, req.Timeseries[0].Samples[0].Timestamp, after)
+	})
+
+	t.Run("keep", func(t *testing.T) {
+		s := &Serializer{
+			Log:                    &testutil.CaptureLogger{},
+			MissingTimestampPolicy: "keep",
+		}
+		require.NoError(t, s.Init())
+
+		data, err := s.Serialize(m)
+		require.NoError(t, err)
+		req, err := DecodePayload(data)
+		require.NoError(t, err)
+		require.Len(t, req.Timeseries, 1)
+		require.Equal(t, time.Time{}.UnixNano()/int64(time.Millisecond), req.Timeseries[0].Samples[0].Timestamp)
+	})
+
+	t.Run("drop", func(t *testing.T) {
+		clog := &testutil.CaptureLogger{}
+		s := &Serializer{
+			Log:                    clog,
+			MissingTimestampPolicy: "drop",
+		}
+		require.NoError(t, s.Init())
+
+		data, err := s.Serialize(m)
+		require.NoError(t, err)
+		actual, err := prompbToText(data)
+		require.NoError(t, err)
+		require.Empty(t, actual)
+
+		warnings := clog.Warnings()
+		require.Len(t, warnings, 1)
+		require.Contains(t, warnings[0], `metric "cpu" has no timestamp`)
+	})
+
+	t.Run("error", func(t *testing.T) {
+		s := &Serializer{
+			Log:                    &testutil.CaptureLogger{},
+			MissingTimestampPolicy: "error",
+		}
+		require.NoError(t, s.Init())
+
+		_, err := s.Serialize(m)
+		require.ErrorContains(t, err, `metric "cpu" has no timestamp`)
+	})
+}
+
+func TestRemoteWriteSerializeDropTag(t *testing.T) {
+	metrics := []telegraf.Metric{
+		testutil.MustMetric(
+			"cpu",
+			map[string]string{"host": "a", "exclude": "true"},
+			map[string]interface{}{"time_idle": 42.0},
+			time.Unix(0, 0),
+		),
+		testutil.MustMetric(
+			"cpu",
+			map[string]string{"host": "b", "exclude": "false"},
+			map[string]interface{}{"time_idle": 43.0},
+			time.Unix(0, 0),
+		),
+		testutil.MustMetric(
+			"cpu",
+			map[string]string{"host": "c"},
+			map[string]interface{}{"time_idle": 44.0},
+			time.Unix(0, 0),
+		),
+	}
+
+	tests := []struct {
+		name     string
+		value    string
+		expected string
+	}{
+		{
+			name: "any value",
+			expected: `
+cpu_time_idle{host="c"} 44
+`,
+		},
+		{
+			name:  "specific value",
+			value: "true",
+			expected: `
+cpu_time_idle{host="b"} 43
+cpu_time_idle{host="c"} 44
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Serializer{
+				Log:          &testutil.CaptureLogger{},
+				SortMetrics:  true,
+				DropTag:      "exclude",
+				DropTagValue: tt.value,
+			}
+			require.NoError(t, s.Init())
+
+			data, err := s.SerializeBatch(metrics)
+			require.NoError(t, err)
+			actual, err := prompbToText(data)
+			require.NoError(t, err)
+			require.Equal(t, strings.TrimSpace(tt.expected), strings.TrimSpace(string(actual)))
+		})
+	}
+}
+
+func TestRemoteWriteSerializeParseStringNumbers(t *testing.T) {
+	metrics := []telegraf.Metric{
+		testutil.MustMetric(
+			"cpu",
+			map[string]string{"host": "a"},
+			map[string]interface{}{
+				"time_idle": "3.0",
+				"state":     "asd",
+			},
+			time.Unix(0, 0),
+		),
+	}
+
+	tests := []struct {
+		name          string
+		stringAsLabel bool
+		expected      string
+	}{
+		{
+			name: "drop unparseable",
+			expected: `
+cpu_time_idle{host="a"} 3
+`,
+		},
+		{
+			name:          "unparseable as label",
+			stringAsLabel: true,
+			expected: `
+cpu_time_idle{host="a", state="asd"} 3
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Serializer{
+				Log:                &testutil.CaptureLogger{},
+				SortMetrics:        true,
+				StringAsLabel:      tt.stringAsLabel,
+				ParseStringNumbers: true,
+			}
+			require.NoError(t, s.Init())
+
+			data, err := s.SerializeBatch(metrics)
+			require.NoError(t, err)
+			actual, err := prompbToText(data)
+			require.NoError(t, err)
+			require.Equal(t, strings.TrimSpace(tt.expected), strings.TrimSpace(string(actual)))
+		})
+	}
+}
+
+func TestRemoteWriteSerializeLogBatchSummary(t *testing.T) {
+	metrics := []telegraf.Metric{
+		testutil.MustMetric(
+			"cpu",
+			map[string]string{"host": "a"},
+			map[string]interface{}{
+				"time_idle": 42.0,
+				"time_user": 1.0,
+			},
+			time.Unix(1, 0),
+		),
+		testutil.MustMetric(
+			"cpu",
+			map[string]string{"host": "a"},
+			map[string]interface{}{"time_idle": 41.0},
+			time.Unix(0, 0),
+		),
+		testutil.MustMetric(
+			"mem",
+			map[string]string{"host": "a"},
+			map[string]interface{}{"used": 10.0},
+			time.Unix(1, 0),
+		),
+	}
+
+	clog := &testutil.CaptureLogger{}
+	s := &Serializer{
+		Log:             clog,
+		LogBatchSummary: true,
+	}
+	require.NoError(t, s.Init())
+
+	data, err := s.SerializeBatch(metrics)
+	require.NoError(t, err)
+
+	var summaries []string
+	for _, msg := range clog.Messages() {
+		if msg.Level == testutil.LevelDebug {
+			summaries = append(summaries, msg.Text)
+		}
+	}
+	expected := fmt.Sprintf("serialized 3 series with 3 samples of 3 families, dropped 1 series, payload size %d bytes", len(data))
+	require.Equal(t, []string{expected}, summaries)
+
+	// The summary is not logged by default
+	clog.Clear()
+	s.LogBatchSummary = false
+	_, err = s.SerializeBatch(metrics)
+	require.NoError(t, err)
+	for _, msg := range clog.Messages() {
+		require.NotEqual(t, byte(testutil.LevelDebug), msg.Level)
+	}
+}
+
+func TestRemoteWriteSerializeTypeConflict(t *testing.T) {
+	metrics := []telegraf.Metric{
+		testutil.MustMetric(
+			"cpu",
+			map[string]string{"host": "a"},
+			map[string]interface{}{"time_idle": 42.0},
+			time.Unix(0, 0),
+			telegraf.Counter,
+		),
+		testutil.MustMetric(
+			"cpu",
+			map[string]string{"host": "a"},
+			map[string]interface{}{"time_idle": 43.0},
+			time.Unix(1, 0),
+			telegraf.Gauge,
+		),
+	}
+
+	t.Run("first-wins", func(t *testing.T) {
+		clog := &testutil.CaptureLogger{}
+		s := &Serializer{
+			Log:           clog,
+			WriteMetadata: true,
+		}
+		require.NoError(t, s.Init())
+
+		data, err := s.SerializeBatch(metrics)
+		require.NoError(t, err)
+		req, err := DecodePayload(data)
+		require.NoError(t, err)
+		require.Equal(t, "cpu_time_idle{host=\"a\"} 42", strings.TrimSpace(RenderText(req)))
+		require.Len(t, req.Metadata, 1)
+		require.Equal(t, prompb.MetricMetadata_COUNTER, req.Metadata[0].Type)
+
+		warnings := clog.Warnings()
+		require.Len(t, warnings, 1)
+		require.Contains(t, warnings[0], `resolved conflicting metric types of series ["cpu_time_idle"] using policy "first-wins"`)
+	})
+
+	t.Run("error", func(t *testing.T) {
+		s := &Serializer{
+			Log:                &testutil.CaptureLogger{},
+			TypeConflictPolicy: "error",
+		}
+		require.NoError(t, s.Init())
+
+		_, err := s.SerializeBatch(metrics)
+		require.ErrorContains(t, err, `conflicting metric types of series ["cpu_time_idle"]`)
+	})
+}
+
+func TestRemoteWriteSerializeMetadataConflict(t *testing.T) {
+	metrics := []telegraf.Metric{
+		testutil.MustMetric(
+			"cpu",
+			map[string]string{"host": "a"},
+			map[string]interface{}{"time_idle": 42.0},
+			time.Unix(0, 0),
+			telegraf.Counter,
+		),
+		testutil.MustMetric(
+			"cpu",
+			map[string]string{"host": "b"},
+			map[string]interface{}{"time_idle": 43.0},
+			time.Unix(0, 0),
+			telegraf.Gauge,
+		),
+		testutil.MustMetric(
+			"cpu",
+			map[string]string{"host": "c"},
+			map[string]interface{}{"time_idle": 44.0},
+			time.Unix(0, 0),
+			telegraf.Gauge,
+		),
+	}
+
+	tests := []struct {
+		name        string
+		policy      string
+		concurrency int
+		expected    prompb.MetricMetadata_MetricType
+	}{
+		{
+			name:     "first-wins",
+			policy:   "first-wins",
+			expected: prompb.MetricMetadata_COUNTER,
+		},
+		{
+			name:        "first-wins concurrent",
+			policy:      "first-wins",
+			concurrency: 3,
+			expected:    prompb.MetricMetadata_COUNTER,
+		},
+		{
+			name:     "most-common",
+			policy:   "most-common",
+			expected: prompb.MetricMetadata_GAUGE,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clog := &testutil.CaptureLogger{}
+			s := &Serializer{
+				Log:                    clog,
+				Protocol:               "2.0",
+				WriteMetadata:          true,
+				MetadataConflictPolicy: tt.policy,
+				Concurrency:            tt.concurrency,
+			}
+			require.NoError(t, s.Init())
+
+			series, _, err := s.assemble(metrics)
+			require.NoError(t, err)
+			require.Len(t, series, 3)
+			for _, ts := range series {
+				require.Equal(t, tt.expected, ts.metadata.Type)
+			}
+
+			warnings := clog.Warnings()
+			require.Len(t, warnings, 1)
+			require.Contains(t, warnings[0],
+				fmt.Sprintf(`resolved conflicting metadata types of metric families ["cpu_time_idle"] using policy %q`, tt.policy))
+		})
+	}
+
+	t.Run("error", func(t *testing.T) {
+		s := &Serializer{
+			Log:                    &testutil.CaptureLogger{},
+			MetadataConflictPolicy: "error",
+		}
+		require.NoError(t, s.Init())
+
+		_, err := s.SerializeBatch(metrics)
+		require.ErrorContains(t, err, `conflicting metadata types of metric families ["cpu_time_idle"]`)
+	})
+}
+
+func TestRemoteWriteSerializeValuePrecision(t *testing.T) {
+	metrics := []telegraf.Metric{
+		testutil.MustMetric(
+			"sensor",
+			map[string]string{},
+			map[string]interface{}{
+				"temperature": 21.456789,
+				"pressure":    101325.7,
+				"humidity":    0.0012345,
+				"zero":        0.0,
+			},
+			time.Unix(0, 0),
+			telegraf.Gauge,
+		),
+		testutil.MustMetric(
+			"net",
+			map[string]string{},
+			map[string]interface{}{"bytes_recv": 123456789},
+			time.Unix(0, 0),
+			telegraf.Counter,
+		),
+		testutil.MustMetric(
+			"prometheus",
+			map[string]string{"le": "0.5"},
+			map[string]interface{}{"http_request_duration_seconds_bucket": 129389.0},
+			time.Unix(0, 0),
+			telegraf.Histogram,
+		),
+	}
+
+	s := &Serializer{
+		Log:            &testutil.CaptureLogger{},
+		SortMetrics:    true,
+		ValuePrecision: 3,
+	}
+	require.NoError(t, s.Init())
+
+	data, err := s.SerializeBatch(metrics)
+	require.NoError(t, err)
+	actual, err := prompbToText(data)
+	require.NoError(t, err)
+
+	expected := `
+http_request_duration_seconds_count 0
+http_request_duration_seconds_sum 0
+net_bytes_recv 123000000
+sensor_humidity 0.00123
+sensor_pressure 101000
+sensor_temperature 21.5
+sensor_zero 0
+http_request_duration_seconds_bucket{le="+Inf"} 0
+http_request_duration_seconds_bucket{le="0.5"} 129389
+`
+	require.Equal(t, strings.TrimSpace(expected), strings.TrimSpace(string(actual)))
+}
+
+func TestRemoteWriteSerializeBatchFor(t *testing.T) {
+	metrics := []telegraf.Metric{
+		testutil.MustMetric(
+			"cpu",
+			map[string]string{"host": "a", "cluster": "local"},
+			map[string]interface{}{"time_idle": 42.0},
+			time.Unix(0, 0),
+		),
+		testutil.MustMetric(
+			"mem",
+			map[string]string{"host": "a"},
+			map[string]interface{}{"used": 10.0},
+			time.Unix(0, 0),
+		),
+	}
+
+	s := &Serializer{
+		Log:         &testutil.CaptureLogger{},
+		SortMetrics: true,
+		LabelSets: map[string]map[string]string{
+			"primary": {"cluster": "eu-1", "replica": "0"},
+			"backup":  {"cluster": "us-1"},
+		},
+	}
+	require.NoError(t, s.Init())
+
+	tests := []struct {
+		id       string
+		expected string
+	}{
+		{
+			id: "primary",
+			expected: `
+cpu_time_idle{cluster="eu-1", host="a", replica="0"} 42
+mem_used{cluster="eu-1", host="a", replica="0"} 10
+`,
+		},
+		{
+			id: "backup",
+			expected: `
+cpu_time_idle{cluster="us-1", host="a"} 42
+mem_used{cluster="us-1", host="a"} 10
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.id, func(t *testing.T) {
+			data, err := s.SerializeBatchFor(tt.id, metrics)
+			require.NoError(t, err)
+			actual, err := prompbToText(data)
+			require.NoError(t, err)
+			require.Equal(t, strings.TrimSpace(tt.expected), strings.TrimSpace(string(actual)))
+		})
+	}
+
+	_, err := s.SerializeBatchFor("unknown", metrics)
+	require.ErrorContains(t, err, `no label set for destination "unknown"`)
+}
+
+func TestRemoteWriteSerializeBatchForLabelProcessing(t *testing.T) {
+	tagged := testutil.MustMetric(
+		"cpu",
+		map[string]string{"host": "a", "cluster": "eu-1", "replica": "0"},
+		map[string]interface{}{"time_idle": 42.0},
+		time.Unix(0, 0),
+	)
+	untagged := testutil.MustMetric(
+		"cpu",
+		map[string]string{"host": "a"},
+		map[string]interface{}{"time_idle": 42.0},
+		time.Unix(0, 0),
+	)
+
+	// The labels of the label set must be identified by the series ID and
+	// count towards the label limit like tags of the metric.
+	s := &Serializer{
+		Log:                &testutil.CaptureLogger{},
+		EmitSeriesID:       true,
+		MaxLabelsPerSeries: 3,
+		LabelSets: map[string]map[string]string{
+			"primary": {"cluster": "eu-1", "replica": "0"},
+		},
+	}
+	require.NoError(t, s.Init())
+
+	data, err := s.SerializeBatch([]telegraf.Metric{tagged})
+	require.NoError(t, err)
+	expected, err := DecodePayload(data)
+	require.NoError(t, err)
+
+	data, err = s.SerializeBatchFor("primary", []telegraf.Metric{untagged})
+	require.NoError(t, err)
+	actual, err := DecodePayload(data)
+	require.NoError(t, err)
+
+	require.Equal(t, expected.Timeseries, actual.Timeseries)
+	require.Len(t, actual.Timeseries, 1)
+	require.False(t, hasLabel("replica", actual.Timeseries[0].Labels))
+	require.True(t, hasLabel(seriesIDLabel, actual.Timeseries[0].Labels))
+}
+
+func TestRemoteWriteSerializeReservedNames(t *testing.T) {
+	metrics := []telegraf.Metric{
+		testutil.MustMetric(
+			"prometheus",
+			map[string]string{"host": "a"},
+			map[string]interface{}{"up": 1.0},
+			time.Unix(0, 0),
+			telegraf.Gauge,
+		),
+		testutil.MustMetric(
+			"cpu",
+			map[string]string{"host": "a"},
+			map[string]interface{}{"time_idle": 42.0},
+			time.Unix(0, 0),
+		),
+	}
+
+	tests := []struct {
+		name     string
+		policy   string
+		expected string
+		warning  string
+	}{
+		{
+			name:   "warn",
+			policy: "warn",
+			expected: `
+cpu_time_idle{host="a"} 42
+up{host="a"} 1
+`,
+			warning: `metric names ["up"] collide with reserved names`,
+		},
+		{
+			name:   "prefix",
+			policy: "prefix",
+			expected: `
+cpu_time_idle{host="a"} 42
+telegraf_up{host="a"} 1
+`,
+			warning: `prefixed reserved metric names ["up"] with "telegraf_"`,
+		},
+		{
+			name:   "drop",
+			policy: "drop",
+			expected: `
+cpu_time_idle{host="a"} 42
+`,
+			warning: `dropped series with reserved metric names ["up"]`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clog := &testutil.CaptureLogger{}
+			s := &Serializer{
+				Log:                clog,
+				SortMetrics:        true,
+				ReservedNamePolicy: tt.policy,
+			}
+			require.NoError(t, s.Init())
+
+			data, err := s.SerializeBatch(metrics)
+			require.NoError(t, err)
+			actual, err := prompbToText(data)
+			require.NoError(t, err)
+			require.Equal(t, strings.TrimSpace(tt.expected), strings.TrimSpace(string(actual)))
+
+			warnings := clog.Warnings()
+			require.Len(t, warnings, 1)
+			require.Contains(t, warnings[0], tt.warning)
+		})
+	}
+}
+
+func TestRemoteWriteSerializeCustomReservedNames(t *testing.T) {
+	m := testutil.MustMetric(
+		"cpu",
+		map[string]string{},
+		map[string]interface{}{"time_idle": 42.0},
+		time.Unix(0, 0),
+	)
+
+	s := &Serializer{
+		Log:                &testutil.CaptureLogger{},
+		ReservedNames:      []string{"cpu_time_idle"},
+		ReservedNamePolicy: "prefix",
+		ReservedNamePrefix: "host_",
+	}
+	require.NoError(t, s.Init())
+
+	data, err := s.Serialize(m)
+	require.NoError(t, err)
+	actual, err := prompbToText(data)
+	require.NoError(t, err)
+	require.Equal(t, "host_cpu_time_idle 42", strings.TrimSpace(string(actual)))
+}
+
+func TestRemoteWriteSerializeNormalizeNumericLabels(t *testing.T) {
+	metrics := []telegraf.Metric{
+		testutil.MustMetric(
+			"model",
+			map[string]string{"learning_rate": "1e-05", "version": "1e-05"},
+			map[string]interface{}{"loss": 0.5},
+			time.Unix(0, 0),
+		),
+		testutil.MustMetric(
+			"model",
+			map[string]string{"learning_rate": "0.000010", "version": "2.0"},
+			map[string]interface{}{"loss": 0.25},
+			time.Unix(1, 0),
+		),
+		testutil.MustMetric(
+			"model",
+			map[string]string{"learning_rate": "auto", "version": "0x1"},
+			map[string]interface{}{"loss": 0.75},
+			time.Unix(0, 0),
+		),
+	}
+
+	s := &Serializer{
+		Log:                    &testutil.CaptureLogger{},
+		SortMetrics:            true,
+		NormalizeNumericLabels: []string{"learning_rate"},
+	}
+	require.NoError(t, s.Init())
+
+	data, err := s.SerializeBatch(metrics)
+	require.NoError(t, err)
+	actual, err := prompbToText(data)
+	require.NoError(t, err)
+	expected := `
+model_loss{learning_rate="0.00001", version="1e-05"} 0.5
+model_loss{learning_rate="0.00001", version="2.0"} 0.25
+model_loss{learning_rate="auto", version="0x1"} 0.75
+`
+	require.Equal(t, strings.TrimSpace(expected), strings.TrimSpace(string(actual)))
+}
+
+func TestRemoteWriteSerializeNormalizeNumericBoundaries(t *testing.T) {
+	metrics := []telegraf.Metric{
+		testutil.MustMetric(
+			"latency",
+			map[string]string{"le": "0.00001"},
+			map[string]interface{}{"seconds_bucket": 3.0},
+			time.Unix(0, 0),
+			telegraf.Histogram,
+		),
+		testutil.MustMetric(
+			"rpc",
+			map[string]string{"quantile": "0.00001"},
+			map[string]interface{}{"seconds": 0.25},
+			time.Unix(0, 0),
+			telegraf.Summary,
+		),
+	}
+
+	tests := []struct {
+		name      string
+		normalize []string
+		expected  []string
+	}{
+		{
+			name: "default",
+			expected: []string{
+				`latency_seconds_bucket{le="1e-05"} 3`,
+				`rpc_seconds{quantile="1e-05"} 0.25`,
+			},
+		},
+		{
+			name:      "normalized",
+			normalize: []string{"le", "quantile"},
+			expected: []string{
+				`latency_seconds_bucket{le="0.00001"} 3`,
+				`rpc_seconds{quantile="0.00001"} 0.25`,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Serializer{
+				Log:                    &testutil.CaptureLogger{},
+				NormalizeNumericLabels: tt.normalize,
+			}
+			require.NoError(t, s.Init())
+
+			data, err := s.SerializeBatch(metrics)
+			require.NoError(t, err)
+			actual, err := prompbToText(data)
+			require.NoError(t, err)
+			for _, line := range tt.expected {
+				require.Contains(t, string(actual), line+"\n")
+			}
+		})
+	}
+}
+
+func TestRemoteWriteCanonicalNumber(t *testing.T) {
+	tests := map[string]string{
+		"1e-05":  "0.00001",
+		"1.50":   "1.5",
+		"-2E3":   "-2000",
+		"42":     "42",
+		"+Inf":   "+Inf",
+		"NaN":    "NaN",
+		"0x1p-2": "0x1p-2",
+		"1_000":  "1_000",
+		"eth0":   "eth0",
+	}
+	for value, expected := range tests {
+		require.Equal(t, expected, canonicalNumber(value), value)
+	}
+}
+
+func TestRemoteWriteMetricUnit(t *testing.T) {
+	tests := []struct {
+		name       string
+		metricType prompb.MetricMetadata_MetricType
+		expected   string
+	}{
+		{name: "process_cpu_seconds_total", metricType: prompb.MetricMetadata_COUNTER, expected: "seconds"},
+		{name: "node_memory_free_bytes", metricType: prompb.MetricMetadata_GAUGE, expected: "bytes"},
+		{name: "http_request_duration_seconds_bucket", metricType: prompb.MetricMetadata_HISTOGRAM, expected: "seconds"},
+		{name: "http_request_duration_seconds_sum", metricType: prompb.MetricMetadata_HISTOGRAM, expected: "seconds"},
+		{name: "rpc_duration_milliseconds_count", metricType: prompb.MetricMetadata_SUMMARY, expected: "milliseconds"},
+		{name: "disk_used_ratio", metricType: prompb.MetricMetadata_UNKNOWN, expected: "ratio"},
+		{name: "cpu_time_idle", metricType: prompb.MetricMetadata_UNKNOWN, expected: ""},
+		{name: "queue_bytes_count", metricType: prompb.MetricMetadata_GAUGE, expected: ""},
+		{name: "bytes", metricType: prompb.MetricMetadata_GAUGE, expected: ""},
+	}
+	for _, tt := range tests {
+		require.Equal(t, tt.expected, metricUnit(tt.name, tt.metricType), tt.name)
+	}
+}
+
+func TestRemoteWriteSerializeUnitLabel(t *testing.T) {
+	metrics := []telegraf.Metric{
+		testutil.MustMetric(
+			"net",
+			map[string]string{"interface": "eth0"},
+			map[string]interface{}{"received_bytes_total": 1024.0},
+			time.Unix(0, 0),
+			telegraf.Counter,
+		),
+		testutil.MustMetric(
+			"prometheus",
+			map[string]string{"le": "+Inf"},
+			map[string]interface{}{"http_request_duration_seconds_bucket": 10.0},
+			time.Unix(0, 0),
+			telegraf.Histogram,
+		),
+		testutil.MustMetric(
+			"prometheus",
+			map[string]string{},
+			map[string]interface{}{
+				"http_request_duration_seconds_sum":   3.0,
+				"http_request_duration_seconds_count": 10.0,
+			},
+			time.Unix(0, 0),
+			telegraf.Histogram,
+		),
+		testutil.MustMetric(
+			"cpu",
+			map[string]string{},
+			map[string]interface{}{"time_idle": 42.0},
+			time.Unix(0, 0),
+		),
+	}
+
+	s := &Serializer{
+		Log:           &testutil.CaptureLogger{},
+		SortMetrics:   true,
+		EmitUnitLabel: true,
+	}
+	require.NoError(t, s.Init())
+
+	data, err := s.SerializeBatch(metrics)
+	require.NoError(t, err)
+	actual, err := prompbToText(data)
+	require.NoError(t, err)
+
+	expected := `
+cpu_time_idle 42
+http_request_duration_seconds_count{__unit__="seconds"} 10
+http_request_duration_seconds_sum{__unit__="seconds"} 3
+http_request_duration_seconds_bucket{__unit__="seconds", le="+Inf"} 10
+net_received_bytes_total{__unit__="bytes", interface="eth0"} 1024
+`
+	require.Equal(t, strings.TrimSpace(expected), strings.TrimSpace(string(actual)))
+}
+
+func TestRemoteWriteScaleUnit(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected string
+		exponent int
+		found    bool
+	}{
+		{name: "rpc_duration_milliseconds", expected: "rpc_duration_seconds", exponent: -3, found: true},
+		{name: "gc_pause_nanoseconds_total", expected: "gc_pause_seconds_total", exponent: -9, found: true},
+		{name: "disk_free_megabytes", expected: "disk_free_bytes", exponent: 6, found: true},
+		{name: "cpu_usage_percent", expected: "cpu_usage_ratio", exponent: -2, found: true},
+		{name: "rpc_duration_seconds", expected: "rpc_duration_seconds"},
+		{name: "milliseconds", expected: "milliseconds"},
+		{name: "cpu_time_idle", expected: "cpu_time_idle"},
+	}
+	for _, tt := range tests {
+		actual, exponent, found := scaleUnit(tt.name)
+		require.Equal(t, tt.expected, actual, tt.name)
+		require.Equal(t, tt.exponent, exponent, tt.name)
+		require.Equal(t, tt.found, found, tt.name)
+	}
+}
+
+func TestRemoteWriteSerializeAutoScaleUnits(t *testing.T) {
+	metrics := []telegraf.Metric{
+		testutil.MustMetric(
+			"rpc",
+			map[string]string{},
+			map[string]interface{}{"duration_milliseconds": 250.0},
+			time.Unix(0, 0),
+			telegraf.Gauge,
+		),
+		testutil.MustMetric(
+			"gc",
+			map[string]string{},
+			map[string]interface{}{"pause_microseconds_total": 1500.0},
+			time.Unix(0, 0),
+			telegraf.Counter,
+		),
+		testutil.MustMetric(
+			"prometheus",
+			map[string]string{"le": "+Inf"},
+			map[string]interface{}{"http_request_duration_milliseconds_bucket": 10.0},
+			time.Unix(0, 0),
+			telegraf.Histogram,
+		),
+	}
+
+	s := &Serializer{
+		Log:            &testutil.CaptureLogger{},
+		SortMetrics:    true,
+		WriteMetadata:  true,
+		EmitUnitLabel:  true,
+		AutoScaleUnits: true,
+	}
+	require.NoError(t, s.Init())
+
+	data, err := s.SerializeBatch(metrics)
+	require.NoError(t, err)
+	req, err := DecodePayload(data)
+	require.NoError(t, err)
+
+	expected := `
+gc_pause_seconds_total{__unit__="seconds"} 0.0015
+http_request_duration_milliseconds_count{__unit__="milliseconds"} 0
+http_request_duration_milliseconds_sum{__unit__="milliseconds"} 0
+rpc_duration_seconds{__unit__="seconds"} 0.25
+http_request_duration_milliseconds_bucket{__unit__="milliseconds", le="+Inf"} 10
+`
+	require.Equal(t, strings.TrimSpace(expected), strings.TrimSpace(RenderText(req)))
+
+	families := make([]string, 0, len(req.Metadata))
+	for _, m := range req.Metadata {
+		families = append(families, m.MetricFamilyName)
+	}
+	require.ElementsMatch(t, []string{"gc_pause_seconds_total", "http_request_duration_milliseconds", "rpc_duration_seconds"}, families)
+}
+
+func TestRemoteWriteSerializeLabelValueCase(t *testing.T) {
+	metrics := []telegraf.Metric{
+		testutil.MustMetric(
+			"http",
+			map[string]string{"method": "GET", "path": "/Index"},
+			map[string]interface{}{"requests": 1.0},
+			time.Unix(0, 0),
+		),
+		testutil.MustMetric(
+			"http",
+			map[string]string{"method": "get", "path": "/index"},
+			map[string]interface{}{"requests": 2.0},
+			time.Unix(1, 0),
+		),
+		testutil.MustMetric(
+			"http",
+			map[string]string{"method": "Post", "path": "/Index"},
+			map[string]interface{}{"requests": 3.0},
+			time.Unix(0, 0),
+		),
+	}
+
+	tests := []struct {
+		name      string
+		valueCase string
+		expected  string
+	}{
+		{
+			name: "none",
+			expected: `
+http_requests{method="GET", path="/Index"} 1
+http_requests{method="Post", path="/Index"} 3
+http_requests{method="get", path="/index"} 2
+`,
+		},
+		{
+			name:      "lower",
+			valueCase: "lower",
+			expected: `
+http_requests{method="get", path="/Index"} 1
+http_requests{method="get", path="/index"} 2
+http_requests{method="post", path="/Index"} 3
+`,
+		},
+		{
+			name:      "upper",
+			valueCase: "upper",
+			expected: `
+http_requests{method="GET", path="/Index"} 1
+http_requests{method="GET", path="/index"} 2
+http_requests{method="POST", path="/Index"} 3
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Serializer{
+				Log:                  &testutil.CaptureLogger{},
+				SortMetrics:          true,
+				LabelValueCase:       tt.valueCase,
+				LabelValueCaseLabels: []string{"method"},
+			}
+			require.NoError(t, s.Init())
+
+			data, err := s.SerializeBatch(metrics)
+			require.NoError(t, err)
+			actual, err := prompbToText(data)
+			require.NoError(t, err)
+			require.Equal(t, strings.TrimSpace(tt.expected), strings.TrimSpace(string(actual)))
+		})
+	}
+}
+
+func TestRemoteWriteSerializeDifferentTimestamps(t *testing.T) {
+	tests := []struct {
+		name               string
+		summaryToHistogram bool
+		metrics            []telegraf.Metric
+		expected           []string
+	}{
+		{
+			name: "fields of same series",
+			metrics: []telegraf.Metric{
+				testutil.MustMetric(
+					"cpu",
+					map[string]string{"host": "a"},
+					map[string]interface{}{"time_idle": 3.0},
+					time.Unix(20, 0),
+				),
+				testutil.MustMetric(
+					"cpu",
+					map[string]string{"host": "a"},
+					map[string]interface{}{"time_idle": 1.0, "time_user": 2.0},
+					time.Unix(10, 0),
+				),
+			},
+			expected: []string{
+				`cpu_time_idle{host="a"} 3 @20000`,
+				`cpu_time_user{host="a"} 2 @10000`,
+			},
+		},
+		{
+			name: "histogram buckets newer than count",
+			metrics: []telegraf.Metric{
+				testutil.MustMetric(
+					"prometheus",
+					map[string]string{"le": "+Inf"},
+					map[string]interface{}{"http_request_duration_seconds_bucket": 0.0},
+					time.Unix(20, 0),
+					telegraf.Histogram,
+				),
+				testutil.MustMetric(
+					"prometheus",
+					map[string]string{},
+					map[string]interface{}{
+						"http_request_duration_seconds_sum":   1.5,
+						"http_request_duration_seconds_count": 1.0,
+					},
+					time.Unix(10, 0),
+					telegraf.Histogram,
+				),
+			},
+			expected: []string{
+				`http_request_duration_seconds_count 1 @10000`,
+				`http_request_duration_seconds_sum 1.5 @10000`,
+				`http_request_duration_seconds_bucket{le="+Inf"} 0 @20000`,
+			},
+		},
+		{
+			name:               "summary quantiles older than count",
+			summaryToHistogram: true,
+			metrics: []telegraf.Metric{
+				testutil.MustMetric(
+					"prometheus",
+					map[string]string{"quantile": "0.5"},
+					map[string]interface{}{"rpc_duration_seconds": 0.2},
+					time.Unix(10, 0),
+					telegraf.Summary,
+				),
+				testutil.MustMetric(
+					"prometheus",
+					map[string]string{},
+					map[string]interface{}{
+						"rpc_duration_seconds_sum":   1.0,
+						"rpc_duration_seconds_count": 4.0,
+					},
+					time.Unix(10, 0),
+					telegraf.Summary,
+				),
+				testutil.MustMetric(
+					"prometheus",
+					map[string]string{},
+					map[string]interface{}{
+						"rpc_duration_seconds_sum":   10.0,
+						"rpc_duration_seconds_count": 40.0,
+					},
+					time.Unix(20, 0),
+					telegraf.Summary,
+				),
+			},
+			expected: []string{
+				`rpc_duration_seconds_count 40 @20000`,
+				`rpc_duration_seconds_sum 10 @20000`,
+				`rpc_duration_seconds_bucket{le="+Inf"} 40 @20000`,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Serializer{
+				Log:                &testutil.CaptureLogger{},
+				SortMetrics:        true,
+				SummaryToHistogram: tt.summaryToHistogram,
+			}
+			require.NoError(t, s.Init())
+
+			data, err := s.SerializeBatch(tt.metrics)
+			require.NoError(t, err)
+			req, err := DecodePayload(data)
+			require.NoError(t, err)
+
+			actual := make([]string, 0, len(req.Timeseries))
+			for _, ts := range req.Timeseries {
+				require.Len(t, ts.Samples, 1)
+				metric := make(model.Metric, len(ts.Labels))
+				for _, l := range ts.Labels {
+					metric[model.LabelName(l.Name)] = model.LabelValue(l.Value)
+				}
+				actual = append(actual, fmt.Sprintf("%s %v @%d", metric, ts.Samples[0].Value, ts.Samples[0].Timestamp))
+			}
+			require.Equal(t, tt.expected, actual)
+		})
+	}
+}
+
+func prompbToText(data []byte) ([]byte, error) {
+	req, err := DecodePayload(data)
+	if err != nil {
+		return nil, err
 	}
-	return samples
+	return []byte(RenderText(req)), nil
 }
 
 func BenchmarkSerialize(b *testing.B) {
@@ -0,0 +1,302 @@
+package prometheusremotewrite
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/golang/snappy"
+	"github.com/prometheus/prometheus/prompb"
+	writev2 "github.com/prometheus/prometheus/prompb/io/prometheus/write/v2"
+
+	"github.com/influxdata/telegraf"
+)
+
+// remoteWriteVersion returns the version of the remote write protocol as
+// announced in the "X-Prometheus-Remote-Write-Version" header.
+func (s *Serializer) remoteWriteVersion() string {
+	if s.Protocol == "2.0" {
+		return "2.0.0"
+	}
+	return "0.1.0"
+}
+
+// protocolLabel is the label carrying the configured protocol version
+const protocolLabel = "__rw_version__"
+
+// protocol returns the configured protocol version defaulting to 1.0
+func (s *Serializer) protocol() string {
+	if s.Protocol == "" {
+		return "1.0"
+	}
+	return s.Protocol
+}
+
+// addProtocolLabel adds the protocol label with the given version to all
+// given series keeping the labels sorted.
+func addProtocolLabel(series []timeSeries, version string) {
+	for i := range series {
+		labels := replaceLabel(series[i].Labels, protocolLabel, version)
+		sort.Sort(sortableLabels(labels))
+		series[i].Labels = labels
+	}
+}
+
+// encode marshals the given series according to the configured protocol and
+// compresses the result.
+func (s *Serializer) encode(series []timeSeries) ([]byte, error) {
+	if s.omitPayload(series) {
+		return nil, nil
+	}
+	data, err := s.marshal(series)
+	if err != nil {
+		return nil, err
+	}
+	return s.compress(data), nil
+}
+
+// omitPayload checks if no payload should be created for the given series as
+// the batch is empty and the empty batch policy asks for omitting it.
+func (s *Serializer) omitPayload(series []timeSeries) bool {
+	return len(series) == 0 && s.EmptyBatchPolicy == "nil"
+}
+
+// SerializeBatchMulti serializes the given metrics for both the remote-write
+// 1.0 and 2.0 protocol, e.g. to serve receivers of both versions during a
+// migration. The metrics are converted only once and the resulting series are
+// encoded for each protocol. The payloads are returned keyed by the protocol
+// version.
+func (s *Serializer) SerializeBatchMulti(metrics []telegraf.Metric) (map[string][]byte, error) {
+	series, _, err := s.assemble(metrics)
+	if err != nil {
+		return nil, err
+	}
+
+	payloads := make(map[string][]byte, 2)
+	for _, protocol := range []string{"1.0", "2.0"} {
+		data, err := s.marshalProtocol(protocol, series)
+		if err != nil {
+			return nil, err
+		}
+		payloads[protocol] = s.compress(data)
+	}
+	return payloads, nil
+}
+
+// marshal creates the uncompressed protobuf payload of the given series
+// according to the configured protocol.
+func (s *Serializer) marshal(series []timeSeries) ([]byte, error) {
+	return s.marshalProtocol(s.Protocol, series)
+}
+
+// marshalProtocol creates the uncompressed protobuf payload of the given
+// series according to the given protocol.
+func (s *Serializer) marshalProtocol(protocol string, series []timeSeries) ([]byte, error) {
+	var data []byte
+	var err error
+	switch protocol {
+	case "2.0":
+		data, err = s.marshalV2(series)
+	default:
+		data, err = s.marshalV1(series)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to marshal protobuf: %w", err)
+	}
+	return data, nil
+}
+
+// compress compresses the given payload as required by the remote-write
+// protocol
+func (s *Serializer) compress(data []byte) []byte {
+	return snappy.Encode(nil, data)
+}
+
+// marshalV1 creates a remote-write 1.0 request. Metadata is written once per
+// metric family unless it is sent in a separate request.
+func (s *Serializer) marshalV1(series []timeSeries) ([]byte, error) {
+	req := &prompb.WriteRequest{Timeseries: make([]prompb.TimeSeries, 0, len(series))}
+	for _, ts := range series {
+		req.Timeseries = append(req.Timeseries, ts.TimeSeries)
+	}
+
+	if s.WriteMetadata && !s.SeparateMetadataRequest {
+		req.Metadata = s.familyMetadata(series)
+	}
+
+	return req.Marshal()
+}
+
+// encodeMetadata creates a compressed remote-write 1.0 request containing
+// the metadata of the given series only.
+func (s *Serializer) encodeMetadata(series []timeSeries) ([]byte, error) {
+	req := &prompb.WriteRequest{Metadata: s.familyMetadata(series)}
+	data, err := req.Marshal()
+	if err != nil {
+		return nil, fmt.Errorf("unable to marshal protobuf: %w", err)
+	}
+	return s.compress(data), nil
+}
+
+// familyMetadata returns the metadata of the given series once per metric
+// family sorted by family name with the first occurrence of a family being
+// authoritative. The metadata is limited to the configured maximum number of
+// entries.
+func (s *Serializer) familyMetadata(series []timeSeries) []prompb.MetricMetadata {
+	kept := s.metadataFamilies(series)
+
+	var metadata []prompb.MetricMetadata
+	seen := make(map[string]bool)
+	for _, ts := range series {
+		if seen[ts.metadata.MetricFamilyName] {
+			continue
+		}
+		seen[ts.metadata.MetricFamilyName] = true
+		if kept != nil && !kept[ts.metadata.MetricFamilyName] {
+			continue
+		}
+		metadata = append(metadata, ts.metadata)
+	}
+	sort.SliceStable(metadata, func(i, j int) bool {
+		return metadata[i].MetricFamilyName < metadata[j].MetricFamilyName
+	})
+	return metadata
+}
+
+// marshalV2 creates a remote-write 2.0 request with all strings being
+// referenced via the symbols table. Metadata is attached to each series.
+func (s *Serializer) marshalV2(series []timeSeries) ([]byte, error) {
+	var kept map[string]bool
+	if s.WriteMetadata {
+		kept = s.metadataFamilies(series)
+	}
+
+	symbols := writev2.NewSymbolTable()
+	req := &writev2.Request{Timeseries: make([]writev2.TimeSeries, 0, len(series))}
+	for _, ts := range series {
+		v2 := writev2.TimeSeries{
+			LabelsRefs: symbolizeLabels(&symbols, ts.Labels),
+			Samples:    make([]writev2.Sample, 0, len(ts.Samples)),
+		}
+		for _, sample := range ts.Samples {
+			v2.Samples = append(v2.Samples, writev2.Sample{Value: sample.Value, Timestamp: sample.Timestamp})
+		}
+		for _, exemplar := range ts.Exemplars {
+			v2.Exemplars = append(v2.Exemplars, writev2.Exemplar{
+				LabelsRefs: symbolizeLabels(&symbols, exemplar.Labels),
+				Value:      exemplar.Value,
+				Timestamp:  exemplar.Timestamp,
+			})
+		}
+		if s.WriteMetadata && (kept == nil || kept[ts.metadata.MetricFamilyName]) {
+			v2.Metadata = writev2.Metadata{Type: metadataTypeV2(ts.metadata.Type)}
+			if ts.metadata.Help != "" {
+				v2.Metadata.HelpRef = symbols.Symbolize(ts.metadata.Help)
+			}
+			if ts.metadata.Unit != "" {
+				v2.Metadata.UnitRef = symbols.Symbolize(ts.metadata.Unit)
+			}
+		}
+		req.Timeseries = append(req.Timeseries, v2)
+	}
+	req.Symbols = symbols.Symbols()
+
+	return req.Marshal()
+}
+
+// metadataFamilies returns the metric families to write metadata for if the
+// number of families exceeds the configured maximum of metadata entries. The
+// families with the most series are kept, nil is returned if all families are
+// kept.
+func (s *Serializer) metadataFamilies(series []timeSeries) map[string]bool {
+	if s.MaxMetadataEntries <= 0 {
+		return nil
+	}
+
+	counts := make(map[string]int)
+	for _, ts := range series {
+		counts[ts.metadata.MetricFamilyName]++
+	}
+	if len(counts) <= s.MaxMetadataEntries {
+		return nil
+	}
+
+	families := make([]string, 0, len(counts))
+	for family := range counts {
+		families = append(families, family)
+	}
+	sort.Slice(families, func(i, j int) bool {
+		if counts[families[i]] != counts[families[j]] {
+			return counts[families[i]] > counts[families[j]]
+		}
+		return families[i] < families[j]
+	})
+
+	kept := make(map[string]bool, s.MaxMetadataEntries)
+	for _, family := range families[:s.MaxMetadataEntries] {
+		kept[family] = true
+	}
+	s.Log.Debugf("dropped metadata of %d metric families exceeding the limit of %d entries", len(families)-s.MaxMetadataEntries, s.MaxMetadataEntries)
+	return kept
+}
+
+func symbolizeLabels(symbols *writev2.SymbolsTable, labels []prompb.Label) []uint32 {
+	refs := make([]uint32, 0, 2*len(labels))
+	for _, label := range labels {
+		refs = append(refs, symbols.Symbolize(label.Name), symbols.Symbolize(label.Value))
+	}
+	return refs
+}
+
+func metadataType(valueType telegraf.ValueType) prompb.MetricMetadata_MetricType {
+	switch valueType {
+	case telegraf.Counter:
+		return prompb.MetricMetadata_COUNTER
+	case telegraf.Gauge:
+		return prompb.MetricMetadata_GAUGE
+	case telegraf.Histogram:
+		return prompb.MetricMetadata_HISTOGRAM
+	case telegraf.Summary:
+		return prompb.MetricMetadata_SUMMARY
+	default:
+		return prompb.MetricMetadata_UNKNOWN
+	}
+}
+
+func metadataTypeV2(metricType prompb.MetricMetadata_MetricType) writev2.Metadata_MetricType {
+	switch metricType {
+	case prompb.MetricMetadata_COUNTER:
+		return writev2.Metadata_METRIC_TYPE_COUNTER
+	case prompb.MetricMetadata_GAUGE:
+		return writev2.Metadata_METRIC_TYPE_GAUGE
+	case prompb.MetricMetadata_HISTOGRAM:
+		return writev2.Metadata_METRIC_TYPE_HISTOGRAM
+	case prompb.MetricMetadata_GAUGEHISTOGRAM:
+		return writev2.Metadata_METRIC_TYPE_GAUGEHISTOGRAM
+	case prompb.MetricMetadata_SUMMARY:
+		return writev2.Metadata_METRIC_TYPE_SUMMARY
+	case prompb.MetricMetadata_INFO:
+		return writev2.Metadata_METRIC_TYPE_INFO
+	case prompb.MetricMetadata_STATESET:
+		return writev2.Metadata_METRIC_TYPE_STATESET
+	default:
+		return writev2.Metadata_METRIC_TYPE_UNSPECIFIED
+	}
+}
+
+// metricMetadata returns the metadata of the family with the given name
+// converted from the metric including the help text if any.
+func (s *Serializer) metricMetadata(metric telegraf.Metric, metricName string) prompb.MetricMetadata {
+	metadata := prompb.MetricMetadata{
+		Type:             metadataType(metric.Type()),
+		MetricFamilyName: metricName,
+	}
+	if s.HelpTag != "" {
+		metadata.Help, _ = metric.GetTag(s.HelpTag)
+	}
+	if metadata.Help == "" && s.SynthesizeHelp {
+		if origin, found := metric.GetTag(s.originTag()); found && origin != "" {
+			metadata.Help = "from telegraf input " + origin
+		}
+	}
+	return metadata
+}
@@ -0,0 +1,468 @@
+package prometheusremotewrite
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/prompb"
+	writev2 "github.com/prometheus/prometheus/prompb/io/prometheus/write/v2"
+	"github.com/stretchr/testify/require"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/testutil"
+)
+
+var protocolTestMetrics = []telegraf.Metric{
+	testutil.MustMetric(
+		"prometheus",
+		map[string]string{"code": "400"},
+		map[string]interface{}{"http_requests_total": 3.0},
+		time.Unix(0, 0),
+		telegraf.Counter,
+	),
+	testutil.MustMetric(
+		"prometheus",
+		map[string]string{},
+		map[string]interface{}{
+			"http_request_duration_seconds_sum":   53423,
+			"http_request_duration_seconds_count": 144320,
+		},
+		time.Unix(0, 0),
+		telegraf.Histogram,
+	),
+	testutil.MustMetric(
+		"cpu",
+		map[string]string{},
+		map[string]interface{}{"time_idle": 42.0},
+		time.Unix(0, 0),
+		telegraf.Gauge,
+	),
+}
+
+func TestRemoteWriteMetadataV1(t *testing.T) {
+	s := &Serializer{
+		Log:           &testutil.CaptureLogger{},
+		SortMetrics:   true,
+		WriteMetadata: true,
+	}
+	require.NoError(t, s.Init())
+
+	data, err := s.SerializeBatch(protocolTestMetrics)
+	require.NoError(t, err)
+	req, err := DecodePayload(data)
+	require.NoError(t, err)
+	require.Len(t, req.Timeseries, 5)
+
+	expected := []prompb.MetricMetadata{
+		{Type: prompb.MetricMetadata_GAUGE, MetricFamilyName: "cpu_time_idle"},
+		{Type: prompb.MetricMetadata_HISTOGRAM, MetricFamilyName: "http_request_duration_seconds"},
+		{Type: prompb.MetricMetadata_COUNTER, MetricFamilyName: "http_requests_total"},
+	}
+	require.Equal(t, expected, req.Metadata)
+}
+
+func TestRemoteWriteMetadataV1Disabled(t *testing.T) {
+	s := &Serializer{Log: &testutil.CaptureLogger{}}
+	require.NoError(t, s.Init())
+
+	data, err := s.SerializeBatch(protocolTestMetrics)
+	require.NoError(t, err)
+	req, err := DecodePayload(data)
+	require.NoError(t, err)
+	require.Len(t, req.Timeseries, 5)
+	require.Empty(t, req.Metadata)
+}
+
+func TestRemoteWriteMetadataSeparateRequest(t *testing.T) {
+	s := &Serializer{
+		Log:                     &testutil.CaptureLogger{},
+		SortMetrics:             true,
+		WriteMetadata:           true,
+		SeparateMetadataRequest: true,
+	}
+	require.NoError(t, s.Init())
+
+	samples, metadata, err := s.SerializeBatchSplit(protocolTestMetrics)
+	require.NoError(t, err)
+
+	req, err := DecodePayload(samples)
+	require.NoError(t, err)
+	require.Len(t, req.Timeseries, 5)
+	require.Empty(t, req.Metadata)
+
+	req, err = DecodePayload(metadata)
+	require.NoError(t, err)
+	require.Empty(t, req.Timeseries)
+	expected := []prompb.MetricMetadata{
+		{Type: prompb.MetricMetadata_GAUGE, MetricFamilyName: "cpu_time_idle"},
+		{Type: prompb.MetricMetadata_HISTOGRAM, MetricFamilyName: "http_request_duration_seconds"},
+		{Type: prompb.MetricMetadata_COUNTER, MetricFamilyName: "http_requests_total"},
+	}
+	require.Equal(t, expected, req.Metadata)
+
+	// The samples payload of the batch serialization must not contain the
+	// metadata either
+	data, err := s.SerializeBatch(protocolTestMetrics)
+	require.NoError(t, err)
+	require.Equal(t, samples, data)
+}
+
+func TestRemoteWriteMetadataSeparateRequestDisabled(t *testing.T) {
+	s := &Serializer{
+		Log:           &testutil.CaptureLogger{},
+		SortMetrics:   true,
+		WriteMetadata: true,
+	}
+	require.NoError(t, s.Init())
+
+	samples, metadata, err := s.SerializeBatchSplit(protocolTestMetrics)
+	require.NoError(t, err)
+	require.Nil(t, metadata)
+
+	req, err := DecodePayload(samples)
+	require.NoError(t, err)
+	require.Len(t, req.Metadata, 3)
+}
+
+func TestRemoteWriteMetadataMaxEntries(t *testing.T) {
+	s := &Serializer{
+		Log:                &testutil.CaptureLogger{},
+		SortMetrics:        true,
+		WriteMetadata:      true,
+		MaxMetadataEntries: 2,
+	}
+	require.NoError(t, s.Init())
+
+	// The histogram family has three series, the counter family has one and
+	// the gauge family has two series.
+	metrics := append([]telegraf.Metric{}, protocolTestMetrics...)
+	metrics = append(metrics, testutil.MustMetric(
+		"cpu",
+		map[string]string{"cpu": "cpu1"},
+		map[string]interface{}{"time_idle": 43.0},
+		time.Unix(0, 0),
+		telegraf.Gauge,
+	))
+
+	data, err := s.SerializeBatch(metrics)
+	require.NoError(t, err)
+	req, err := DecodePayload(data)
+	require.NoError(t, err)
+	require.Len(t, req.Timeseries, 6)
+
+	expected := []prompb.MetricMetadata{
+		{Type: prompb.MetricMetadata_GAUGE, MetricFamilyName: "cpu_time_idle"},
+		{Type: prompb.MetricMetadata_HISTOGRAM, MetricFamilyName: "http_request_duration_seconds"},
+	}
+	require.Equal(t, expected, req.Metadata)
+}
+
+func TestRemoteWriteMetadataMaxEntriesV2(t *testing.T) {
+	s := &Serializer{
+		Log:                &testutil.CaptureLogger{},
+		Protocol:           "2.0",
+		SortMetrics:        true,
+		WriteMetadata:      true,
+		MaxMetadataEntries: 1,
+	}
+	require.NoError(t, s.Init())
+
+	data, err := s.SerializeBatch(protocolTestMetrics)
+	require.NoError(t, err)
+	decompressed, err := snappy.Decode(nil, data)
+	require.NoError(t, err)
+	var req writev2.Request
+	require.NoError(t, req.Unmarshal(decompressed))
+	require.Len(t, req.Timeseries, 5)
+
+	for _, ts := range req.Timeseries {
+		if strings.HasPrefix(seriesIdentifierV2(t, req.Symbols, &ts), "http_request_duration_seconds") {
+			require.Equal(t, writev2.Metadata_METRIC_TYPE_HISTOGRAM, ts.Metadata.Type)
+		} else {
+			require.Equal(t, writev2.Metadata_METRIC_TYPE_UNSPECIFIED, ts.Metadata.Type)
+		}
+	}
+}
+
+func TestRemoteWriteMetadataHelpTag(t *testing.T) {
+	m := testutil.MustMetric(
+		"cpu",
+		map[string]string{"host": "example.org", "_help": "Time spent idle"},
+		map[string]interface{}{"time_idle": 42.0},
+		time.Unix(0, 0),
+		telegraf.Gauge,
+	)
+
+	s := &Serializer{
+		Log:           &testutil.CaptureLogger{},
+		WriteMetadata: true,
+		HelpTag:       "_help",
+	}
+	require.NoError(t, s.Init())
+
+	data, err := s.Serialize(m)
+	require.NoError(t, err)
+	req, err := DecodePayload(data)
+	require.NoError(t, err)
+
+	expected := []prompb.MetricMetadata{
+		{Type: prompb.MetricMetadata_GAUGE, MetricFamilyName: "cpu_time_idle", Help: "Time spent idle"},
+	}
+	require.Equal(t, expected, req.Metadata)
+	require.Equal(t, "cpu_time_idle{host=\"example.org\"} 42\n", RenderText(req))
+}
+
+func TestRemoteWriteMetadataSynthesizeHelp(t *testing.T) {
+	metrics := []telegraf.Metric{
+		testutil.MustMetric(
+			"cpu",
+			map[string]string{"origin": "cpu"},
+			map[string]interface{}{"time_idle": 42.0},
+			time.Unix(0, 0),
+			telegraf.Gauge,
+		),
+		testutil.MustMetric(
+			"mem",
+			map[string]string{"origin": "mem", "_help": "Free memory in bytes"},
+			map[string]interface{}{"free": 1024.0},
+			time.Unix(0, 0),
+			telegraf.Gauge,
+		),
+		testutil.MustMetric(
+			"disk",
+			map[string]string{},
+			map[string]interface{}{"used": 12.0},
+			time.Unix(0, 0),
+			telegraf.Gauge,
+		),
+	}
+
+	s := &Serializer{
+		Log:            &testutil.CaptureLogger{},
+		WriteMetadata:  true,
+		HelpTag:        "_help",
+		SynthesizeHelp: true,
+	}
+	require.NoError(t, s.Init())
+
+	data, err := s.SerializeBatch(metrics)
+	require.NoError(t, err)
+	req, err := DecodePayload(data)
+	require.NoError(t, err)
+
+	actual := make(map[string]string, len(req.Metadata))
+	for _, metadata := range req.Metadata {
+		actual[metadata.MetricFamilyName] = metadata.Help
+	}
+	expected := map[string]string{
+		"cpu_time_idle": "from telegraf input cpu",
+		"mem_free":      "Free memory in bytes",
+		"disk_used":     "",
+	}
+	require.Equal(t, expected, actual)
+}
+
+func TestRemoteWriteMetadataSynthesizeHelpOriginTag(t *testing.T) {
+	m := testutil.MustMetric(
+		"cpu",
+		map[string]string{"plugin": "cpu", "origin": "ignored"},
+		map[string]interface{}{"time_idle": 42.0},
+		time.Unix(0, 0),
+		telegraf.Gauge,
+	)
+
+	s := &Serializer{
+		Log:            &testutil.CaptureLogger{},
+		WriteMetadata:  true,
+		SynthesizeHelp: true,
+		OriginTag:      "plugin",
+	}
+	require.NoError(t, s.Init())
+
+	data, err := s.Serialize(m)
+	require.NoError(t, err)
+	req, err := DecodePayload(data)
+	require.NoError(t, err)
+
+	require.Len(t, req.Metadata, 1)
+	require.Equal(t, "from telegraf input cpu", req.Metadata[0].Help)
+}
+
+func TestRemoteWriteMetadataV2(t *testing.T) {
+	s := &Serializer{
+		Log:           &testutil.CaptureLogger{},
+		Protocol:      "2.0",
+		SortMetrics:   true,
+		WriteMetadata: true,
+	}
+	require.NoError(t, s.Init())
+
+	data, err := s.SerializeBatch(protocolTestMetrics)
+	require.NoError(t, err)
+	req, err := DecodePayloadV2(data)
+	require.NoError(t, err)
+
+	expected := map[string]writev2.Metadata_MetricType{
+		"cpu_time_idle":                                   writev2.Metadata_METRIC_TYPE_GAUGE,
+		`http_requests_total{code="400"}`:                 writev2.Metadata_METRIC_TYPE_COUNTER,
+		"http_request_duration_seconds_count":             writev2.Metadata_METRIC_TYPE_HISTOGRAM,
+		"http_request_duration_seconds_sum":               writev2.Metadata_METRIC_TYPE_HISTOGRAM,
+		`http_request_duration_seconds_bucket{le="+Inf"}`: writev2.Metadata_METRIC_TYPE_HISTOGRAM,
+	}
+	actual := make(map[string]writev2.Metadata_MetricType, len(req.Timeseries))
+	for _, ts := range req.Timeseries {
+		require.Zero(t, ts.Metadata.HelpRef)
+		require.Zero(t, ts.Metadata.UnitRef)
+		require.Len(t, ts.Samples, 1)
+		actual[seriesIdentifierV2(t, req.Symbols, &ts)] = ts.Metadata.Type
+	}
+	require.Equal(t, expected, actual)
+}
+
+func TestRemoteWriteMetadataV2Disabled(t *testing.T) {
+	s := &Serializer{
+		Log:      &testutil.CaptureLogger{},
+		Protocol: "2.0",
+	}
+	require.NoError(t, s.Init())
+
+	data, err := s.SerializeBatch(protocolTestMetrics)
+	require.NoError(t, err)
+	req, err := DecodePayloadV2(data)
+	require.NoError(t, err)
+	require.Len(t, req.Timeseries, 5)
+	for _, ts := range req.Timeseries {
+		require.Equal(t, writev2.Metadata{}, ts.Metadata)
+	}
+}
+
+func TestRemoteWriteSerializeBatchMulti(t *testing.T) {
+	s := &Serializer{
+		Log:           &testutil.CaptureLogger{},
+		SortMetrics:   true,
+		WriteMetadata: true,
+	}
+	require.NoError(t, s.Init())
+
+	payloads, err := s.SerializeBatchMulti(protocolTestMetrics)
+	require.NoError(t, err)
+	require.Len(t, payloads, 2)
+
+	reqV1, err := DecodePayload(payloads["1.0"])
+	require.NoError(t, err)
+	v1 := make(map[string]prompb.Sample, len(reqV1.Timeseries))
+	for _, ts := range reqV1.Timeseries {
+		require.Len(t, ts.Samples, 1)
+		metric := make(model.Metric, len(ts.Labels))
+		for _, l := range ts.Labels {
+			metric[model.LabelName(l.Name)] = model.LabelValue(l.Value)
+		}
+		v1[metric.String()] = ts.Samples[0]
+	}
+	require.Len(t, reqV1.Metadata, 3)
+
+	reqV2, err := DecodePayloadV2(payloads["2.0"])
+	require.NoError(t, err)
+	v2 := make(map[string]prompb.Sample, len(reqV2.Timeseries))
+	for _, ts := range reqV2.Timeseries {
+		require.Len(t, ts.Samples, 1)
+		require.NotEqual(t, writev2.Metadata_METRIC_TYPE_UNSPECIFIED, ts.Metadata.Type)
+		v2[seriesIdentifierV2(t, reqV2.Symbols, &ts)] = prompb.Sample{
+			Value:     ts.Samples[0].Value,
+			Timestamp: ts.Samples[0].Timestamp,
+		}
+	}
+
+	require.Len(t, v1, 5)
+	require.Equal(t, v1, v2)
+}
+
+func TestRemoteWriteSerializeProtocolLabel(t *testing.T) {
+	for _, protocol := range []string{"1.0", "2.0"} {
+		t.Run(protocol, func(t *testing.T) {
+			s := &Serializer{
+				Log:               &testutil.CaptureLogger{},
+				Protocol:          protocol,
+				EmitProtocolLabel: true,
+			}
+			require.NoError(t, s.Init())
+
+			data, err := s.SerializeBatch(protocolTestMetrics)
+			require.NoError(t, err)
+
+			var versions []string
+			if protocol == "2.0" {
+				req, err := DecodePayloadV2(data)
+				require.NoError(t, err)
+				for _, ts := range req.Timeseries {
+					refs := ts.LabelsRefs
+					for i := 0; i+1 < len(refs); i += 2 {
+						if req.Symbols[refs[i]] == "__rw_version__" {
+							versions = append(versions, req.Symbols[refs[i+1]])
+						}
+					}
+				}
+				require.Len(t, versions, len(req.Timeseries))
+			} else {
+				req, err := DecodePayload(data)
+				require.NoError(t, err)
+				for _, ts := range req.Timeseries {
+					if version, found := labelValue(ts.Labels, "__rw_version__"); found {
+						versions = append(versions, version)
+					}
+				}
+				require.Len(t, versions, len(req.Timeseries))
+			}
+			require.NotEmpty(t, versions)
+			for _, version := range versions {
+				require.Equal(t, protocol, version)
+			}
+		})
+	}
+}
+
+func TestRemoteWriteSerializeProtocolLabelDisabled(t *testing.T) {
+	s := &Serializer{Log: &testutil.CaptureLogger{}}
+	require.NoError(t, s.Init())
+
+	data, err := s.SerializeBatch(protocolTestMetrics)
+	require.NoError(t, err)
+	req, err := DecodePayload(data)
+	require.NoError(t, err)
+	for _, ts := range req.Timeseries {
+		require.False(t, hasLabel("__rw_version__", ts.Labels))
+	}
+}
+
+// seriesIdentifierV2 returns a text representation of the series labels
+// resolved from the symbols table.
+func seriesIdentifierV2(t *testing.T, symbols []string, ts *writev2.TimeSeries) string {
+	t.Helper()
+
+	require.Zero(t, len(ts.LabelsRefs)%2)
+	metric := make(model.Metric, len(ts.LabelsRefs)/2)
+	for i := 0; i < len(ts.LabelsRefs); i += 2 {
+		metric[model.LabelName(symbols[ts.LabelsRefs[i]])] = model.LabelValue(symbols[ts.LabelsRefs[i+1]])
+	}
+	return metric.String()
+}
+
+func TestRemoteWriteSerializeBatchWithSize(t *testing.T) {
+	s := &Serializer{Log: &testutil.CaptureLogger{}}
+	require.NoError(t, s.Init())
+
+	data, size, err := s.SerializeBatchWithSize(protocolTestMetrics)
+	require.NoError(t, err)
+
+	decompressed, err := snappy.Decode(nil, data)
+	require.NoError(t, err)
+	require.Equal(t, len(decompressed), size)
+
+	// The uncompressed length matches the marshaled protobuf request
+	req, err := DecodePayload(data)
+	require.NoError(t, err)
+	require.Equal(t, req.Size(), size)
+}
@@ -0,0 +1,101 @@
+package prometheusremotewrite
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/golang/snappy"
+	"github.com/prometheus/prometheus/prompb"
+
+	"github.com/influxdata/telegraf"
+)
+
+// decodeRawSeries decodes the series contained in the given value of the raw
+// series field. The value must be a marshalled remote-write 1.0 request,
+// optionally snappy compressed. The metadata of the request is attached to
+// the series of the corresponding metric family, series of families without
+// metadata are of unknown type with the family being the metric name.
+func decodeRawSeries(raw interface{}) ([]timeSeries, error) {
+	value, ok := raw.(string)
+	if !ok {
+		return nil, fmt.Errorf("unsupported type %T", raw)
+	}
+	data := []byte(value)
+	if decoded, err := snappy.Decode(nil, data); err == nil {
+		data = decoded
+	}
+	var req prompb.WriteRequest
+	if err := req.Unmarshal(data); err != nil {
+		return nil, fmt.Errorf("unable to unmarshal protobuf: %w", err)
+	}
+
+	metadata := make(map[string]prompb.MetricMetadata, len(req.Metadata))
+	for _, m := range req.Metadata {
+		metadata[m.MetricFamilyName] = m
+	}
+
+	series := make([]timeSeries, 0, len(req.Timeseries))
+	for _, ts := range req.Timeseries {
+		name := seriesName(ts.Labels)
+		if name == "" {
+			return nil, fmt.Errorf("series %v without metric name", ts.Labels)
+		}
+		ts.Labels = slices.Clone(ts.Labels)
+		sort.Sort(sortableLabels(ts.Labels))
+
+		m, found := familyOfRawSeries(metadata, name)
+		if !found {
+			m = prompb.MetricMetadata{Type: prompb.MetricMetadata_UNKNOWN, MetricFamilyName: name}
+		}
+		series = append(series, timeSeries{TimeSeries: ts, metadata: m})
+	}
+	return series, nil
+}
+
+// familyOfRawSeries returns the metadata of the family of the series with the
+// given name, taking the suffixes of histogram and summary parts into account.
+func familyOfRawSeries(metadata map[string]prompb.MetricMetadata, name string) (prompb.MetricMetadata, bool) {
+	if m, found := metadata[name]; found {
+		return m, true
+	}
+	for _, suffix := range []string{"_bucket", "_sum", "_count"} {
+		if base, found := strings.CutSuffix(name, suffix); found {
+			if m, found := metadata[base]; found {
+				return m, true
+			}
+		}
+	}
+	return prompb.MetricMetadata{}, false
+}
+
+// convertRawSeries adds the pre-serialized series of the metric to the
+// conversion as they are. It returns false if the metric does not carry raw
+// series and must be converted instead.
+func (s *Serializer) convertRawSeries(c *conversion, metric telegraf.Metric, index int) bool {
+	if s.RawSeriesField == "" {
+		return false
+	}
+	raw, found := metric.GetField(s.RawSeriesField)
+	if !found {
+		return false
+	}
+
+	series, err := decodeRawSeries(raw)
+	if err != nil {
+		s.traceAndKeepErr(c, "failed to decode raw series of metric %q: %w", metric.Name(), err)
+		return true
+	}
+	for _, ts := range series {
+		s.truncateSamples(&ts.TimeSeries)
+		key := MakeMetricKey(ts.Labels)
+		if m, ok := c.entries[key]; ok && sampleTime(&ts.TimeSeries) < sampleTime(&m.TimeSeries) {
+			s.traceAndKeepErr(c, "raw series %q has samples older than already registered before", seriesName(ts.Labels))
+			continue
+		}
+		c.entries[key] = ts
+		c.observeFamily(ts.metadata, index)
+	}
+	return true
+}
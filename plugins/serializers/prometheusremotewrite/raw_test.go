@@ -0,0 +1,159 @@
+package prometheusremotewrite
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/stretchr/testify/require"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/testutil"
+)
+
+func TestRemoteWriteSerializeRawSeries(t *testing.T) {
+	raw := &prompb.WriteRequest{
+		Timeseries: []prompb.TimeSeries{
+			{
+				Labels: []prompb.Label{
+					{Name: "job", Value: "proxy"},
+					{Name: "__name__", Value: "http_requests_total"},
+				},
+				Samples: []prompb.Sample{{Value: 1027, Timestamp: 1000}},
+			},
+			{
+				Labels:  []prompb.Label{{Name: "__name__", Value: "http_request_duration_seconds_sum"}},
+				Samples: []prompb.Sample{{Value: 53423, Timestamp: 1000}},
+			},
+		},
+		Metadata: []prompb.MetricMetadata{
+			{Type: prompb.MetricMetadata_COUNTER, MetricFamilyName: "http_requests_total"},
+			{Type: prompb.MetricMetadata_HISTOGRAM, MetricFamilyName: "http_request_duration_seconds"},
+		},
+	}
+	buf, err := raw.Marshal()
+	require.NoError(t, err)
+
+	for _, compressed := range []bool{false, true} {
+		data := buf
+		if compressed {
+			data = snappy.Encode(nil, buf)
+		}
+		metrics := []telegraf.Metric{
+			testutil.MustMetric(
+				"proxy",
+				map[string]string{"host": "ignored"},
+				map[string]interface{}{"series": string(data), "ignored": 1.0},
+				time.Unix(0, 0),
+			),
+			testutil.MustMetric(
+				"cpu",
+				map[string]string{},
+				map[string]interface{}{"time_idle": 42.0},
+				time.Unix(0, 0),
+				telegraf.Gauge,
+			),
+		}
+
+		s := &Serializer{
+			Log:            &testutil.CaptureLogger{},
+			SortMetrics:    true,
+			WriteMetadata:  true,
+			RawSeriesField: "series",
+		}
+		require.NoError(t, s.Init())
+
+		out, err := s.SerializeBatch(metrics)
+		require.NoError(t, err)
+		req, err := DecodePayload(out)
+		require.NoError(t, err)
+
+		expected := `
+cpu_time_idle 42
+http_request_duration_seconds_sum 53423
+http_requests_total{job="proxy"} 1027
+`
+		require.Equal(t, strings.TrimSpace(expected), strings.TrimSpace(RenderText(req)))
+		require.Equal(t, int64(1000), req.Timeseries[1].Samples[0].Timestamp)
+
+		types := make(map[string]string, len(req.Metadata))
+		for _, metadata := range req.Metadata {
+			types[metadata.MetricFamilyName] = metadata.Type.String()
+		}
+		require.Equal(t, map[string]string{
+			"cpu_time_idle":                 "GAUGE",
+			"http_request_duration_seconds": "HISTOGRAM",
+			"http_requests_total":           "COUNTER",
+		}, types)
+	}
+}
+
+func TestRemoteWriteSerializeRawSeriesTimestampResolution(t *testing.T) {
+	raw := &prompb.WriteRequest{
+		Timeseries: []prompb.TimeSeries{
+			{
+				Labels:  []prompb.Label{{Name: "__name__", Value: "http_requests_total"}},
+				Samples: []prompb.Sample{{Value: 1027, Timestamp: 1999}},
+			},
+		},
+	}
+	buf, err := raw.Marshal()
+	require.NoError(t, err)
+
+	s := &Serializer{
+		Log:                 &testutil.CaptureLogger{},
+		RawSeriesField:      "series",
+		TimestampResolution: "s",
+	}
+	require.NoError(t, s.Init())
+
+	data, err := s.SerializeBatch([]telegraf.Metric{
+		testutil.MustMetric(
+			"proxy",
+			map[string]string{},
+			map[string]interface{}{"series": string(buf)},
+			time.Unix(0, 0),
+		),
+	})
+	require.NoError(t, err)
+	req, err := DecodePayload(data)
+	require.NoError(t, err)
+	require.Len(t, req.Timeseries, 1)
+	require.Equal(t, []prompb.Sample{{Value: 1027, Timestamp: 1000}}, req.Timeseries[0].Samples)
+}
+
+func TestRemoteWriteSerializeRawSeriesInvalid(t *testing.T) {
+	metrics := []telegraf.Metric{
+		testutil.MustMetric(
+			"proxy",
+			map[string]string{},
+			map[string]interface{}{"series": "garbage"},
+			time.Unix(0, 0),
+		),
+		testutil.MustMetric(
+			"cpu",
+			map[string]string{},
+			map[string]interface{}{"time_idle": 42.0},
+			time.Unix(0, 0),
+		),
+	}
+
+	clog := &testutil.CaptureLogger{}
+	s := &Serializer{
+		Log:            clog,
+		RawSeriesField: "series",
+	}
+	require.NoError(t, s.Init())
+
+	out, err := s.SerializeBatch(metrics)
+	require.NoError(t, err)
+	req, err := DecodePayload(out)
+	require.NoError(t, err)
+	require.Equal(t, "cpu_time_idle 42", strings.TrimSpace(RenderText(req)))
+
+	warnings := clog.Warnings()
+	require.Len(t, warnings, 1)
+	require.Contains(t, warnings[0], `failed to decode raw series of metric "proxy"`)
+}
@@ -0,0 +1,29 @@
+package prometheusremotewrite
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// seriesIDLabel is the label carrying the ID of the series
+const seriesIDLabel = "__series_id__"
+
+// seriesID computes a deterministic ID from the given sorted labels
+func seriesID(labels []prompb.Label) string {
+	return fmt.Sprintf("%016x", uint64(MakeMetricKey(labels)))
+}
+
+// addSeriesIDs adds the series ID label computed from the existing labels to
+// all given series keeping the labels sorted. An existing series ID label is
+// replaced.
+func addSeriesIDs(series []timeSeries) {
+	for i := range series {
+		labels := slices.DeleteFunc(slices.Clone(series[i].Labels), func(l prompb.Label) bool { return l.Name == seriesIDLabel })
+		labels = append(labels, prompb.Label{Name: seriesIDLabel, Value: seriesID(labels)})
+		sort.Sort(sortableLabels(labels))
+		series[i].Labels = labels
+	}
+}
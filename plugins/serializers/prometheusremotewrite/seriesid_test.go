@@ -0,0 +1,72 @@
+package prometheusremotewrite
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/testutil"
+)
+
+func TestRemoteWriteSeriesID(t *testing.T) {
+	s := &Serializer{
+		Log:          &testutil.CaptureLogger{},
+		EmitSeriesID: true,
+		EmitWriteID:  true,
+	}
+	require.NoError(t, s.Init())
+
+	metrics := []telegraf.Metric{
+		testutil.MustMetric(
+			"cpu",
+			map[string]string{"host": "a"},
+			map[string]interface{}{"time_idle": 42.0},
+			time.Unix(0, 0),
+		),
+		testutil.MustMetric(
+			"cpu",
+			map[string]string{"host": "b"},
+			map[string]interface{}{"time_idle": 42.0},
+			time.Unix(0, 0),
+		),
+	}
+	first := extractSeriesIDs(t, s, metrics)
+	require.Len(t, first, 2)
+	require.NotEqual(t, first["a"], first["b"])
+
+	// Identical label sets result in the same ID independent of the samples
+	// and the write ID of the batch
+	modified := []telegraf.Metric{
+		testutil.MustMetric(
+			"cpu",
+			map[string]string{"host": "a"},
+			map[string]interface{}{"time_idle": 23.0},
+			time.Unix(10, 0),
+		),
+	}
+	second := extractSeriesIDs(t, s, modified)
+	require.Equal(t, first["a"], second["a"])
+}
+
+// extractSeriesIDs serializes the metrics and returns the series IDs keyed
+// by the host label, requiring every series to carry an ID.
+func extractSeriesIDs(t *testing.T, s *Serializer, metrics []telegraf.Metric) map[string]string {
+	t.Helper()
+
+	data, err := s.SerializeBatch(metrics)
+	require.NoError(t, err)
+	req, err := DecodePayload(data)
+	require.NoError(t, err)
+
+	ids := make(map[string]string)
+	for _, ts := range req.Timeseries {
+		id, found := labelValue(ts.Labels, seriesIDLabel)
+		require.True(t, found, "series %v without series ID", ts.Labels)
+		require.Len(t, id, 16)
+		host, _ := labelValue(ts.Labels, "host")
+		ids[host] = id
+	}
+	return ids
+}
@@ -0,0 +1,80 @@
+package prometheusremotewrite
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// stateUpdate collects the changes to the state kept by the serializer across
+// batches while assembling a batch. The changes are only applied once the
+// assembly completed, so failed or abandoned assemblies leave the state
+// untouched.
+type stateUpdate struct {
+	timestamps map[MetricKey]int64
+	series     map[string]map[MetricKey]bool
+	totals     map[MetricKey]cumulativeTotal
+}
+
+func newStateUpdate() *stateUpdate {
+	return &stateUpdate{
+		timestamps: make(map[MetricKey]int64),
+		series:     make(map[string]map[MetricKey]bool),
+		totals:     make(map[MetricKey]cumulativeTotal),
+	}
+}
+
+// applyState applies the given changes to the state of the serializer
+func (s *Serializer) applyState(update *stateUpdate) {
+	now := time.Now()
+	if s.DedupScope == "serializer" {
+		s.dedup.record(update.timestamps, now)
+	}
+	if s.CardinalityLimit > 0 {
+		s.cardinality.record(update.series, now)
+	}
+	if s.DeltaToCumulative {
+		s.cumulative.record(update.totals)
+	}
+}
+
+// assemblyRun tracks a single assembly which might be abandoned by the
+// watchdog. Abandoning the run cancels its context to stop the conversion
+// early and prevents it from applying its state changes afterwards.
+type assemblyRun struct {
+	ctx     context.Context
+	cancel  context.CancelFunc
+	applied bool
+	sync.Mutex
+}
+
+func newAssemblyRun() *assemblyRun {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &assemblyRun{ctx: ctx, cancel: cancel}
+}
+
+// apply applies the given state changes unless the run was abandoned
+func (r *assemblyRun) apply(s *Serializer, update *stateUpdate) error {
+	r.Lock()
+	defer r.Unlock()
+
+	if err := r.ctx.Err(); err != nil {
+		return err
+	}
+	s.applyState(update)
+	r.applied = true
+	return nil
+}
+
+// abandon cancels the run and returns true unless the run applied its state
+// changes already. In the latter case the run is about to finish.
+func (r *assemblyRun) abandon() bool {
+	r.Lock()
+	defer r.Unlock()
+
+	if r.applied {
+		return false
+	}
+	r.cancel()
+	return true
+}
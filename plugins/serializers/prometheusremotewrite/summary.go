@@ -0,0 +1,203 @@
+package prometheusremotewrite
+
+import (
+	"math"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/prompb"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/plugins/serializers/prometheus"
+)
+
+// checkSummaryCounts checks all summaries in the given series for a sum
+// without a count. Depending on the configured action, all series of those
+// summaries are removed, the sums are degraded to gauges or a zero count is
+// synthesized. The names of the affected summaries are returned sorted
+// together with the number of removed series.
+func (s *Serializer) checkSummaryCounts(entries map[MetricKey]timeSeries) (names []string, removed int) {
+	sums := make(map[MetricKey]timeSeries)
+	counted := make(map[MetricKey]bool)
+	for _, ts := range entries {
+		if ts.metadata.Type != prompb.MetricMetadata_SUMMARY {
+			continue
+		}
+		name := seriesName(ts.Labels)
+		switch {
+		case strings.HasSuffix(name, "_count"):
+			counted[s.summaryKey(ts.Labels, "_count")] = true
+		case strings.HasSuffix(name, "_sum"):
+			sums[s.summaryKey(ts.Labels, "_sum")] = ts
+		}
+	}
+
+	incomplete := make(map[MetricKey]bool)
+	for key, ts := range sums {
+		if counted[key] {
+			continue
+		}
+		incomplete[key] = true
+		names = append(names, strings.TrimSuffix(seriesName(ts.Labels), "_sum"))
+
+		// The sum is meaningless for the summary without the count, but is
+		// kept as a family on its own. The quantiles remain valid.
+		if s.IncompleteSummaryAction == "gauge" {
+			ts.metadata.Type = prompb.MetricMetadata_GAUGE
+			ts.metadata.MetricFamilyName = seriesName(ts.Labels)
+			entries[MakeMetricKey(ts.Labels)] = ts
+		}
+
+		// The synthesized count holds a zero sample for each sample of the
+		// sum, similar to the count created for histograms without one.
+		if s.IncompleteSummaryAction == "synthesize" {
+			labels := slices.Clone(ts.Labels)
+			for i := range labels {
+				if labels[i].Name == model.MetricNameLabel {
+					labels[i].Value = strings.TrimSuffix(labels[i].Value, "_sum") + "_count"
+				}
+			}
+			samples := make([]prompb.Sample, 0, len(ts.Samples))
+			for _, sample := range ts.Samples {
+				samples = append(samples, prompb.Sample{Timestamp: sample.Timestamp})
+			}
+			count := prompb.TimeSeries{Labels: labels, Samples: samples}
+			entries[MakeMetricKey(labels)] = timeSeries{TimeSeries: count, metadata: ts.metadata}
+		}
+	}
+	sort.Strings(names)
+	names = slices.Compact(names)
+	if len(incomplete) == 0 || s.IncompleteSummaryAction != "drop" {
+		return names, 0
+	}
+
+	for key, ts := range entries {
+		if ts.metadata.Type != prompb.MetricMetadata_SUMMARY {
+			continue
+		}
+		name := seriesName(ts.Labels)
+		var suffix string
+		switch {
+		case strings.HasSuffix(name, "_sum"):
+			suffix = "_sum"
+		case strings.HasSuffix(name, "_count"):
+			suffix = "_count"
+		}
+		if incomplete[s.summaryKey(ts.Labels, suffix)] {
+			delete(entries, key)
+			removed++
+		}
+	}
+	return names, removed
+}
+
+// summaryKey identifies the summary of the series with the given labels and
+// suffix by its base name and the labels excluding the quantile label.
+func (s *Serializer) summaryKey(labels []prompb.Label, suffix string) MetricKey {
+	quantile := s.quantileLabel()
+	labels = slices.DeleteFunc(slices.Clone(labels), func(l prompb.Label) bool { return l.Name == quantile })
+	for i := range labels {
+		if labels[i].Name == model.MetricNameLabel {
+			labels[i].Value = strings.TrimSuffix(labels[i].Value, suffix)
+		}
+	}
+	return MakeMetricKey(labels)
+}
+
+// convertSummaryField converts the quantile, sum or count field of a summary
+// metric into a series. Summaries converted to histograms adapt the given
+// metadata to the histogram family. Fields with invalid values are reported
+// as not ok.
+func (s *Serializer) convertSummaryField(
+	c *conversion,
+	metric telegraf.Metric,
+	field *telegraf.Field,
+	metricName string,
+	labels []prompb.Label,
+	timestamp time.Time,
+	metadata *prompb.MetricMetadata,
+) (MetricKey, prompb.TimeSeries, bool) {
+	// All parts of a converted summary belong to the histogram family, not
+	// only the buckets.
+	if s.SummaryToHistogram {
+		metadata.Type = prompb.MetricMetadata_HISTOGRAM
+	}
+
+	switch {
+	case strings.HasSuffix(field.Key, "_sum"):
+		sum, ok := prometheus.SampleSum(field.Value)
+		if !ok {
+			s.traceAndKeepErr(c, "failed to parse %q: bad sample value %#v", metricName, field.Value)
+			return 0, prompb.TimeSeries{}, false
+		}
+
+		metrickey, promts := getPromTS(metricName+"_sum", labels, sum, timestamp)
+		return metrickey, promts, true
+	case strings.HasSuffix(field.Key, "_count"):
+		count, ok := prometheus.SampleCount(field.Value)
+		if !ok {
+			s.traceAndKeepErr(c, "failed to parse %q: bad sample value %#v", metricName, field.Value)
+			return 0, prompb.TimeSeries{}, false
+		}
+
+		// A converted summary requires the +Inf bucket holding all
+		// observations
+		if s.SummaryToHistogram {
+			extraLabel := prompb.Label{
+				Name:  s.bucketLabel(),
+				Value: "+Inf",
+			}
+			metrickeyinf, promtsinf := getPromTS(metricName+"_bucket", labels, float64(count), timestamp, extraLabel)
+			if minf, ok := c.entries[metrickeyinf]; !ok || sampleTime(&minf.TimeSeries) <= sampleTime(&promtsinf) {
+				c.entries[metrickeyinf] = timeSeries{TimeSeries: promtsinf, metadata: *metadata}
+			}
+		}
+
+		metrickey, promts := getPromTS(metricName+"_count", labels, float64(count), timestamp)
+		return metrickey, promts, true
+	}
+
+	quantileTag, ok := metric.GetTag("quantile")
+	if !ok {
+		s.traceAndKeepErr(c, "failed to parse %q: can't find `quantile` label", metricName)
+		return 0, prompb.TimeSeries{}, false
+	}
+	quantile, err := strconv.ParseFloat(quantileTag, 64)
+	if err != nil {
+		s.traceAndKeepErr(c, "failed to parse %q: can't parse %q value: %w", metricName, quantileTag, err)
+		return 0, prompb.TimeSeries{}, false
+	}
+	value, ok := prometheus.SampleValue(field.Value)
+	if !ok {
+		s.traceAndKeepErr(c, "failed to parse %q: bad sample value %#v", metricName, field.Value)
+		return 0, prompb.TimeSeries{}, false
+	}
+
+	if !s.SummaryToHistogram {
+		extraLabel := prompb.Label{
+			Name:  s.quantileLabel(),
+			Value: s.formatBoundary(s.quantileLabel(), quantile),
+		}
+		metrickey, promts := getPromTS(metricName, labels, value, timestamp, extraLabel)
+		return metrickey, promts, true
+	}
+
+	// Interpret the quantile as bucket with the quantile value as upper
+	// boundary. The bucket temporarily holds the quantile as fraction of the
+	// observations, scaled by the count later.
+	if math.IsNaN(value) {
+		s.traceAndKeepErr(c, "failed to convert %q: quantile %v has no value", metricName, quantile)
+		return 0, prompb.TimeSeries{}, false
+	}
+	extraLabel := prompb.Label{
+		Name:  s.bucketLabel(),
+		Value: s.formatBoundary(s.bucketLabel(), value),
+	}
+	metrickey, promts := getPromTS(metricName+"_bucket", labels, quantile, timestamp, extraLabel)
+	c.quantileBuckets[metrickey], _ = getPromTS(metricName+"_count", labels, 0, timestamp)
+	return metrickey, promts, true
+}
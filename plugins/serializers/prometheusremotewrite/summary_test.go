@@ -0,0 +1,144 @@
+package prometheusremotewrite
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/stretchr/testify/require"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/testutil"
+)
+
+func TestRemoteWriteSerializeIncompleteSummary(t *testing.T) {
+	metrics := []telegraf.Metric{
+		testutil.MustMetric(
+			"prometheus",
+			map[string]string{"host": "a", "quantile": "0.5"},
+			map[string]interface{}{"rpc_duration_seconds": 0.2},
+			time.Unix(0, 0),
+			telegraf.Summary,
+		),
+		testutil.MustMetric(
+			"prometheus",
+			map[string]string{"host": "a"},
+			map[string]interface{}{"rpc_duration_seconds_sum": 30.0},
+			time.Unix(0, 0),
+			telegraf.Summary,
+		),
+		testutil.MustMetric(
+			"prometheus",
+			map[string]string{"host": "b", "quantile": "0.5"},
+			map[string]interface{}{"rpc_duration_seconds": 0.1},
+			time.Unix(0, 0),
+			telegraf.Summary,
+		),
+		testutil.MustMetric(
+			"prometheus",
+			map[string]string{"host": "b"},
+			map[string]interface{}{
+				"rpc_duration_seconds_sum":   10.0,
+				"rpc_duration_seconds_count": 5.0,
+			},
+			time.Unix(0, 0),
+			telegraf.Summary,
+		),
+	}
+
+	tests := []struct {
+		action   string
+		expected string
+		types    map[string]prompb.MetricMetadata_MetricType
+		warning  string
+	}{
+		{
+			action: "keep",
+			expected: `
+rpc_duration_seconds_count{host="b"} 5
+rpc_duration_seconds_sum{host="a"} 30
+rpc_duration_seconds_sum{host="b"} 10
+rpc_duration_seconds{host="a", quantile="0.5"} 0.2
+rpc_duration_seconds{host="b", quantile="0.5"} 0.1
+`,
+			types: map[string]prompb.MetricMetadata_MetricType{
+				"rpc_duration_seconds": prompb.MetricMetadata_SUMMARY,
+			},
+		},
+		{
+			action: "drop",
+			expected: `
+rpc_duration_seconds_count{host="b"} 5
+rpc_duration_seconds_sum{host="b"} 10
+rpc_duration_seconds{host="b", quantile="0.5"} 0.1
+`,
+			types: map[string]prompb.MetricMetadata_MetricType{
+				"rpc_duration_seconds": prompb.MetricMetadata_SUMMARY,
+			},
+			warning: `dropped summaries ["rpc_duration_seconds"] without count`,
+		},
+		{
+			action: "gauge",
+			expected: `
+rpc_duration_seconds_count{host="b"} 5
+rpc_duration_seconds_sum{host="a"} 30
+rpc_duration_seconds_sum{host="b"} 10
+rpc_duration_seconds{host="a", quantile="0.5"} 0.2
+rpc_duration_seconds{host="b", quantile="0.5"} 0.1
+`,
+			types: map[string]prompb.MetricMetadata_MetricType{
+				"rpc_duration_seconds":     prompb.MetricMetadata_SUMMARY,
+				"rpc_duration_seconds_sum": prompb.MetricMetadata_GAUGE,
+			},
+			warning: `degraded sums of summaries ["rpc_duration_seconds"] without count to gauges`,
+		},
+		{
+			action: "synthesize",
+			expected: `
+rpc_duration_seconds_count{host="a"} 0
+rpc_duration_seconds_count{host="b"} 5
+rpc_duration_seconds_sum{host="a"} 30
+rpc_duration_seconds_sum{host="b"} 10
+rpc_duration_seconds{host="a", quantile="0.5"} 0.2
+rpc_duration_seconds{host="b", quantile="0.5"} 0.1
+`,
+			types: map[string]prompb.MetricMetadata_MetricType{
+				"rpc_duration_seconds": prompb.MetricMetadata_SUMMARY,
+			},
+			warning: `synthesized zero counts for summaries ["rpc_duration_seconds"] without count`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.action, func(t *testing.T) {
+			clog := &testutil.CaptureLogger{}
+			s := &Serializer{
+				Log:                     clog,
+				SortMetrics:             true,
+				WriteMetadata:           true,
+				IncompleteSummaryAction: tt.action,
+			}
+			require.NoError(t, s.Init())
+
+			data, err := s.SerializeBatch(metrics)
+			require.NoError(t, err)
+			req, err := DecodePayload(data)
+			require.NoError(t, err)
+			require.Equal(t, strings.TrimSpace(tt.expected), strings.TrimSpace(RenderText(req)))
+
+			types := make(map[string]prompb.MetricMetadata_MetricType, len(req.Metadata))
+			for _, m := range req.Metadata {
+				types[m.MetricFamilyName] = m.Type
+			}
+			require.Equal(t, tt.types, types)
+
+			warnings := clog.Warnings()
+			if tt.warning == "" {
+				require.Empty(t, warnings)
+				return
+			}
+			require.Len(t, warnings, 1)
+			require.Contains(t, warnings[0], tt.warning)
+		})
+	}
+}
@@ -0,0 +1,115 @@
+package prometheusremotewrite
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// labelValueEscaper escapes label values according to the OpenMetrics
+// specification and the text exposition format
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// TextOptions controls the rendering of remote-write requests as text
+type TextOptions struct {
+	// OpenMetrics renders the series using the OpenMetrics escaping rules and
+	// terminates the output with "# EOF" to be consumable by OpenMetrics
+	// parsers.
+	OpenMetrics bool
+}
+
+// RenderText renders the samples of the given remote-write request as text
+// with one sample per line in the order of the series in the request. Values
+// are formatted as by Prometheus which never uses scientific notation.
+func RenderText(req *prompb.WriteRequest) string {
+	return RenderTextWithOptions(req, TextOptions{})
+}
+
+// RenderTextWithOptions renders the samples of the given remote-write request
+// like RenderText using the given options.
+func RenderTextWithOptions(req *prompb.WriteRequest, options TextOptions) string {
+	var buf strings.Builder
+	for _, sample := range requestToSamples(req) {
+		if options.OpenMetrics {
+			writeOpenMetricsSeries(&buf, sample.Metric)
+		} else {
+			buf.WriteString(sample.Metric.String())
+		}
+		buf.WriteString(" ")
+		buf.WriteString(sample.Value.String())
+		buf.WriteString("\n")
+	}
+	if options.OpenMetrics {
+		buf.WriteString("# EOF\n")
+	}
+	return buf.String()
+}
+
+// writeOpenMetricsSeries writes the metric name and the sorted labels of the
+// series in OpenMetrics notation.
+func writeOpenMetricsSeries(buf *strings.Builder, metric model.Metric) {
+	buf.WriteString(string(metric[model.MetricNameLabel]))
+
+	names := make([]string, 0, len(metric))
+	for name := range metric {
+		if name != model.MetricNameLabel {
+			names = append(names, string(name))
+		}
+	}
+	if len(names) == 0 {
+		return
+	}
+	sort.Strings(names)
+
+	buf.WriteString("{")
+	for i, name := range names {
+		if i > 0 {
+			buf.WriteString(",")
+		}
+		buf.WriteString(name)
+		buf.WriteString(`="`)
+		buf.WriteString(labelValueEscaper.Replace(string(metric[model.LabelName(name)])))
+		buf.WriteString(`"`)
+	}
+	buf.WriteString("}")
+}
+
+// formatValue formats the sample value as plain decimal without scientific
+// notation for readability. Integral values are formatted without a decimal
+// point. Special values are formatted as in the Prometheus text exposition
+// format.
+func formatValue(v model.SampleValue) string {
+	f := float64(v)
+	switch {
+	case math.IsNaN(f):
+		return "NaN"
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+func requestToSamples(req *prompb.WriteRequest) model.Samples {
+	var samples model.Samples
+	for _, ts := range req.Timeseries {
+		metric := make(model.Metric, len(ts.Labels))
+		for _, l := range ts.Labels {
+			metric[model.LabelName(l.Name)] = model.LabelValue(l.Value)
+		}
+
+		for _, s := range ts.Samples {
+			samples = append(samples, &model.Sample{
+				Metric:    metric,
+				Value:     model.SampleValue(s.Value),
+				Timestamp: model.Time(s.Timestamp),
+			})
+		}
+	}
+	return samples
+}
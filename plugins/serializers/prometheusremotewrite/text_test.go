@@ -0,0 +1,135 @@
+package prometheusremotewrite
+
+import (
+	"errors"
+	"io"
+	"math"
+	"testing"
+
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/textparse"
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRemoteWriteRenderText(t *testing.T) {
+	req := &prompb.WriteRequest{
+		Timeseries: []prompb.TimeSeries{
+			{
+				Labels: []prompb.Label{
+					{Name: "__name__", Value: "cpu_time_idle"},
+					{Name: "host", Value: "example.org"},
+				},
+				Samples: []prompb.Sample{{Value: 42.0}},
+			},
+			{
+				Labels: []prompb.Label{
+					{Name: "__name__", Value: "http_request_duration_seconds_bucket"},
+					{Name: "le", Value: "0.5"},
+				},
+				Samples: []prompb.Sample{{Value: 129389}, {Value: 129390, Timestamp: 1000}},
+			},
+			{
+				Labels: []prompb.Label{
+					{Name: "__name__", Value: "no_samples"},
+				},
+			},
+		},
+	}
+
+	expected := `cpu_time_idle{host="example.org"} 42
+http_request_duration_seconds_bucket{le="0.5"} 129389
+http_request_duration_seconds_bucket{le="0.5"} 129390
+`
+	require.Equal(t, expected, RenderText(req))
+}
+
+func TestRemoteWriteRenderTextEmpty(t *testing.T) {
+	require.Empty(t, RenderText(&prompb.WriteRequest{}))
+}
+
+func TestRemoteWriteRenderTextNoScientificNotation(t *testing.T) {
+	values := []float64{1.5e+21, 1e-07, 0.25, math.Inf(1), math.Inf(-1), math.NaN()}
+	req := &prompb.WriteRequest{}
+	for _, v := range values {
+		req.Timeseries = append(req.Timeseries, prompb.TimeSeries{
+			Labels:  []prompb.Label{{Name: "__name__", Value: "value"}},
+			Samples: []prompb.Sample{{Value: v}},
+		})
+	}
+
+	expected := `value 1500000000000000000000
+value 0.0000001
+value 0.25
+value +Inf
+value -Inf
+value NaN
+`
+	require.Equal(t, expected, RenderText(req))
+}
+
+func TestRemoteWriteRenderTextIntegralValues(t *testing.T) {
+	values := []float64{42.0, -3.0, 0.0, 1e6, 2.5, 0.1, -0.75}
+	req := &prompb.WriteRequest{}
+	for _, v := range values {
+		req.Timeseries = append(req.Timeseries, prompb.TimeSeries{
+			Labels:  []prompb.Label{{Name: "__name__", Value: "value"}},
+			Samples: []prompb.Sample{{Value: v}},
+		})
+	}
+
+	expected := `value 42
+value -3
+value 0
+value 1000000
+value 2.5
+value 0.1
+value -0.75
+`
+	require.Equal(t, expected, RenderText(req))
+}
+
+func TestRemoteWriteRenderTextOpenMetrics(t *testing.T) {
+	req := &prompb.WriteRequest{
+		Timeseries: []prompb.TimeSeries{
+			{
+				Labels: []prompb.Label{
+					{Name: "__name__", Value: "cpu_time_idle"},
+					{Name: "host", Value: "example.org"},
+					{Name: "path", Value: "C:\\Temp \"new\"\nline"},
+				},
+				Samples: []prompb.Sample{{Value: 42.0}},
+			},
+			{
+				Labels:  []prompb.Label{{Name: "__name__", Value: "up"}},
+				Samples: []prompb.Sample{{Value: math.Inf(1)}},
+			},
+		},
+	}
+
+	expected := `cpu_time_idle{host="example.org",path="C:\\Temp \"new\"\nline"} 42
+up +Inf
+# EOF
+`
+	actual := RenderTextWithOptions(req, TextOptions{OpenMetrics: true})
+	require.Equal(t, expected, actual)
+
+	parser := textparse.NewOpenMetricsParser([]byte(actual), labels.NewSymbolTable())
+	var parsed []labels.Labels
+	for {
+		entry, err := parser.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		require.NoError(t, err)
+		require.Equal(t, textparse.EntrySeries, entry)
+
+		var lbls labels.Labels
+		parser.Metric(&lbls)
+		parsed = append(parsed, lbls)
+	}
+	require.Equal(t, []labels.Labels{
+		labels.FromStrings("__name__", "cpu_time_idle", "host", "example.org", "path", "C:\\Temp \"new\"\nline"),
+		labels.FromStrings("__name__", "up"),
+	}, parsed)
+}
@@ -0,0 +1,181 @@
+package prometheusremotewrite
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/prometheus/prometheus/prompb"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/internal"
+)
+
+// metricTimestamp returns the timestamp of the metric according to the
+// timestamp options. For event-style metrics, the field holding the value of
+// the event is returned alongside. Metrics to be dropped are reported as not
+// ok.
+func (s *Serializer) metricTimestamp(c *conversion, metric telegraf.Metric, now time.Time) (time.Time, string, bool, error) {
+	// Use the timestamp of the event carried in the field if any and
+	// restrict the samples to the field holding the value of the event.
+	metricTime := metric.Time()
+	var valueSource string
+	if s.TimestampValueField != "" {
+		if raw, found := metric.GetField(s.TimestampValueField); found {
+			t, err := internal.ParseTimestamp("unix_ns", raw, time.UTC)
+			if err != nil {
+				s.traceAndKeepErr(c, "failed to parse timestamp %v of field %q: %w", raw, s.TimestampValueField, err)
+				return time.Time{}, "", false, nil
+			}
+			metricTime = t
+			valueSource = s.TimestampValueSource
+		}
+	}
+	if valueSource != "" && !metric.HasField(valueSource) {
+		s.traceAndKeepErr(c, "metric %q has no value field %q", metric.Name(), valueSource)
+		return time.Time{}, "", false, nil
+	}
+	if metricTime.IsZero() {
+		switch s.MissingTimestampPolicy {
+		case "keep":
+		case "drop":
+			s.traceAndKeepErr(c, "metric %q has no timestamp", metric.Name())
+			return time.Time{}, "", false, nil
+		case "error":
+			return time.Time{}, "", false, fmt.Errorf("metric %q has no timestamp", metric.Name())
+		default:
+			metricTime = now
+		}
+	}
+	if s.RejectZeroTimestamp && metricTime.Before(zeroTimestampFloor) {
+		if s.ZeroTimestampAction != "now" {
+			s.traceAndKeepErr(c, "metric %q has zero timestamp %v", metric.Name(), metricTime)
+			return time.Time{}, "", false, nil
+		}
+		c.substitutedTimestamps++
+		metricTime = now
+	}
+
+	// Receivers reject samples too far in the future, e.g. due to clock
+	// skew of the source.
+	if s.MaxFutureSkew > 0 && metricTime.After(now.Add(time.Duration(s.MaxFutureSkew))) {
+		if s.FutureTimestampAction != "clamp" {
+			s.traceAndKeepErr(c, "metric %q has timestamp %v too far in the future", metric.Name(), metricTime)
+			return time.Time{}, "", false, nil
+		}
+		c.clampedTimestamps++
+		metricTime = now
+	}
+	return s.truncateTimestamp(metricTime), valueSource, true, nil
+}
+
+// fieldTimestamp returns the timestamp of the given field, i.e. the timestamp
+// of the companion field if any or the given metric timestamp otherwise.
+// Fields with an invalid companion timestamp are reported as not ok.
+func (s *Serializer) fieldTimestamp(c *conversion, metric telegraf.Metric, key string, metricTime time.Time) (time.Time, bool) {
+	if s.FieldTimestampSuffix == "" {
+		return metricTime, true
+	}
+	raw, found := metric.GetField(key + s.FieldTimestampSuffix)
+	if !found {
+		return metricTime, true
+	}
+	t, err := internal.ParseTimestamp(s.timestampFormat(), raw, time.UTC)
+	if err != nil {
+		s.traceAndKeepErr(c, "failed to parse timestamp %v of field %q: %w", raw, key, err)
+		return time.Time{}, false
+	}
+	return s.truncateTimestamp(t), true
+}
+
+// zeroTimestampFloor is the earliest timestamp not considered to be an
+// erroneous (near) zero timestamp.
+var zeroTimestampFloor = time.Unix(24*60*60, 0)
+
+// truncateTimestamp truncates the given time to the configured resolution of
+// sample timestamps. Truncation happens before deduplication, so samples
+// falling into the same second replace each other.
+func (s *Serializer) truncateTimestamp(t time.Time) time.Time {
+	if s.TimestampResolution == "s" {
+		return t.Truncate(time.Second)
+	}
+	return t
+}
+
+// truncateSamples truncates the timestamps of the samples and exemplars of the
+// given series to the configured resolution.
+func (s *Serializer) truncateSamples(ts *prompb.TimeSeries) {
+	if s.TimestampResolution != "s" {
+		return
+	}
+	for i := range ts.Samples {
+		ts.Samples[i].Timestamp = truncateMillis(ts.Samples[i].Timestamp)
+	}
+	for i := range ts.Exemplars {
+		ts.Exemplars[i].Timestamp = truncateMillis(ts.Exemplars[i].Timestamp)
+	}
+}
+
+// truncateMillis truncates the given timestamp in milliseconds to seconds,
+// rounding towards negative infinity like time.Truncate.
+func truncateMillis(ms int64) int64 {
+	remainder := ms % 1000
+	if remainder < 0 {
+		remainder += 1000
+	}
+	return ms - remainder
+}
+
+// sampleTime returns the timestamp of the first sample of the series or, for
+// series carrying exemplars only, the timestamp of the first exemplar.
+func sampleTime(ts *prompb.TimeSeries) int64 {
+	if len(ts.Samples) > 0 {
+		return ts.Samples[0].Timestamp
+	}
+	if len(ts.Exemplars) > 0 {
+		return ts.Exemplars[0].Timestamp
+	}
+	return 0
+}
+
+// isFieldTimestamp returns true if the field is holding the timestamp of
+// another field or of all fields of the metric.
+func (s *Serializer) isFieldTimestamp(metric telegraf.Metric, key string) bool {
+	if s.TimestampValueField != "" && key == s.TimestampValueField {
+		return true
+	}
+	if s.FieldTimestampSuffix == "" || len(key) <= len(s.FieldTimestampSuffix) {
+		return false
+	}
+	base, found := strings.CutSuffix(key, s.FieldTimestampSuffix)
+	return found && metric.HasField(base)
+}
+
+// timestampFormat returns the format of field timestamps defaulting to unix
+// seconds
+func (s *Serializer) timestampFormat() string {
+	if s.FieldTimestampFormat == "" {
+		return "unix"
+	}
+	return s.FieldTimestampFormat
+}
+
+// orderByTimestamp sorts the samples of each series by their timestamp and
+// then the series by the timestamp of their oldest sample. Series with the
+// same timestamp keep their relative order, e.g. the label order of sorted
+// series or the order of buckets.
+func orderByTimestamp(series []timeSeries) {
+	for i := range series {
+		samples := series[i].Samples
+		if !sort.SliceIsSorted(samples, func(a, b int) bool { return samples[a].Timestamp < samples[b].Timestamp }) {
+			samples = slices.Clone(samples)
+			sort.SliceStable(samples, func(a, b int) bool { return samples[a].Timestamp < samples[b].Timestamp })
+			series[i].Samples = samples
+		}
+	}
+	sort.SliceStable(series, func(i, j int) bool {
+		return sampleTime(&series[i].TimeSeries) < sampleTime(&series[j].TimeSeries)
+	})
+}
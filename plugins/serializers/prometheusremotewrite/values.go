@@ -0,0 +1,114 @@
+package prometheusremotewrite
+
+import (
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/prometheus/prompb"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/plugins/serializers/prometheus"
+)
+
+// convertValue converts the field of a counter, gauge or untyped metric into
+// a series applying the value options. For fields emitted as counter and
+// gauge, the counter series is added to the conversion directly and the
+// given metadata is adapted to the gauge series returned. Fields with
+// invalid values are reported as not ok.
+func (s *Serializer) convertValue(
+	c *conversion,
+	metric telegraf.Metric,
+	field *telegraf.Field,
+	metricName string,
+	labels []prompb.Label,
+	exponent int,
+	timestamp time.Time,
+	metadata *prompb.MetricMetadata,
+	index int,
+) (MetricKey, prompb.TimeSeries, bool) {
+	value, ok := prometheus.SampleValue(field.Value)
+	if !ok {
+		s.traceAndKeepErr(c, "failed to parse %q: bad sample value %#v", metricName, field.Value)
+		return 0, prompb.TimeSeries{}, false
+	}
+	if exponent > 0 {
+		value *= math.Pow10(exponent)
+	} else if exponent < 0 {
+		value /= math.Pow10(-exponent)
+	}
+	if multiplier, found := s.valueMultiplier(field.Key, metricName); found {
+		value *= multiplier
+	}
+	if s.valueTransform != nil {
+		value = s.valueTransform(value)
+	}
+	metrickey, promts := getPromTS(metricName, labels, value, timestamp)
+	if s.DeltaToCumulative && metric.Type() == telegraf.Counter {
+		promts.Samples[0].Value = c.accumulate(&s.cumulative, metrickey, value, promts.Samples[0].Timestamp)
+	}
+	if s.ClampNegativeCounters && metric.Type() == telegraf.Counter && promts.Samples[0].Value < 0 {
+		c.clampedCounters[metricName] = true
+		promts.Samples[0].Value = 0
+	}
+	if s.ValuePrecision > 0 {
+		promts.Samples[0].Value = roundSignificant(promts.Samples[0].Value, s.ValuePrecision)
+	}
+	if !slices.Contains(s.DualEmitFields, field.Key) {
+		return metrickey, promts, true
+	}
+
+	// Emit the value as counter and as gauge for consumers expecting either
+	// of them.
+	base := strings.TrimSuffix(metricName, "_total")
+	totalkey, totalts := getPromTS(base+"_total", labels, promts.Samples[0].Value, timestamp)
+	if m, ok := c.entries[totalkey]; !ok || sampleTime(&m.TimeSeries) <= sampleTime(&totalts) {
+		totalmetadata := prompb.MetricMetadata{
+			Type:             prompb.MetricMetadata_COUNTER,
+			MetricFamilyName: base + "_total",
+			Help:             metadata.Help,
+		}
+		c.entries[totalkey] = timeSeries{TimeSeries: totalts, metadata: totalmetadata}
+		c.observeFamily(totalmetadata, index)
+	}
+	metadata.Type = prompb.MetricMetadata_GAUGE
+	metadata.MetricFamilyName = base
+	metrickey, promts = getPromTS(base, labels, promts.Samples[0].Value, timestamp)
+	return metrickey, promts, true
+}
+
+// roundSignificant rounds the given value to the given number of significant
+// digits. Zero and non-finite values are returned unchanged.
+func roundSignificant(value float64, digits int) float64 {
+	if value == 0 || math.IsNaN(value) || math.IsInf(value, 0) {
+		return value
+	}
+	rounded, err := strconv.ParseFloat(strconv.FormatFloat(value, 'g', digits, 64), 64)
+	if err != nil {
+		return value
+	}
+	return rounded
+}
+
+// valueMultiplier returns the multiplier configured for the given field or,
+// if none is configured for the field, for the metric family.
+func (s *Serializer) valueMultiplier(field, family string) (float64, bool) {
+	if multiplier, found := s.ValueMultipliers[field]; found {
+		return multiplier, true
+	}
+	multiplier, found := s.ValueMultipliers[family]
+	return multiplier, found
+}
+
+// parseStringNumber returns the numeric value of string field values if
+// parsing string numbers is enabled.
+func (s *Serializer) parseStringNumber(value interface{}) (float64, bool) {
+	v, ok := value.(string)
+	if !ok || !s.ParseStringNumbers {
+		return 0, false
+	}
+	number, err := strconv.ParseFloat(v, 64)
+	return number, err == nil
+}
@@ -0,0 +1,54 @@
+package prometheusremotewrite
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"slices"
+	"sort"
+)
+
+// writeIDLabel is the label carrying the ID of the write request
+const writeIDLabel = "__write_id__"
+
+// writeID computes a deterministic ID from the content of the given series
+// independent of the order of the series.
+func writeID(series []timeSeries) string {
+	hashes := make([]uint64, 0, len(series))
+	buf := make([]byte, 0, 24)
+	for _, ts := range series {
+		h := fnv.New64a()
+		buf = binary.BigEndian.AppendUint64(buf[:0], uint64(MakeMetricKey(ts.Labels)))
+		h.Write(buf)
+		for _, sample := range ts.Samples {
+			buf = binary.BigEndian.AppendUint64(buf[:0], math.Float64bits(sample.Value))
+			buf = binary.BigEndian.AppendUint64(buf, uint64(sample.Timestamp))
+			h.Write(buf)
+		}
+		for _, exemplar := range ts.Exemplars {
+			buf = binary.BigEndian.AppendUint64(buf[:0], uint64(MakeMetricKey(exemplar.Labels)))
+			buf = binary.BigEndian.AppendUint64(buf, math.Float64bits(exemplar.Value))
+			buf = binary.BigEndian.AppendUint64(buf, uint64(exemplar.Timestamp))
+			h.Write(buf)
+		}
+		hashes = append(hashes, h.Sum64())
+	}
+	slices.Sort(hashes)
+
+	h := fnv.New64a()
+	for _, hash := range hashes {
+		h.Write(binary.BigEndian.AppendUint64(buf[:0], hash))
+	}
+	return fmt.Sprintf("%016x", h.Sum64())
+}
+
+// addWriteID adds the write ID label to all given series keeping the labels
+// sorted. An existing write ID label is replaced.
+func addWriteID(series []timeSeries, id string) {
+	for i := range series {
+		labels := replaceLabel(series[i].Labels, writeIDLabel, id)
+		sort.Sort(sortableLabels(labels))
+		series[i].Labels = labels
+	}
+}
@@ -0,0 +1,59 @@
+package prometheusremotewrite
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/testutil"
+)
+
+func TestRemoteWriteWriteID(t *testing.T) {
+	s := &Serializer{
+		Log:           &testutil.CaptureLogger{},
+		EmitWriteID:   true,
+		EmitHeartbeat: true,
+	}
+	require.NoError(t, s.Init())
+
+	first := extractWriteIDs(t, s, protocolTestMetrics)
+	require.Len(t, first, 1)
+
+	// Identical batches result in the same ID independent of the order
+	reversed := []telegraf.Metric{protocolTestMetrics[2], protocolTestMetrics[1], protocolTestMetrics[0]}
+	require.Equal(t, first, extractWriteIDs(t, s, reversed))
+
+	// Different content results in a different ID
+	modified := append([]telegraf.Metric{}, protocolTestMetrics...)
+	modified[2] = testutil.MustMetric(
+		"cpu",
+		map[string]string{},
+		map[string]interface{}{"time_idle": 43.0},
+		time.Unix(0, 0),
+		telegraf.Gauge,
+	)
+	second := extractWriteIDs(t, s, modified)
+	require.Len(t, second, 1)
+	require.NotEqual(t, first, second)
+}
+
+// extractWriteIDs serializes the metrics and returns the set of write IDs
+// found in the series, requiring every series to carry an ID.
+func extractWriteIDs(t *testing.T, s *Serializer, metrics []telegraf.Metric) map[string]bool {
+	t.Helper()
+
+	data, err := s.SerializeBatch(metrics)
+	require.NoError(t, err)
+	req, err := DecodePayload(data)
+	require.NoError(t, err)
+
+	ids := make(map[string]bool)
+	for _, ts := range req.Timeseries {
+		id, found := labelValue(ts.Labels, writeIDLabel)
+		require.True(t, found, "series %v without write ID", ts.Labels)
+		ids[id] = true
+	}
+	return ids
+}